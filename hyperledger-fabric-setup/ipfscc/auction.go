@@ -0,0 +1,580 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	AuctionTypeEnglish = "english"
+	AuctionTypeDutch   = "dutch"
+
+	AuctionStatusOpen      = "open"
+	AuctionStatusSettled   = "settled"
+	AuctionStatusClosed    = "closed"
+	AuctionStatusCancelled = "cancelled"
+	AuctionStatusUnsold    = "unsold"
+)
+
+// DataAuction is an auction on a DataAsset.
+// In an english auction bids are placed with BidForData while the auction is open, and the auction is settled
+// with CloseAuction once EndTime has passed. In a dutch auction the price starts at StartPrice and drops by
+// Decrement every IntervalSeconds until it reaches FloorPrice, the first org to call BuyAtCurrentPrice wins.
+// The owner can call CancelAuction on an open auction before anyone bid. An english auction whose winning bid can
+// no longer be accepted when it closes, say because the winner's org was frozen, ends unsold with UnsoldReason.
+type DataAuction struct {
+	AuctionType     string      `json:"auctionType"`
+	DeviceName      string      `json:"deviceName"`
+	Date            string      `json:"date"`
+	OwnerOrg        string      `json:"ownerOrg"`
+	ReservePrice    int64       `json:"reservePrice"`
+	MinIncrement    int64       `json:"minIncrement"`
+	EndTime         string      `json:"endTime"`
+	StartTime       string      `json:"startTime"`
+	StartPrice      int64       `json:"startPrice"`
+	FloorPrice      int64       `json:"floorPrice"`
+	Decrement       int64       `json:"decrement"`
+	IntervalSeconds int64       `json:"intervalSeconds"`
+	HighestBidder   string      `json:"highestBidder"`
+	HighestPrice    int64       `json:"highestPrice"`
+	Terms           *UsageTerms `json:"terms"`
+	Status          string      `json:"status"`
+	UnsoldReason    string      `json:"unsoldReason,omitempty"`
+	// SaleEpoch is the sale epoch of the asset the auction started, every bid in the auction is placed in it.
+	SaleEpoch int `json:"saleEpoch"`
+}
+
+func CreateAuctionID(deviceName string, date string) string {
+	return "auction_" + deviceName + "_" + date
+}
+
+// parsePrice converts a bid price into an amount of the smallest currency unit.
+func parsePrice(price string) (int64, error) {
+	amount, err := strconv.ParseInt(price, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("price %q is not a whole number: %v", price, err)
+	}
+	if amount < 0 {
+		return 0, fmt.Errorf("price %q must not be negative", price)
+	}
+	return amount, nil
+}
+
+// parseOfferPrice converts the price offered by a bid or a licence request, which must be positive.
+func parseOfferPrice(price string) (int64, error) {
+	amount, err := parsePrice(price)
+	if err != nil {
+		return 0, err
+	}
+	if amount == 0 {
+		return 0, fmt.Errorf("price %q must be positive", price)
+	}
+	return amount, nil
+}
+
+func (s *SmartContract) getAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string) (*DataAuction, error) {
+	auctionBytes, err := ctx.GetStub().GetState(CreateAuctionID(deviceName, date))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting auction: %v", err)
+	}
+	if auctionBytes == nil {
+		return nil, nil
+	}
+
+	var auction DataAuction
+	err = json.Unmarshal(auctionBytes, &auction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auction JSON: %v", err)
+	}
+	return &auction, nil
+}
+
+func (s *SmartContract) putAuction(ctx contractapi.TransactionContextInterface, auction *DataAuction) error {
+	auctionBytes, err := json.Marshal(auction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auction to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateAuctionID(auction.DeviceName, auction.Date), auctionBytes)
+}
+
+// GetAuction returns the latest auction started for the asset of deviceName on date.
+func (s *SmartContract) GetAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string) (*DataAuction, error) {
+	auction, err := s.getAuction(ctx, deviceName, date)
+	if err != nil {
+		return nil, err
+	}
+	if auction == nil {
+		return nil, fmt.Errorf("no auction found for asset %s", CreateAssetID(deviceName, date))
+	}
+	return auction, nil
+}
+
+// endBidding moves the sale epoch of the asset of deviceName on date on, which leaves every bid placed on it
+// so far stale without having to read or rewrite the bids, and returns the new epoch.
+func (s *SmartContract) endBidding(ctx contractapi.TransactionContextInterface, deviceName string, date string) (int, error) {
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return 0, err
+	}
+	asset.SaleEpoch++
+	err = s.putDataAsset(ctx, asset)
+	if err != nil {
+		return 0, err
+	}
+	return asset.SaleEpoch, nil
+}
+
+// checkCanStartAuction returns the calling org if it owns the asset and no auction is open for it yet.
+func (s *SmartContract) checkCanStartAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string) (string, error) {
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Asset Owner %v", err)
+	}
+	if asset.OwnerOrg != mspid {
+		return "", fmt.Errorf("only the owner of asset %s can auction it", CreateAssetID(deviceName, date))
+	}
+	err = checkResaleAllowed(asset, mspid)
+	if err != nil {
+		return "", err
+	}
+	err = checkNotLocked(asset)
+	if err != nil {
+		return "", err
+	}
+	err = checkNotShredded(asset)
+	if err != nil {
+		return "", err
+	}
+
+	existingAuction, err := s.getAuction(ctx, deviceName, date)
+	if err != nil {
+		return "", err
+	}
+	if existingAuction != nil && existingAuction.Status == AuctionStatusOpen {
+		return "", fmt.Errorf("an auction is already open for asset %s", CreateAssetID(deviceName, date))
+	}
+	return mspid, nil
+}
+
+// StartEnglishAuction opens an ascending auction on an asset owned by the calling org.
+// endTime is an RFC3339 timestamp, prices are whole numbers of the smallest currency unit.
+// usageTerms are the JSON encoded UsageTerms every bid in the auction has to agree to.
+func (s *SmartContract) StartEnglishAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string, reservePrice string, minIncrement string, endTime string, usageTerms string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := s.checkCanStartAuction(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	terms, err := ParseUsageTerms(usageTerms)
+	if err != nil {
+		return err
+	}
+	err = s.checkPurpose(ctx, terms)
+	if err != nil {
+		return err
+	}
+	err = s.checkConsent(ctx, deviceName, "", terms)
+	if err != nil {
+		return err
+	}
+
+	reserve, err := parsePrice(reservePrice)
+	if err != nil {
+		return fmt.Errorf("invalid reserve price: %v", err)
+	}
+	increment, err := parsePrice(minIncrement)
+	if err != nil {
+		return fmt.Errorf("invalid minimum increment: %v", err)
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if increment == 0 || increment < config.MinBidIncrement {
+		return fmt.Errorf("minimum increment must be greater than zero and at least %d", config.MinBidIncrement)
+	}
+
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return fmt.Errorf("end time must be an RFC3339 timestamp: %v", err)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !end.After(now) {
+		return fmt.Errorf("auction end time %s is not in the future", endTime)
+	}
+
+	// Bids made before the auction started would otherwise bypass the increment rule.
+	saleEpoch, err := s.endBidding(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+
+	auction := DataAuction{
+		AuctionType:  AuctionTypeEnglish,
+		DeviceName:   deviceName,
+		Date:         date,
+		OwnerOrg:     mspid,
+		ReservePrice: reserve,
+		MinIncrement: increment,
+		EndTime:      end.UTC().Format(time.RFC3339),
+		Terms:        terms,
+		Status:       AuctionStatusOpen,
+		SaleEpoch:    saleEpoch,
+	}
+	return s.putAuction(ctx, &auction)
+}
+
+// placeAuctionBid checks bid against the open auction and records it as the new highest bid.
+func (s *SmartContract) placeAuctionBid(ctx contractapi.TransactionContextInterface, auction *DataAuction, bid *DataBid) error {
+	if auction.AuctionType == AuctionTypeDutch {
+		return fmt.Errorf("asset %s is in a dutch auction, use BuyAtCurrentPrice instead of bidding", CreateAssetID(auction.DeviceName, auction.Date))
+	}
+	if bid.BiddingOrg == auction.OwnerOrg {
+		return fmt.Errorf("the owner of an asset cannot bid in its own auction")
+	}
+	if auction.Terms != nil && (bid.Terms == nil || *bid.Terms != *auction.Terms) {
+		return fmt.Errorf("bid usage terms must match the usage terms of the auction")
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	end, err := time.Parse(time.RFC3339, auction.EndTime)
+	if err != nil {
+		return fmt.Errorf("auction has an invalid end time: %v", err)
+	}
+	if !now.Before(end) {
+		return fmt.Errorf("auction for asset %s ended at %s", CreateAssetID(auction.DeviceName, auction.Date), auction.EndTime)
+	}
+
+	amount, err := parsePrice(bid.Price)
+	if err != nil {
+		return err
+	}
+	if amount < auction.ReservePrice {
+		return fmt.Errorf("bid of %d is below the reserve price of %d", amount, auction.ReservePrice)
+	}
+	if auction.HighestBidder != "" && amount < auction.HighestPrice+auction.MinIncrement {
+		return fmt.Errorf("bid of %d does not beat the highest bid of %d by the minimum increment of %d", amount, auction.HighestPrice, auction.MinIncrement)
+	}
+
+	auction.HighestBidder = bid.BiddingOrg
+	auction.HighestPrice = amount
+	bid.AuctionBid = true
+	return nil
+}
+
+// CloseAuction settles an auction after its end time. If the highest bid meets the reserve price it is accepted
+// the same way AcceptBid accepts a bid, otherwise the auction closes without a sale. If the winning bid can no
+// longer be accepted the auction ends unsold and an auctionUnsold event says why.
+func (s *SmartContract) CloseAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	auction, err := s.GetAuction(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if auction.AuctionType != AuctionTypeEnglish {
+		return fmt.Errorf("only english auctions are closed with CloseAuction")
+	}
+	if auction.Status != AuctionStatusOpen {
+		return fmt.Errorf("auction for asset %s is already %s", CreateAssetID(deviceName, date), auction.Status)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	end, err := time.Parse(time.RFC3339, auction.EndTime)
+	if err != nil {
+		return fmt.Errorf("auction has an invalid end time: %v", err)
+	}
+	if now.Before(end) {
+		return fmt.Errorf("auction for asset %s is open until %s", CreateAssetID(deviceName, date), auction.EndTime)
+	}
+
+	if auction.HighestBidder == "" || auction.HighestPrice < auction.ReservePrice {
+		auction.Status = AuctionStatusClosed
+		_, err = s.endBidding(ctx, deviceName, date)
+		if err != nil {
+			return err
+		}
+		return s.putAuction(ctx, auction)
+	}
+
+	bidID := "bid_" + deviceName + "_" + date + "_" + auction.OwnerOrg + "_" + auction.HighestBidder
+	bidBytes, err := ctx.GetStub().GetState(bidID)
+	if err != nil {
+		return fmt.Errorf("error ocurred getting winning bid: %v", err)
+	}
+	var winningBid DataBid
+	err = json.Unmarshal(bidBytes, &winningBid)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal winning bid: %v", err)
+	}
+
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	err = s.checkBidAcceptable(ctx, asset, &winningBid)
+	if err != nil {
+		// The auction can't be retried once it ended, so it closes unsold instead of staying open for good.
+		auction.Status = AuctionStatusUnsold
+		auction.UnsoldReason = err.Error()
+		_, err = s.endBidding(ctx, deviceName, date)
+		if err != nil {
+			return err
+		}
+		err = s.putAuction(ctx, auction)
+		if err != nil {
+			return err
+		}
+		auctionBytes, err := json.Marshal(auction)
+		if err != nil {
+			return fmt.Errorf("failed to marshal auction: %v", err)
+		}
+		return ctx.GetStub().SetEvent("auctionUnsold_"+CreateAuctionID(deviceName, date), auctionBytes)
+	}
+
+	auction.Status = AuctionStatusSettled
+	err = s.putAuction(ctx, auction)
+	if err != nil {
+		return err
+	}
+	return s.transferAssetToBidder(ctx, &winningBid)
+}
+
+// StartDutchAuction opens a descending price auction on an asset owned by the calling org. The price starts at
+// startPrice and drops by decrement every intervalSeconds, but never below floorPrice. The buyer agrees to usageTerms.
+func (s *SmartContract) StartDutchAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string, startPrice string, floorPrice string, decrement string, intervalSeconds int64, usageTerms string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := s.checkCanStartAuction(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	terms, err := ParseUsageTerms(usageTerms)
+	if err != nil {
+		return err
+	}
+	err = s.checkPurpose(ctx, terms)
+	if err != nil {
+		return err
+	}
+	err = s.checkConsent(ctx, deviceName, "", terms)
+	if err != nil {
+		return err
+	}
+
+	startAmount, err := parsePrice(startPrice)
+	if err != nil {
+		return fmt.Errorf("invalid start price: %v", err)
+	}
+	floorAmount, err := parsePrice(floorPrice)
+	if err != nil {
+		return fmt.Errorf("invalid floor price: %v", err)
+	}
+	decrementAmount, err := parsePrice(decrement)
+	if err != nil {
+		return fmt.Errorf("invalid decrement: %v", err)
+	}
+	if floorAmount > startAmount {
+		return fmt.Errorf("floor price %d is above the start price %d", floorAmount, startAmount)
+	}
+	if decrementAmount == 0 || intervalSeconds <= 0 {
+		return fmt.Errorf("decrement and interval must both be greater than zero")
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	saleEpoch, err := s.endBidding(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+
+	auction := DataAuction{
+		AuctionType:     AuctionTypeDutch,
+		DeviceName:      deviceName,
+		Date:            date,
+		OwnerOrg:        mspid,
+		StartTime:       now.UTC().Format(time.RFC3339),
+		StartPrice:      startAmount,
+		FloorPrice:      floorAmount,
+		Decrement:       decrementAmount,
+		IntervalSeconds: intervalSeconds,
+		Terms:           terms,
+		Status:          AuctionStatusOpen,
+		SaleEpoch:       saleEpoch,
+	}
+	return s.putAuction(ctx, &auction)
+}
+
+// dutchPriceAt computes the price of a dutch auction at the given time.
+func dutchPriceAt(auction *DataAuction, at time.Time) (int64, error) {
+	start, err := time.Parse(time.RFC3339, auction.StartTime)
+	if err != nil {
+		return 0, fmt.Errorf("auction has an invalid start time: %v", err)
+	}
+
+	elapsedIntervals := int64(0)
+	if at.After(start) {
+		elapsedIntervals = int64(at.Sub(start)/time.Second) / auction.IntervalSeconds
+	}
+
+	// Compare before multiplying so that long running auctions can't overflow.
+	if elapsedIntervals >= (auction.StartPrice-auction.FloorPrice)/auction.Decrement+1 {
+		return auction.FloorPrice, nil
+	}
+	price := auction.StartPrice - elapsedIntervals*auction.Decrement
+	if price < auction.FloorPrice {
+		return auction.FloorPrice, nil
+	}
+	return price, nil
+}
+
+func (s *SmartContract) getOpenDutchAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string) (*DataAuction, error) {
+	auction, err := s.GetAuction(ctx, deviceName, date)
+	if err != nil {
+		return nil, err
+	}
+	if auction.AuctionType != AuctionTypeDutch || auction.Status != AuctionStatusOpen {
+		return nil, fmt.Errorf("no open dutch auction for asset %s", CreateAssetID(deviceName, date))
+	}
+	return auction, nil
+}
+
+// GetCurrentAuctionPrice returns the price of an open dutch auction at the transaction timestamp.
+func (s *SmartContract) GetCurrentAuctionPrice(ctx contractapi.TransactionContextInterface, deviceName string, date string) (string, error) {
+	auction, err := s.getOpenDutchAuction(ctx, deviceName, date)
+	if err != nil {
+		return "", err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	price, err := dutchPriceAt(auction, now)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(price, 10), nil
+}
+
+// BuyAtCurrentPrice buys the asset of an open dutch auction at the price computed from the transaction timestamp,
+// and returns that price. The purchase fails if that price is above maxPrice, as the price the buyer saw may
+// differ from the price at the timestamp its transaction gets. The sale goes through the same ownership
+// transfer and event as AcceptBid.
+func (s *SmartContract) BuyAtCurrentPrice(ctx contractapi.TransactionContextInterface, deviceName string, date string, maxPrice string) (string, error) {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return "", err
+	}
+	buyingOrg, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	auction, err := s.getOpenDutchAuction(ctx, deviceName, date)
+	if err != nil {
+		return "", err
+	}
+	if buyingOrg == auction.OwnerOrg {
+		return "", fmt.Errorf("the owner of an asset cannot buy it in its own auction")
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	price, err := dutchPriceAt(auction, now)
+	if err != nil {
+		return "", err
+	}
+	maxAmount, err := parsePrice(maxPrice)
+	if err != nil {
+		return "", fmt.Errorf("invalid maximum price: %v", err)
+	}
+	if price > maxAmount {
+		return "", fmt.Errorf("the current price of %d is above the maximum price of %d", price, maxAmount)
+	}
+
+	auction.HighestBidder = buyingOrg
+	auction.HighestPrice = price
+	auction.EndTime = now.UTC().Format(time.RFC3339)
+	auction.Status = AuctionStatusSettled
+	err = s.putAuction(ctx, auction)
+	if err != nil {
+		return "", err
+	}
+
+	winningBid := DataBid{
+		BiddingOrg:      buyingOrg,
+		CurrentOwnerOrg: auction.OwnerOrg,
+		DeviceName:      deviceName,
+		Date:            date,
+		Price:           strconv.FormatInt(price, 10),
+		Terms:           auction.Terms,
+		AuctionBid:      true,
+		SaleEpoch:       auction.SaleEpoch,
+	}
+	err = s.transferAssetToBidder(ctx, &winningBid)
+	if err != nil {
+		return "", err
+	}
+	return winningBid.Price, nil
+}
+
+// CancelAuction lets the owner call off its open auction on the asset of deviceName on date, as long as no one
+// bid in it. Bidding ends with the auction, so earlier bids don't come back.
+func (s *SmartContract) CancelAuction(ctx contractapi.TransactionContextInterface, deviceName string, date string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	auction, err := s.GetAuction(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if auction.OwnerOrg != mspid {
+		return fmt.Errorf("only the owner of asset %s can cancel its auction", CreateAssetID(deviceName, date))
+	}
+	if auction.Status != AuctionStatusOpen {
+		return fmt.Errorf("auction for asset %s is already %s", CreateAssetID(deviceName, date), auction.Status)
+	}
+	if auction.HighestBidder != "" {
+		return fmt.Errorf("auction for asset %s has bids, it can only be closed once it ends", CreateAssetID(deviceName, date))
+	}
+
+	_, err = s.endBidding(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	auction.Status = AuctionStatusCancelled
+	return s.putAuction(ctx, auction)
+}
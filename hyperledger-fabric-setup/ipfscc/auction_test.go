@@ -0,0 +1,188 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnglishAuction(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	start := time.Date(2000, 2, 3, 9, 0, 0, 0, time.UTC)
+	ledger.setTime(start)
+	err := assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", start.Add(time.Hour).Format(time.RFC3339), testUsageTerms)
+	require.NoError(t, err)
+
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "90", "", testUsageTerms), "reserve")
+	assert.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "105", "", testUsageTerms), "bid must beat the highest by the increment")
+	assert.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "110", "", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "500", "", testUsageTerms), "owner cannot bid on its own auction")
+	assert.Error(t, assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "110"), "auction bids are settled by CloseAuction")
+	assert.ErrorContains(t, assetTransferCC.CancelAuction(transactionContext, testDeviceName, testDataDate), "has bids")
+
+	assert.Error(t, assetTransferCC.CloseAuction(transactionContext, testDeviceName, testDataDate), "auction has not ended yet")
+
+	ledger.setTime(start.Add(2 * time.Hour))
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "200", "", testUsageTerms), "auction has ended")
+	require.NoError(t, assetTransferCC.CloseAuction(transactionContext, testDeviceName, testDataDate))
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg3Msp, asset.OwnerOrg)
+	assert.Equal(t, "bidApproval_"+myOrg3Msp+"_"+myOrg1Msp+"_"+testDeviceName+"_"+testDataDate, ledger.lastEvent)
+
+	auction, err := assetTransferCC.GetAuction(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, AuctionStatusSettled, auction.Status)
+	assert.Error(t, assetTransferCC.CloseAuction(transactionContext, testDeviceName, testDataDate))
+}
+
+func TestEnglishAuctionBelowReserve(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	start := time.Date(2000, 2, 3, 9, 0, 0, 0, time.UTC)
+	ledger.setTime(start)
+	err := assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "1000", "10", start.Add(time.Hour).Format(time.RFC3339), testUsageTerms)
+	require.NoError(t, err)
+
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "500", "", testUsageTerms), "reserve",
+		"bids below the reserve price are refused")
+
+	ledger.setTime(start.Add(time.Hour))
+	require.NoError(t, assetTransferCC.CloseAuction(transactionContext, testDeviceName, testDataDate))
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg1Msp, asset.OwnerOrg)
+	ledger.as(myOrg1Msp)
+	bids, err := assetTransferCC.GetBidsForMyOrg(transactionContext)
+	require.NoError(t, err)
+	assert.Empty(t, bids)
+
+	auction, err := assetTransferCC.GetAuction(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, AuctionStatusClosed, auction.Status)
+}
+
+func TestEnglishAuctionUnsoldWhenWinnerIsFrozen(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) {})
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	start := time.Date(2000, 2, 3, 9, 0, 0, 0, time.UTC)
+	ledger.setTime(start)
+	err := assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", start.Add(time.Hour).Format(time.RFC3339), testUsageTerms)
+	require.NoError(t, err)
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+
+	ledger.setTxID("freezeTx")
+	applyTestEmergencyControl(t, &assetTransferCC, ledger, func() (string, error) {
+		return assetTransferCC.ProposeOrgFreeze(transactionContext, myOrg3Msp, true)
+	})
+
+	ledger.setTime(start.Add(2 * time.Hour))
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.CloseAuction(transactionContext, testDeviceName, testDataDate),
+		"an auction whose winner can't receive the asset still closes")
+	assert.Equal(t, "auctionUnsold_"+CreateAuctionID(testDeviceName, testDataDate), ledger.lastEvent)
+
+	auction, err := assetTransferCC.GetAuction(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, AuctionStatusUnsold, auction.Status)
+	assert.Contains(t, auction.UnsoldReason, "frozen")
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg1Msp, asset.OwnerOrg)
+	assert.Error(t, assetTransferCC.CloseAuction(transactionContext, testDeviceName, testDataDate))
+
+	assert.NoError(t, assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", start.Add(3*time.Hour).Format(time.RFC3339), testUsageTerms),
+		"the owner can auction the asset again")
+}
+
+func TestDutchAuction(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	start := time.Date(2000, 2, 3, 9, 0, 0, 0, time.UTC)
+	ledger.setTime(start)
+	err := assetTransferCC.StartDutchAuction(transactionContext, testDeviceName, testDataDate, "1000", "400", "100", 60, testUsageTerms)
+	require.NoError(t, err)
+
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "900", "", testUsageTerms), "dutch auctions don't take bids")
+
+	ledger.setTime(start.Add(150 * time.Second))
+	price, err := assetTransferCC.GetCurrentAuctionPrice(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, "800", price)
+
+	ledger.setTime(start.Add(24 * time.Hour))
+	price, err = assetTransferCC.GetCurrentAuctionPrice(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, "400", price, "price never drops below the floor")
+
+	ledger.setTime(start.Add(5 * time.Minute))
+	_, err = assetTransferCC.BuyAtCurrentPrice(transactionContext, testDeviceName, testDataDate, "450")
+	assert.ErrorContains(t, err, "above the maximum price", "the price dropped slower than the buyer expected")
+	price, err = assetTransferCC.BuyAtCurrentPrice(transactionContext, testDeviceName, testDataDate, "600")
+	require.NoError(t, err)
+	assert.Equal(t, "500", price)
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg2Msp, asset.OwnerOrg)
+	assert.Equal(t, "bidApproval_"+myOrg2Msp+"_"+myOrg1Msp+"_"+testDeviceName+"_"+testDataDate, ledger.lastEvent)
+
+	var bid DataBid
+	ledger.getJSON(t, "bid_"+testDeviceName+"_"+testDataDate+"_"+myOrg1Msp+"_"+myOrg2Msp, &bid)
+	assert.Equal(t, "500", bid.Price)
+	assert.False(t, bid.Active)
+
+	ledger.as(myOrg3Msp)
+	_, err = assetTransferCC.BuyAtCurrentPrice(transactionContext, testDeviceName, testDataDate, "1000")
+	assert.Error(t, err, "only the first buyer wins")
+}
+
+func TestCancelAuction(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "50", "", testUsageTerms))
+
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.CancelAuction(transactionContext, testDeviceName, testDataDate), "there is no auction")
+	require.NoError(t, assetTransferCC.StartDutchAuction(transactionContext, testDeviceName, testDataDate, "1000", "400", "100", 60, testUsageTerms))
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.CancelAuction(transactionContext, testDeviceName, testDataDate), "only the owner cancels")
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.CancelAuction(transactionContext, testDeviceName, testDataDate))
+	auction, err := assetTransferCC.GetAuction(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, AuctionStatusCancelled, auction.Status)
+	assert.Error(t, assetTransferCC.CancelAuction(transactionContext, testDeviceName, testDataDate), "an auction is cancelled once")
+
+	ledger.as(myOrg2Msp)
+	_, err = assetTransferCC.BuyAtCurrentPrice(transactionContext, testDeviceName, testDataDate, "1000")
+	assert.Error(t, err, "a cancelled auction sells nothing")
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms), "bidding opens again")
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "50"), "bids from before the auction stay stale")
+	require.NoError(t, assetTransferCC.AcceptBid(transactionContext, myOrg2Msp, testDeviceName, testDataDate, "100"))
+}
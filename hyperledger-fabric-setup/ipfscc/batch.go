@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxUploadBatchSize bounds the assets uploaded in one transaction, so a batch stays well below the size the
+// orderer accepts in a block.
+const maxUploadBatchSize = 500
+
+// BatchUploadEntry is one asset of UploadDataAssetsBatch. Metadata is the AssetMetadata of the asset, embedded
+// as a JSON object.
+type BatchUploadEntry struct {
+	DeviceName string          `json:"deviceName"`
+	CID        string          `json:"cid"`
+	Date       string          `json:"date"`
+	Metadata   json.RawMessage `json:"metadata"`
+}
+
+// DataAssetsBatchUploaded is the payload of the event emitted when a batch of assets is uploaded.
+type DataAssetsBatchUploaded struct {
+	UploaderOrg string   `json:"uploaderOrg"`
+	AssetIDs    []string `json:"assetIDs"`
+	UploadedAt  string   `json:"uploadedAt"`
+}
+
+// UploadDataAssetsBatch records many daily assets of the calling org in one transaction, such as every device
+// of a gateway at the end of a day. entries is a JSON array of BatchUploadEntry, and the transient data maps
+// "<deviceName>_<date>" of every entry to the symmetric key of its data, which is stored in the implicit
+// collection of the org like UploadKeyPrivateData does. Every entry is checked before anything is written, so
+// either the whole batch is recorded or none of it, and a single event lists the uploaded assets.
+func (s *SmartContract) UploadDataAssetsBatch(ctx contractapi.TransactionContextInterface, entries string) error {
+	var batch []BatchUploadEntry
+	err := json.Unmarshal([]byte(entries), &batch)
+	if err != nil {
+		return fmt.Errorf("batch entries are not a valid JSON array: %v", err)
+	}
+	if len(batch) == 0 {
+		return fmt.Errorf("a batch needs at least one entry")
+	}
+	if len(batch) > maxUploadBatchSize {
+		return fmt.Errorf("a batch holds at most %d entries, got %d", maxUploadBatchSize, len(batch))
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Reads don't see the writes of this transaction, so entries are checked against each other here.
+	assets := make([]*DataAsset, 0, len(batch))
+	assetIDs := make([]string, 0, len(batch))
+	deviceDays := make([]string, 0, len(batch))
+	for _, entry := range batch {
+		assetID := CreateAssetID(entry.DeviceName, entry.Date)
+		if contains(assetIDs, assetID) {
+			return fmt.Errorf("asset %s is listed twice in the batch", assetID)
+		}
+		if len(transientMap[entry.DeviceName+"_"+entry.Date]) == 0 {
+			return fmt.Errorf("the transient data holds no key for asset %s", assetID)
+		}
+		asset, err := s.newDailyDataAsset(ctx, entry.DeviceName, entry.CID, entry.Date, string(entry.Metadata))
+		if err != nil {
+			return fmt.Errorf("error ocurred validating asset %s: %v", assetID, err)
+		}
+		// The same day can be written in more than one allowed date format.
+		dataDate, err := config.ParseDate(entry.Date)
+		if err != nil {
+			return err
+		}
+		deviceDay := entry.DeviceName + "_" + dataDate.Format("2006-01-02")
+		if contains(deviceDays, deviceDay) {
+			return fmt.Errorf("asset %s overlaps another entry of the batch", assetID)
+		}
+		deviceDays = append(deviceDays, deviceDay)
+		assets = append(assets, asset)
+		assetIDs = append(assetIDs, assetID)
+	}
+
+	privateCollectionName := "_implicit_org_" + mspid
+	for _, asset := range assets {
+		err = s.putDataAsset(ctx, asset)
+		if err != nil {
+			return err
+		}
+		symmetricKey := string(transientMap[asset.AssetName+"_"+asset.Date])
+		err = putKeyPrivateData(ctx, privateCollectionName, asset.AssetName, asset.IPFS_CID, asset.Date, symmetricKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(DataAssetsBatchUploaded{
+		UploaderOrg: mspid,
+		AssetIDs:    assetIDs,
+		UploadedAt:  now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch upload event to JSON: %v", err)
+	}
+	return ctx.GetStub().SetEvent("dataAssetsBatchUploaded_"+ctx.GetStub().GetTxID(), eventBytes)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBatchEntries(deviceNames ...string) string {
+	entries := "["
+	for i, deviceName := range deviceNames {
+		if i > 0 {
+			entries += ","
+		}
+		entries += `{"deviceName":"` + deviceName + `","cid":"` + testCID + `","date":"` + testDataDate + `","metadata":` + testMetadata + `}`
+	}
+	return entries + "]"
+}
+
+func TestUploadDataAssetsBatch(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setTxID("batchTx1")
+	ledger.stub.GetTransientReturns(map[string][]byte{
+		testDeviceName + "_" + testDataDate:        []byte(testEncryptionKey),
+		testDeviceName + "_2_" + testDataDate:      []byte("otherKey"),
+		testDeviceName + "_unused_" + testDataDate: []byte("unusedKey"),
+	}, nil)
+
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.UploadDataAssetsBatch(transactionContext, testBatchEntries(testDeviceName, testDeviceName+"_2"))
+	}))
+	for _, deviceName := range []string{testDeviceName, testDeviceName + "_2"} {
+		asset, err := assetTransferCC.GetAssetByID(transactionContext, deviceName+"_"+testDataDate)
+		require.NoError(t, err)
+		assert.Equal(t, myOrg1Msp, asset.OwnerOrg)
+		assert.Equal(t, testCID, asset.IPFS_CID)
+	}
+	key, err := assetTransferCC.GetKeyPrivateData(transactionContext, testDeviceName+"_2_"+testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, "otherKey", key.SymmetricKey)
+	assert.Len(t, ledger.privateData["_implicit_org_"+myOrg1Msp], 2, "only the keys of the batch are stored")
+
+	assert.Equal(t, "dataAssetsBatchUploaded_batchTx1", ledger.lastEvent)
+	var event DataAssetsBatchUploaded
+	require.NoError(t, json.Unmarshal(ledger.events[ledger.lastEvent], &event))
+	assert.Equal(t, myOrg1Msp, event.UploaderOrg)
+	assert.Equal(t, []string{CreateAssetID(testDeviceName, testDataDate), CreateAssetID(testDeviceName+"_2", testDataDate)}, event.AssetIDs)
+}
+
+func TestUploadDataAssetsBatchIsAtomic(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.stub.GetTransientReturns(map[string][]byte{
+		testDeviceName + "_" + testDataDate:   []byte(testEncryptionKey),
+		testDeviceName + "_2_" + testDataDate: []byte(testEncryptionKey),
+	}, nil)
+
+	assert.Error(t, assetTransferCC.UploadDataAssetsBatch(transactionContext, "[]"))
+	assert.Error(t, assetTransferCC.UploadDataAssetsBatch(transactionContext, "not json"))
+	assert.ErrorContains(t, assetTransferCC.UploadDataAssetsBatch(transactionContext, testBatchEntries(testDeviceName, testDeviceName)), "listed twice")
+	assert.ErrorContains(t, assetTransferCC.UploadDataAssetsBatch(transactionContext, testBatchEntries(testDeviceName, testDeviceName+"_3")), "no key")
+	sameDay := testBatchEntries(testDeviceName)
+	sameDay = sameDay[:len(sameDay)-1] + `,{"deviceName":"` + testDeviceName + `","cid":"` + testCID + `","date":"2000-02-02","metadata":` + testMetadata + `}]`
+	ledger.stub.GetTransientReturns(map[string][]byte{
+		testDeviceName + "_" + testDataDate:   []byte(testEncryptionKey),
+		testDeviceName + "_2000-02-02":        []byte(testEncryptionKey),
+		testDeviceName + "_2_" + testDataDate: []byte(testEncryptionKey),
+	}, nil)
+	assert.ErrorContains(t, assetTransferCC.UploadDataAssetsBatch(transactionContext, sameDay), "overlaps another entry")
+
+	invalidEntry := `{"deviceName":"` + testDeviceName + `_2","cid":"` + testCID + `","date":"` + testDataDate + `","metadata":{}}`
+	entries := testBatchEntries(testDeviceName)
+	entries = entries[:len(entries)-1] + `,` + invalidEntry + `]`
+	assert.ErrorContains(t, assetTransferCC.UploadDataAssetsBatch(transactionContext, entries), testDeviceName+"_2_"+testDataDate)
+	assert.Empty(t, ledger.state[CreateAssetID(testDeviceName, testDataDate)], "nothing is written when an entry is invalid")
+	assert.Empty(t, ledger.privateData["_implicit_org_"+myOrg1Msp])
+	assert.Empty(t, ledger.events)
+
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	assert.ErrorContains(t, assetTransferCC.UploadDataAssetsBatch(transactionContext, testBatchEntries(testDeviceName+"_2", testDeviceName)), "already exists")
+	assert.Empty(t, ledger.state[CreateAssetID(testDeviceName+"_2", testDataDate)])
+}
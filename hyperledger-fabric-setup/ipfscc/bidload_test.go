@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptUnderLoad simulates rounds of blocks in which the owner accepts a bid while other orgs bid on the same
+// asset, and returns how many accepts failed validation. Bids ordered after a sale fail validation either way,
+// as they were placed with the previous owner.
+func acceptUnderLoad(t *testing.T, rounds int, concurrentBids int, accept func(assetTransferCC *SmartContract, ledger *mockLedger) error) int {
+	random := rand.New(rand.NewSource(1))
+	acceptConflicts := 0
+	for round := 0; round < rounds; round++ {
+		transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+		assetTransferCC := SmartContract{}
+		uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+		ledger.as(myOrg2Msp)
+		require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+
+		var block []*txSimulation
+		for i := 0; i < concurrentBids; i++ {
+			ledger.as(fmt.Sprintf("loadOrg%dMSP", i))
+			block = append(block, simulateTx(t, ledger, func() error {
+				return assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "200", "", testUsageTerms)
+			}))
+		}
+		ledger.as(myOrg1Msp)
+		acceptSim := simulateTx(t, ledger, func() error {
+			return accept(&assetTransferCC, ledger)
+		})
+		// The orderer may put the accept anywhere among the bids.
+		position := random.Intn(len(block) + 1)
+		block = append(block[:position], append([]*txSimulation{acceptSim}, block[position:]...)...)
+
+		if !commitBlock(ledger, block)[position] {
+			acceptConflicts++
+		}
+	}
+	return acceptConflicts
+}
+
+// inactivateBidsByScan marks every bid made to currentOwnerOrg for the test asset inactive by scanning them,
+// the way accepting a bid did before sale epochs.
+func inactivateBidsByScan(ledger *mockLedger, currentOwnerOrg string) error {
+	startKey := "bid_" + testDeviceName + "_" + testDataDate + "_" + currentOwnerOrg
+	resultsIterator, err := ledger.ctx.GetStub().GetStateByRange(startKey, startKey+"_~")
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		var bid DataBid
+		err = json.Unmarshal(queryResponse.Value, &bid)
+		if err != nil {
+			return err
+		}
+		bid.Active = false
+		bidBytes, err := json.Marshal(bid)
+		if err != nil {
+			return err
+		}
+		err = ledger.ctx.GetStub().PutState(queryResponse.Key, bidBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestConcurrentBidsDontConflictWithAcceptBid(t *testing.T) {
+	const rounds, concurrentBids = 20, 10
+
+	// Before sale epochs, accepting a bid inactivated the other bids by scanning them, taking a phantom read
+	// dependency on every bid for the asset.
+	scanAcceptConflicts := acceptUnderLoad(t, rounds, concurrentBids, func(assetTransferCC *SmartContract, ledger *mockLedger) error {
+		err := inactivateBidsByScan(ledger, myOrg1Msp)
+		if err != nil {
+			return err
+		}
+		return assetTransferCC.AcceptBid(ledger.ctx, myOrg2Msp, testDeviceName, testDataDate, "100")
+	})
+	acceptConflicts := acceptUnderLoad(t, rounds, concurrentBids, func(assetTransferCC *SmartContract, ledger *mockLedger) error {
+		return assetTransferCC.AcceptBid(ledger.ctx, myOrg2Msp, testDeviceName, testDataDate, "100")
+	})
+
+	t.Logf("accepts failing validation: %d of %d with a bid scan, %d of %d with sale epochs", scanAcceptConflicts, rounds, acceptConflicts, rounds)
+	assert.Zero(t, acceptConflicts, "bids placed concurrently don't invalidate the accept")
+	assert.Greater(t, scanAcceptConflicts, rounds/2, "most accepts fail while they scan the bids")
+}
+
+func TestBidsGoStaleWhenBiddingEnds(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "50", "", testUsageTerms))
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+
+	var bid DataBid
+	ledger.getJSON(t, "bid_"+testDeviceName+"_"+testDataDate+"_"+myOrg1Msp+"_"+myOrg3Msp, &bid)
+	assert.True(t, bid.Active, "the other bids are left as they are")
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, 1, asset.SaleEpoch)
+	assert.True(t, bid.IsStale(&asset))
+
+	// The asset comes back to its first owner, and the bid made to it before the sale stays stale.
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg2Msp, myOrg1Msp, "100"))
+	ledger.as(myOrg1Msp)
+	assert.ErrorContains(t, assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "50"), "bidding")
+	bids, err := assetTransferCC.GetBidsForMyOrg(transactionContext)
+	require.NoError(t, err)
+	assert.Empty(t, bids)
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "60", "", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", "2000-02-03T00:00:00Z", testUsageTerms))
+	bids, err = assetTransferCC.GetBidsForMyOrg(transactionContext)
+	require.NoError(t, err)
+	assert.Empty(t, bids, "bids from before the auction are stale")
+	assert.Error(t, assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "60"))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ChallengeStatusOpen   = "open"
+	ChallengeStatusPassed = "passed"
+	ChallengeStatusFailed = "failed"
+)
+
+// StorageChallenge asks a storage org that attested to pinning an asset to prove it still holds block
+// BlockIndex of the ciphertext, by answering with the hash of the block and its Merkle proof before Deadline.
+type StorageChallenge struct {
+	ChallengeID   string `json:"challengeID"`
+	DeviceName    string `json:"deviceName"`
+	Date          string `json:"date"`
+	CID           string `json:"cid"`
+	StorageOrg    string `json:"storageOrg"`
+	ChallengerOrg string `json:"challengerOrg"`
+	BlockIndex    int64  `json:"blockIndex"`
+	IssuedAt      string `json:"issuedAt"`
+	Deadline      string `json:"deadline"`
+	AnsweredAt    string `json:"answeredAt"`
+	Status        string `json:"status"`
+}
+
+// CreateStorageChallengeID keys challenges by storage org, so the reputation of an org reads only its own.
+func CreateStorageChallengeID(storageOrg string, txID string) string {
+	return "challenge_" + storageOrg + "_" + txID
+}
+
+// CreateOpenChallengeID keys the latest challenge challengerOrg posted to storageOrg for an asset, which must be
+// settled before challengerOrg posts another.
+func CreateOpenChallengeID(storageOrg string, deviceName string, date string, challengerOrg string) string {
+	return "openChallenge_" + storageOrg + "_" + deviceName + "_" + date + "_" + challengerOrg
+}
+
+// IsPending reports whether the storage org can still answer the challenge.
+func (c *StorageChallenge) IsPending(now time.Time) bool {
+	return c.Status == ChallengeStatusOpen && !c.IsFailed(now)
+}
+
+// IsFailed reports whether the storage org answered the challenge wrongly, or missed its deadline.
+func (c *StorageChallenge) IsFailed(now time.Time) bool {
+	if c.Status == ChallengeStatusFailed {
+		return true
+	}
+	if c.Status != ChallengeStatusOpen {
+		return false
+	}
+	deadline, err := time.Parse(time.RFC3339, c.Deadline)
+	if err != nil {
+		return false
+	}
+	return now.After(deadline)
+}
+
+// challengeBlockIndex derives the challenged block from the transaction ID, which neither the challenger nor
+// the storage org can choose.
+func challengeBlockIndex(txID string, blockCount int64) int64 {
+	digest := sha256.Sum256([]byte(txID))
+	return int64(binary.BigEndian.Uint64(digest[:8]) % uint64(blockCount))
+}
+
+func (s *SmartContract) putStorageChallenge(ctx contractapi.TransactionContextInterface, challenge *StorageChallenge) error {
+	challengeBytes, err := json.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to marshal storage challenge to JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(CreateStorageChallengeID(challenge.StorageOrg, challenge.ChallengeID), challengeBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put storage challenge: %v", err)
+	}
+	return ctx.GetStub().SetEvent("storageChallenge_"+challenge.ChallengeID, challengeBytes)
+}
+
+// GetStorageChallenge returns the storage challenge of storageOrg posted in transaction challengeID.
+func (s *SmartContract) GetStorageChallenge(ctx contractapi.TransactionContextInterface, storageOrg string, challengeID string) (*StorageChallenge, error) {
+	challengeBytes, err := ctx.GetStub().GetState(CreateStorageChallengeID(storageOrg, challengeID))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting storage challenge: %v", err)
+	}
+	if challengeBytes == nil {
+		return nil, fmt.Errorf("storage challenge %s of %s does not exist", challengeID, storageOrg)
+	}
+
+	var challenge StorageChallenge
+	err = json.Unmarshal(challengeBytes, &challenge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal storage challenge JSON: %v", err)
+	}
+	return &challenge, nil
+}
+
+// PostStorageChallenge lets any org challenge storageOrg to prove it still stores the asset of deviceName on
+// date. The asset must have been uploaded with a block Merkle root, and storageOrg must have attested to
+// pinning it. A challenger has at most one challenge pending per storage org and asset. The challenge is
+// announced with an event.
+func (s *SmartContract) PostStorageChallenge(ctx contractapi.TransactionContextInterface, deviceName string, date string, storageOrg string) (string, error) {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return "", err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return "", err
+	}
+	if asset.Metadata == nil || asset.Metadata.BlockMerkleRoot == "" {
+		return "", fmt.Errorf("asset %s was uploaded without a block Merkle root", CreateAssetID(deviceName, date))
+	}
+	cid := assetCIDv1(asset)
+	pinBytes, err := ctx.GetStub().GetState(CreatePinAttestationID(cid, storageOrg))
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting pin attestation: %v", err)
+	}
+	if pinBytes == nil {
+		return "", fmt.Errorf("org %s has not attested to pinning asset %s", storageOrg, CreateAssetID(deviceName, date))
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	openChallengeID := CreateOpenChallengeID(storageOrg, deviceName, date, mspid)
+	openBytes, err := ctx.GetStub().GetState(openChallengeID)
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting open storage challenge: %v", err)
+	}
+	if openBytes != nil {
+		open, err := s.GetStorageChallenge(ctx, storageOrg, string(openBytes))
+		if err != nil {
+			return "", err
+		}
+		if open.IsPending(now) {
+			return "", fmt.Errorf("storage challenge %s of %s on asset %s is still pending until %s", open.ChallengeID, storageOrg, CreateAssetID(deviceName, date), open.Deadline)
+		}
+	}
+
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	txID := ctx.GetStub().GetTxID()
+	challenge := &StorageChallenge{
+		ChallengeID:   txID,
+		DeviceName:    deviceName,
+		Date:          date,
+		CID:           cid,
+		StorageOrg:    storageOrg,
+		ChallengerOrg: mspid,
+		BlockIndex:    challengeBlockIndex(txID, asset.Metadata.BlockCount),
+		IssuedAt:      now.UTC().Format(time.RFC3339),
+		Deadline:      now.Add(time.Duration(config.ChallengeResponseSeconds) * time.Second).UTC().Format(time.RFC3339),
+		Status:        ChallengeStatusOpen,
+	}
+	err = s.putStorageChallenge(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+	err = ctx.GetStub().PutState(openChallengeID, []byte(txID))
+	if err != nil {
+		return "", fmt.Errorf("failed to put open storage challenge: %v", err)
+	}
+	return txID, nil
+}
+
+// AnswerStorageChallenge lets the challenged storage org answer its challenge challengeID with blockHash, the
+// hex encoded sha2-256 hash of the challenged block, and proof, a JSON array of the hex encoded sibling hashes
+// from the leaf of the block up to the Merkle root. A wrong answer is recorded as failed rather than rejected,
+// and the resulting status is returned.
+func (s *SmartContract) AnswerStorageChallenge(ctx contractapi.TransactionContextInterface, challengeID string, blockHash string, proof string) (string, error) {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return "", err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	challenge, err := s.GetStorageChallenge(ctx, mspid, challengeID)
+	if err != nil {
+		return "", err
+	}
+	if challenge.Status != ChallengeStatusOpen {
+		return "", fmt.Errorf("storage challenge %s is already %s", challengeID, challenge.Status)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	if challenge.IsFailed(now) {
+		return "", fmt.Errorf("the deadline of storage challenge %s passed at %s", challengeID, challenge.Deadline)
+	}
+
+	hash, err := parseHexHash(blockHash)
+	if err != nil {
+		return "", err
+	}
+	siblings, err := parseHexHashes(proof)
+	if err != nil {
+		return "", err
+	}
+	asset, err := s.getDataAsset(ctx, challenge.DeviceName, challenge.Date)
+	if err != nil {
+		return "", err
+	}
+	root, err := hex.DecodeString(asset.Metadata.BlockMerkleRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid block Merkle root: %v", err)
+	}
+
+	challenge.Status = ChallengeStatusFailed
+	if verifyMerkleProof(merkleLeaf(hash), challenge.BlockIndex, asset.Metadata.BlockCount, siblings, root) {
+		challenge.Status = ChallengeStatusPassed
+	}
+	challenge.AnsweredAt = now.UTC().Format(time.RFC3339)
+	err = s.putStorageChallenge(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+	return challenge.Status, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// prepChallengedAsset uploads the test asset with a block Merkle root over blocks, pinned by myOrg3Msp.
+func prepChallengedAsset(t *testing.T, blocks [][]byte) (*mockLedger, SmartContract) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) { c.StorageProviderOrgs = []string{myOrg3Msp} })
+	metadata := AssetMetadata{
+		SchemaVersion: 2, SensorType: "energy meter", Unit: "kWh", SamplingIntervalSeconds: 60, ReadingCount: 1440, ByteSize: 52000,
+		Compression: "deflate", EncryptionAlgorithm: "AES-256-ECB",
+		BlockCount: int64(len(blocks)), BlockMerkleRoot: hex.EncodeToString(merkleRoot(merkleLeaves(blocks))),
+	}
+	metadataBytes, err := json.Marshal(metadata)
+	require.NoError(t, err)
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, testDataDate, string(metadataBytes)))
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.PostPinAttestation(transactionContext, testCID, 2, testClusterPeerIDs))
+	return ledger, assetTransferCC
+}
+
+func testChallengeAnswer(t *testing.T, blocks [][]byte, index int64) (string, string) {
+	proof := merkleProof(merkleLeaves(blocks), int(index))
+	encoded := make([]string, len(proof))
+	for i, hash := range proof {
+		encoded[i] = hex.EncodeToString(hash)
+	}
+	proofBytes, err := json.Marshal(encoded)
+	require.NoError(t, err)
+	return hex.EncodeToString(blocks[index]), string(proofBytes)
+}
+
+func TestStorageChallenge(t *testing.T) {
+	blocks := testBlockHashes(5)
+	ledger, assetTransferCC := prepChallengedAsset(t, blocks)
+	transactionContext := ledger.ctx
+
+	ledger.as(myOrg2Msp)
+	_, err := assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg1Msp)
+	assert.Error(t, err, "only orgs that attested to pinning the asset can be challenged")
+	ledger.setTxID("challengeTx1")
+	challengeID, err := assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	require.NoError(t, err)
+	assert.Equal(t, "challengeTx1", challengeID)
+	assert.Equal(t, "storageChallenge_challengeTx1", ledger.lastEvent)
+
+	challenge, err := assetTransferCC.GetStorageChallenge(transactionContext, myOrg3Msp, challengeID)
+	require.NoError(t, err)
+	assert.Equal(t, ChallengeStatusOpen, challenge.Status)
+	assert.Equal(t, myOrg2Msp, challenge.ChallengerOrg)
+	assert.Equal(t, challengeBlockIndex("challengeTx1", 5), challenge.BlockIndex)
+	assert.Equal(t, "2000-02-02T13:00:00Z", challenge.Deadline)
+
+	blockHash, proof := testChallengeAnswer(t, blocks, challenge.BlockIndex)
+	_, err = assetTransferCC.AnswerStorageChallenge(transactionContext, challengeID, blockHash, proof)
+	assert.ErrorContains(t, err, "does not exist", "only the storage org answers")
+	ledger.as(myOrg3Msp)
+	_, err = assetTransferCC.AnswerStorageChallenge(transactionContext, challengeID, blockHash, "proof")
+	assert.Error(t, err)
+	status, err := assetTransferCC.AnswerStorageChallenge(transactionContext, challengeID, blockHash, proof)
+	require.NoError(t, err)
+	assert.Equal(t, ChallengeStatusPassed, status)
+	_, err = assetTransferCC.AnswerStorageChallenge(transactionContext, challengeID, blockHash, proof)
+	assert.Error(t, err, "challenges are answered once")
+
+	ledger.as(myOrg2Msp)
+	ledger.setTxID("challengeTx2")
+	challengeID, err = assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	require.NoError(t, err)
+	challenge, err = assetTransferCC.GetStorageChallenge(transactionContext, myOrg3Msp, challengeID)
+	require.NoError(t, err)
+	_, proof = testChallengeAnswer(t, blocks, challenge.BlockIndex)
+	ledger.as(myOrg3Msp)
+	status, err = assetTransferCC.AnswerStorageChallenge(transactionContext, challengeID, hex.EncodeToString(testBlockHashes(6)[5]), proof)
+	require.NoError(t, err, "a wrong answer is recorded, not rolled back")
+	assert.Equal(t, ChallengeStatusFailed, status)
+
+	reputation, err := assetTransferCC.GetOrgReputation(transactionContext, myOrg3Msp)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reputation.FailedStorageChallenges)
+}
+
+func TestStorageChallengeDeadline(t *testing.T) {
+	blocks := testBlockHashes(3)
+	ledger, assetTransferCC := prepChallengedAsset(t, blocks)
+	transactionContext := ledger.ctx
+
+	ledger.as(myOrg2Msp)
+	challengeID, err := assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	require.NoError(t, err)
+	challenge, err := assetTransferCC.GetStorageChallenge(transactionContext, myOrg3Msp, challengeID)
+	require.NoError(t, err)
+
+	ledger.setTime(time.Date(2000, time.February, 2, 13, 0, 1, 0, time.UTC))
+	reputation, err := assetTransferCC.GetOrgReputation(transactionContext, myOrg3Msp)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reputation.FailedStorageChallenges, "a missed challenge counts as failed")
+
+	ledger.as(myOrg3Msp)
+	blockHash, proof := testChallengeAnswer(t, blocks, challenge.BlockIndex)
+	_, err = assetTransferCC.AnswerStorageChallenge(transactionContext, challengeID, blockHash, proof)
+	assert.ErrorContains(t, err, "deadline")
+}
+
+func TestStorageChallengesPendOnePerChallenger(t *testing.T) {
+	blocks := testBlockHashes(3)
+	ledger, assetTransferCC := prepChallengedAsset(t, blocks)
+	transactionContext := ledger.ctx
+
+	ledger.as(myOrg2Msp)
+	ledger.setTxID("challengeTx1")
+	_, err := assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	require.NoError(t, err)
+	ledger.setTxID("challengeTx2")
+	_, err = assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	assert.ErrorContains(t, err, "pending", "the first challenge is not settled yet")
+	ledger.as(myOrg1Msp)
+	_, err = assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	require.NoError(t, err, "another challenger is not held up")
+
+	ledger.as(myOrg2Msp)
+	ledger.setTxID("challengeTx3")
+	ledger.setTime(time.Date(2000, time.February, 2, 13, 0, 1, 0, time.UTC))
+	_, err = assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	require.NoError(t, err, "a missed challenge is settled")
+	challenge, err := assetTransferCC.GetStorageChallenge(transactionContext, myOrg3Msp, "challengeTx3")
+	require.NoError(t, err)
+	ledger.as(myOrg3Msp)
+	blockHash, proof := testChallengeAnswer(t, blocks, challenge.BlockIndex)
+	_, err = assetTransferCC.AnswerStorageChallenge(transactionContext, "challengeTx3", blockHash, proof)
+	require.NoError(t, err)
+	ledger.as(myOrg2Msp)
+	ledger.setTxID("challengeTx4")
+	_, err = assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	require.NoError(t, err, "an answered challenge is settled")
+}
+
+func TestStorageChallengeNeedsBlockMerkleRoot(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	_, err := assetTransferCC.PostStorageChallenge(transactionContext, testDeviceName, testDataDate, myOrg3Msp)
+	assert.ErrorContains(t, err, "Merkle root")
+}
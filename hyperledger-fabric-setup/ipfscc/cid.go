@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// multicodecs lists the content codecs a CID may use.
+var multicodecs = map[uint64]string{
+	0x55:   "raw",
+	0x70:   "dag-pb",
+	0x71:   "dag-cbor",
+	0x0129: "dag-json",
+}
+
+type multihashFunction struct {
+	Name         string
+	DigestLength uint64
+}
+
+// multihashFunctions lists the hash functions a CID may use with the length of their digests.
+var multihashFunctions = map[uint64]multihashFunction{
+	0x12:   {Name: "sha2-256", DigestLength: 32},
+	0x13:   {Name: "sha2-512", DigestLength: 64},
+	0x16:   {Name: "sha3-256", DigestLength: 32},
+	0x14:   {Name: "sha3-512", DigestLength: 64},
+	0xb220: {Name: "blake2b-256", DigestLength: 32},
+}
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ContentID is a parsed IPFS CID. CIDv1 is the normalised base32 CIDv1 form of the CID, and Digest the hex
+// encoded digest clients can check fetched blocks against.
+type ContentID struct {
+	Version      int    `json:"version"`
+	Codec        string `json:"codec"`
+	HashFunction string `json:"hashFunction"`
+	Digest       string `json:"digest"`
+	CIDv1        string `json:"cidV1"`
+}
+
+func decodeBase58(encoded string) ([]byte, error) {
+	value := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range encoded {
+		index := strings.IndexRune(base58Alphabet, c)
+		if index < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(index)))
+	}
+	decoded := value.Bytes()
+	// Leading ones encode leading zero bytes.
+	zeros := 0
+	for zeros < len(encoded) && encoded[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), decoded...), nil
+}
+
+// decodeMultibase decodes a multibase string, whose first character names its encoding.
+func decodeMultibase(encoded string) ([]byte, error) {
+	if len(encoded) < 2 {
+		return nil, fmt.Errorf("multibase string is too short")
+	}
+	prefix, data := encoded[0], encoded[1:]
+	switch prefix {
+	case 'b':
+		return base32Lower.DecodeString(data)
+	case 'B':
+		return base32Lower.DecodeString(strings.ToLower(data))
+	case 'z':
+		return decodeBase58(data)
+	case 'f', 'F':
+		return hex.DecodeString(strings.ToLower(data))
+	case 'm':
+		return base64.RawStdEncoding.DecodeString(data)
+	case 'u':
+		return base64.RawURLEncoding.DecodeString(data)
+	}
+	return nil, fmt.Errorf("unsupported multibase prefix %q", prefix)
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	value, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("invalid varint")
+	}
+	return value, data[n:], nil
+}
+
+// parseMultihash checks that multihash uses a known hash function with a digest of the right length.
+func parseMultihash(multihash []byte) (multihashFunction, []byte, error) {
+	code, rest, err := readUvarint(multihash)
+	if err != nil {
+		return multihashFunction{}, nil, fmt.Errorf("invalid multihash function: %v", err)
+	}
+	function, ok := multihashFunctions[code]
+	if !ok {
+		return multihashFunction{}, nil, fmt.Errorf("unsupported multihash function 0x%x", code)
+	}
+	length, digest, err := readUvarint(rest)
+	if err != nil {
+		return multihashFunction{}, nil, fmt.Errorf("invalid multihash length: %v", err)
+	}
+	if length != function.DigestLength || uint64(len(digest)) != length {
+		return multihashFunction{}, nil, fmt.Errorf("%s digest must be %d bytes long, got %d", function.Name, function.DigestLength, len(digest))
+	}
+	return function, digest, nil
+}
+
+// ParseCID validates a CIDv0 or CIDv1 and returns it with its normalised CIDv1 form.
+func ParseCID(cid string) (*ContentID, error) {
+	var version int
+	var codec uint64
+	var multihash []byte
+
+	if len(cid) == 46 && strings.HasPrefix(cid, "Qm") {
+		// CIDv0 is a bare base58btc sha2-256 multihash of a dag-pb block.
+		decoded, err := decodeBase58(cid)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDv0 %q: %v", cid, err)
+		}
+		version, codec, multihash = 0, 0x70, decoded
+	} else {
+		decoded, err := decodeMultibase(cid)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID %q: %v", cid, err)
+		}
+		cidVersion, rest, err := readUvarint(decoded)
+		if err != nil || cidVersion != 1 {
+			return nil, fmt.Errorf("invalid CID %q: only CIDv0 and CIDv1 are supported", cid)
+		}
+		codec, multihash, err = readUvarint(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID %q: invalid codec", cid)
+		}
+		version = 1
+	}
+
+	codecName, ok := multicodecs[codec]
+	if !ok {
+		return nil, fmt.Errorf("invalid CID %q: unsupported codec 0x%x", cid, codec)
+	}
+	function, digest, err := parseMultihash(multihash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID %q: %v", cid, err)
+	}
+	if version == 0 && function.Name != "sha2-256" {
+		return nil, fmt.Errorf("invalid CIDv0 %q: only sha2-256 is allowed", cid)
+	}
+
+	normalised := binary.AppendUvarint([]byte{1}, codec)
+	normalised = append(normalised, multihash...)
+	return &ContentID{
+		Version:      version,
+		Codec:        codecName,
+		HashFunction: function.Name,
+		Digest:       hex.EncodeToString(digest),
+		CIDv1:        "b" + base32Lower.EncodeToString(normalised),
+	}, nil
+}
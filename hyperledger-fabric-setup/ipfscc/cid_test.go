@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCIDv1 = "bafybeie5nqv6kd3qnfjupgvz34woh3oksc3iau6abmyajn7qvtf6d2ho34"
+
+func TestParseCID(t *testing.T) {
+	v0, err := ParseCID(testCID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, v0.Version)
+	assert.Equal(t, "dag-pb", v0.Codec)
+	assert.Equal(t, "sha2-256", v0.HashFunction)
+	assert.Len(t, v0.Digest, 64)
+	assert.Equal(t, testCIDv1, v0.CIDv1)
+
+	v1, err := ParseCID(testCIDv1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, v1.Version)
+	assert.Equal(t, v0.Digest, v1.Digest)
+	assert.Equal(t, testCIDv1, v1.CIDv1)
+
+	upper, err := ParseCID(strings.ToUpper(testCIDv1))
+	require.NoError(t, err)
+	assert.Equal(t, testCIDv1, upper.CIDv1, "other multibases are normalised to base32")
+
+	raw, err := ParseCID("f01551220" + v0.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", raw.Codec)
+	assert.Equal(t, v0.Digest, raw.Digest)
+
+	invalid := []string{
+		"42421337",
+		"",
+		"QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbd0",
+		"f01551219" + v0.Digest[2:],
+		"f01551220" + v0.Digest[2:],
+		"f01991220" + v0.Digest,
+		"f02551220" + v0.Digest,
+		"x" + testCIDv1[1:],
+	}
+	for _, cid := range invalid {
+		_, err = ParseCID(cid)
+		assert.Error(t, err, cid)
+	}
+}
+
+func TestUploadRejectsInvalidCID(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+
+	assert.Error(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, "42421337", testDataDate, testMetadata))
+	assert.Error(t, assetTransferCC.UploadKeyPrivateData(transactionContext, testDeviceName, "42421337", testDataDate))
+	assert.Empty(t, ledger.state)
+
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, testDataDate, testMetadata))
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, testCID, asset.IPFS_CID)
+	require.NotNil(t, asset.ContentID)
+	assert.Equal(t, testCIDv1, asset.ContentID.CIDv1)
+	assert.Equal(t, "sha2-256", asset.ContentID.HashFunction)
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	configKey    = "config"
+	bootstrapKey = "bootstrap"
+)
+
+const (
+	ProposalKindConfig = "config"
+
+	ProposalStatusOpen     = "open"
+	ProposalStatusApplied  = "applied"
+	ProposalStatusRejected = "rejected"
+)
+
+// MarketConfig holds the channel wide marketplace settings. It is bootstrapped with InitLedger and changed
+// through governance proposals that a majority of MemberOrgs approve.
+type MarketConfig struct {
+	MemberOrgs               []string `json:"memberOrgs"`
+	AllowedDateFormats       []string `json:"allowedDateFormats"`
+	MinBidIncrement          int64    `json:"minBidIncrement"`
+	BidLifetimeSeconds       int64    `json:"bidLifetimeSeconds"`
+	ArbiterOrgs              []string `json:"arbiterOrgs"`
+	PlatformFeePercent       int      `json:"platformFeePercent"`
+	KeyDeliveryWindowSeconds int64    `json:"keyDeliveryWindowSeconds"`
+	// OrgCategories maps an org to its category, such as "research" or "utility", for device consent.
+	OrgCategories map[string]string `json:"orgCategories"`
+	// Purposes is the vocabulary buyers and licensees choose the purpose of their usage terms from.
+	Purposes []string `json:"purposes"`
+	// ValidatorOrgs can post quality attestations on assets.
+	ValidatorOrgs []string `json:"validatorOrgs"`
+	// StorageProviderOrgs run IPFS cluster peers and post pin attestations.
+	StorageProviderOrgs []string `json:"storageProviderOrgs"`
+	// ChallengeResponseSeconds is how long a storage org has to answer a storage challenge.
+	ChallengeResponseSeconds int64 `json:"challengeResponseSeconds"`
+	// AssetGranularities are the chunk sizes, as Go durations, that windowed assets may be uploaded in.
+	AssetGranularities []string `json:"assetGranularities"`
+	// ExternalChannels are the channels whose assets can be read and linked, see LinkExternalAsset.
+	ExternalChannels []string `json:"externalChannels"`
+	// Version counts the configs applied to the channel. It is set by the chaincode, starting at 1 with InitLedger.
+	Version int `json:"version"`
+}
+
+// GovernanceProposal is a change that needs approval from a majority of the member orgs before it is applied.
+type GovernanceProposal struct {
+	ProposalID  string `json:"proposalID"`
+	Kind        string `json:"kind"`
+	ProposerOrg string `json:"proposerOrg"`
+	Payload     string `json:"payload"`
+	// ConfigVersion is the version of the market config the proposal was made against.
+	ConfigVersion int      `json:"configVersion"`
+	Approvals     []string `json:"approvals"`
+	Rejections    []string `json:"rejections"`
+	Status        string   `json:"status"`
+	CreatedAt     string   `json:"createdAt"`
+}
+
+// ConfigBootstrap is the config InitLedger was called with, waiting for the approval of every org in its
+// MemberOrgs before it becomes the market config.
+type ConfigBootstrap struct {
+	Config    string   `json:"config"`
+	Approvals []string `json:"approvals"`
+}
+
+func CreateProposalID(txID string) string {
+	return "proposal_" + txID
+}
+
+// defaultMarketConfig is used until InitLedger is called. It already checks asset dates against the formats
+// below and sets working windows and purposes, but names no orgs: there are no member, arbiter, validator or
+// storage orgs until governance sets them.
+func defaultMarketConfig() *MarketConfig {
+	return &MarketConfig{
+		AllowedDateFormats:       []string{"2006-01-02", "02-01-2006"},
+		KeyDeliveryWindowSeconds: int64(24 * time.Hour / time.Second),
+		ChallengeResponseSeconds: int64(time.Hour / time.Second),
+		AssetGranularities:       []string{"15m", "1h", "24h"},
+		Purposes:                 []string{"energy research", "academic research", "grid operations", "product development", "marketing"},
+	}
+}
+
+func parseMarketConfig(config string) (*MarketConfig, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(config)))
+	decoder.DisallowUnknownFields()
+
+	var marketConfig MarketConfig
+	err := decoder.Decode(&marketConfig)
+	if err != nil {
+		return nil, fmt.Errorf("market config is not valid JSON: %v", err)
+	}
+	err = marketConfig.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &marketConfig, nil
+}
+
+func (c *MarketConfig) Validate() error {
+	if len(c.MemberOrgs) == 0 {
+		return fmt.Errorf("market config needs at least one member org")
+	}
+	if len(c.AllowedDateFormats) == 0 {
+		return fmt.Errorf("market config needs at least one allowed date format")
+	}
+	reference := time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, layout := range c.AllowedDateFormats {
+		parsed, err := time.Parse(layout, reference.Format(layout))
+		if err != nil || !parsed.Equal(reference) {
+			return fmt.Errorf("date format %q does not identify a single day", layout)
+		}
+	}
+	if c.MinBidIncrement < 0 || c.BidLifetimeSeconds < 0 {
+		return fmt.Errorf("minimum bid increment and bid lifetime must not be negative")
+	}
+	if c.PlatformFeePercent < 0 || c.PlatformFeePercent > 100 {
+		return fmt.Errorf("platform fee must be between 0 and 100 percent, got %d", c.PlatformFeePercent)
+	}
+	if c.KeyDeliveryWindowSeconds <= 0 {
+		return fmt.Errorf("key delivery window must be greater than zero")
+	}
+	if c.ChallengeResponseSeconds <= 0 {
+		return fmt.Errorf("storage challenge response window must be greater than zero")
+	}
+	if len(c.Purposes) == 0 {
+		return fmt.Errorf("market config needs at least one allowed purpose")
+	}
+	for _, channel := range c.ExternalChannels {
+		if channel == "" {
+			return fmt.Errorf("external channel names must not be empty")
+		}
+	}
+	for _, granularity := range c.AssetGranularities {
+		err := validateGranularity(granularity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsMember reports whether org takes part in governance votes.
+func (c *MarketConfig) IsMember(org string) bool {
+	return contains(c.MemberOrgs, org)
+}
+
+// ParseDate parses date with the first allowed date format that matches it.
+func (c *MarketConfig) ParseDate(date string) (time.Time, error) {
+	for _, layout := range c.AllowedDateFormats {
+		parsed, err := time.Parse(layout, date)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date %q does not match any of the allowed date formats %v", date, c.AllowedDateFormats)
+}
+
+// computePlatformFee returns the fee the platform takes from a sale at price.
+func computePlatformFee(config *MarketConfig, price string) (int64, error) {
+	if config.PlatformFeePercent == 0 {
+		return 0, nil
+	}
+	amount, err := parsePrice(price)
+	if err != nil {
+		return 0, fmt.Errorf("platform fee can't be computed: %v", err)
+	}
+	return amount * int64(config.PlatformFeePercent) / 100, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// getMarketConfig returns the current market config, or the defaults if the ledger was not initialised.
+func (s *SmartContract) getMarketConfig(ctx contractapi.TransactionContextInterface) (*MarketConfig, error) {
+	configBytes, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting market config: %v", err)
+	}
+	if configBytes == nil {
+		return defaultMarketConfig(), nil
+	}
+
+	var config MarketConfig
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal market config JSON: %v", err)
+	}
+	return &config, nil
+}
+
+func (s *SmartContract) putMarketConfig(ctx contractapi.TransactionContextInterface, config *MarketConfig) error {
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal market config to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(configKey, configBytes)
+}
+
+// GetMarketConfig returns the market config every transaction currently works with.
+func (s *SmartContract) GetMarketConfig(ctx contractapi.TransactionContextInterface) (*MarketConfig, error) {
+	return s.getMarketConfig(ctx)
+}
+
+// InitLedger bootstraps the market config of the channel. Only the member orgs of config can call it, and config
+// takes effect once every one of them called InitLedger with the same config, see GetConfigBootstrap. A member
+// calling it with a different config starts the bootstrap over. It can only succeed once, later changes go
+// through ProposeConfigUpdate and UpdateConfig.
+func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface, config string) error {
+	existing, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return fmt.Errorf("error ocurred getting market config: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("the ledger is already initialised, use ProposeConfigUpdate to change the config")
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	marketConfig, err := parseMarketConfig(config)
+	if err != nil {
+		return err
+	}
+	if !marketConfig.IsMember(mspid) {
+		return fmt.Errorf("only the member orgs of a config can bootstrap it, %s is not one of %v", mspid, marketConfig.MemberOrgs)
+	}
+
+	bootstrap, err := s.GetConfigBootstrap(ctx)
+	if err != nil {
+		return err
+	}
+	if bootstrap == nil || bootstrap.Config != config {
+		bootstrap = &ConfigBootstrap{Config: config}
+	}
+	if !contains(bootstrap.Approvals, mspid) {
+		bootstrap.Approvals = append(bootstrap.Approvals, mspid)
+	}
+	if countMembers(marketConfig, bootstrap.Approvals) < len(marketConfig.MemberOrgs) {
+		bootstrapBytes, err := json.Marshal(bootstrap)
+		if err != nil {
+			return fmt.Errorf("failed to marshal config bootstrap to JSON: %v", err)
+		}
+		return ctx.GetStub().PutState(bootstrapKey, bootstrapBytes)
+	}
+
+	err = ctx.GetStub().DelState(bootstrapKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete config bootstrap: %v", err)
+	}
+	marketConfig.Version = 1
+	return s.putMarketConfig(ctx, marketConfig)
+}
+
+// GetConfigBootstrap returns the config waiting for the approval of its member orgs, nil if there is none.
+func (s *SmartContract) GetConfigBootstrap(ctx contractapi.TransactionContextInterface) (*ConfigBootstrap, error) {
+	bootstrapBytes, err := ctx.GetStub().GetState(bootstrapKey)
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting config bootstrap: %v", err)
+	}
+	if bootstrapBytes == nil {
+		return nil, nil
+	}
+
+	var bootstrap ConfigBootstrap
+	err = json.Unmarshal(bootstrapBytes, &bootstrap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config bootstrap JSON: %v", err)
+	}
+	return &bootstrap, nil
+}
+
+// createProposal records a governance proposal of the calling member org, which counts as its approval.
+func (s *SmartContract) createProposal(ctx contractapi.TransactionContextInterface, kind string, payload string) (string, error) {
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	existing, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting market config: %v", err)
+	}
+	if existing == nil {
+		return "", fmt.Errorf("the ledger must be initialised with InitLedger before proposals can be made")
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !config.IsMember(mspid) {
+		return "", fmt.Errorf("only member orgs can make proposals")
+	}
+	err = s.checkOrgNotFrozen(ctx, mspid)
+	if err != nil {
+		return "", err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	proposal := GovernanceProposal{
+		ProposalID:    ctx.GetStub().GetTxID(),
+		Kind:          kind,
+		ProposerOrg:   mspid,
+		Payload:       payload,
+		ConfigVersion: config.Version,
+		Approvals:     []string{mspid},
+		Status:        ProposalStatusOpen,
+		CreatedAt:     now.UTC().Format(time.RFC3339),
+	}
+	err = s.putProposal(ctx, &proposal)
+	if err != nil {
+		return "", err
+	}
+	return proposal.ProposalID, nil
+}
+
+func (s *SmartContract) putProposal(ctx contractapi.TransactionContextInterface, proposal *GovernanceProposal) error {
+	proposalBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return fmt.Errorf("failed to marshal proposal to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateProposalID(proposal.ProposalID), proposalBytes)
+}
+
+// GetProposal returns the governance proposal created in transaction proposalID.
+func (s *SmartContract) GetProposal(ctx contractapi.TransactionContextInterface, proposalID string) (*GovernanceProposal, error) {
+	proposalBytes, err := ctx.GetStub().GetState(CreateProposalID(proposalID))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting proposal: %v", err)
+	}
+	if proposalBytes == nil {
+		return nil, fmt.Errorf("proposal %s does not exist", proposalID)
+	}
+
+	var proposal GovernanceProposal
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proposal JSON: %v", err)
+	}
+	return &proposal, nil
+}
+
+// VoteOnProposal records the calling member org's approval or rejection of an open proposal. A proposal that
+// a majority rejects can no longer be applied.
+func (s *SmartContract) VoteOnProposal(ctx contractapi.TransactionContextInterface, proposalID string, approve bool) error {
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !config.IsMember(mspid) {
+		return fmt.Errorf("only member orgs can vote on proposals")
+	}
+	err = s.checkOrgNotFrozen(ctx, mspid)
+	if err != nil {
+		return err
+	}
+	proposal, err := s.GetProposal(ctx, proposalID)
+	if err != nil {
+		return err
+	}
+	if proposal.Status != ProposalStatusOpen {
+		return fmt.Errorf("proposal %s is already %s", proposalID, proposal.Status)
+	}
+	if contains(proposal.Approvals, mspid) || contains(proposal.Rejections, mspid) {
+		return fmt.Errorf("%s already voted on proposal %s", mspid, proposalID)
+	}
+
+	if approve {
+		proposal.Approvals = append(proposal.Approvals, mspid)
+	} else {
+		proposal.Rejections = append(proposal.Rejections, mspid)
+		if countMembers(config, proposal.Rejections)*2 > len(config.MemberOrgs) {
+			proposal.Status = ProposalStatusRejected
+		}
+	}
+	return s.putProposal(ctx, proposal)
+}
+
+// countMembers counts the orgs in votes that are still member orgs.
+func countMembers(config *MarketConfig, votes []string) int {
+	count := 0
+	for _, org := range votes {
+		if config.IsMember(org) {
+			count++
+		}
+	}
+	return count
+}
+
+// approvedProposal returns the open proposal of the given kind, if a majority of the current member orgs approved it.
+func (s *SmartContract) approvedProposal(ctx contractapi.TransactionContextInterface, proposalID string, kind string) (*GovernanceProposal, error) {
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	proposal, err := s.GetProposal(ctx, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Kind != kind {
+		return nil, fmt.Errorf("proposal %s is a %s proposal, not a %s proposal", proposalID, proposal.Kind, kind)
+	}
+	if proposal.Status != ProposalStatusOpen {
+		return nil, fmt.Errorf("proposal %s is already %s", proposalID, proposal.Status)
+	}
+	approvals := countMembers(config, proposal.Approvals)
+	if approvals*2 <= len(config.MemberOrgs) {
+		return nil, fmt.Errorf("proposal %s is approved by %d of %d member orgs, a majority is required", proposalID, approvals, len(config.MemberOrgs))
+	}
+	return proposal, nil
+}
+
+// ProposeConfigUpdate proposes replacing the market config with config, and returns the proposal ID.
+func (s *SmartContract) ProposeConfigUpdate(ctx contractapi.TransactionContextInterface, config string) (string, error) {
+	_, err := parseMarketConfig(config)
+	if err != nil {
+		return "", err
+	}
+	return s.createProposal(ctx, ProposalKindConfig, config)
+}
+
+// UpdateConfig applies a config proposal once a majority of the member orgs approved it. The proposal must have
+// been made against the current config, a proposal overtaken by another config change has to be made again.
+func (s *SmartContract) UpdateConfig(ctx contractapi.TransactionContextInterface, proposalID string) error {
+	proposal, err := s.approvedProposal(ctx, proposalID, ProposalKindConfig)
+	if err != nil {
+		return err
+	}
+	current, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if proposal.ConfigVersion != current.Version {
+		return fmt.Errorf("proposal %s was made against config version %d, the config is now at version %d", proposalID, proposal.ConfigVersion, current.Version)
+	}
+	marketConfig, err := parseMarketConfig(proposal.Payload)
+	if err != nil {
+		return err
+	}
+	marketConfig.Version = current.Version + 1
+	err = s.putMarketConfig(ctx, marketConfig)
+	if err != nil {
+		return err
+	}
+
+	proposal.Status = ProposalStatusApplied
+	return s.putProposal(ctx, proposal)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMarketConfigJSON(t *testing.T, change func(config *MarketConfig)) string {
+	config := defaultMarketConfig()
+	config.MemberOrgs = []string{myOrg1Msp, myOrg2Msp, myOrg3Msp}
+	change(config)
+	configBytes, err := json.Marshal(config)
+	require.NoError(t, err)
+	return string(configBytes)
+}
+
+func TestInitLedger(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+
+	config, err := assetTransferCC.GetMarketConfig(transactionContext)
+	require.NoError(t, err)
+	assert.Equal(t, defaultMarketConfig(), config, "defaults apply before the ledger is initialised")
+
+	assert.Error(t, assetTransferCC.InitLedger(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) { c.MemberOrgs = nil })))
+	assert.Error(t, assetTransferCC.InitLedger(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) { c.AllowedDateFormats = []string{"2006-01"} })))
+	assert.Error(t, assetTransferCC.InitLedger(transactionContext, `{"memberOrgs":["a"],"unknown":1}`))
+	assert.Error(t, assetTransferCC.InitLedger(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) { c.Purposes = nil })))
+	initTestLedger(t, ledger, testMarketConfigJSON(t, func(c *MarketConfig) { c.PlatformFeePercent = 5 }))
+	assert.Error(t, assetTransferCC.InitLedger(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) {})), "the ledger is initialised once")
+
+	config, err = assetTransferCC.GetMarketConfig(transactionContext)
+	require.NoError(t, err)
+	assert.Equal(t, 5, config.PlatformFeePercent)
+	assert.NotNil(t, ledger.state[configKey])
+}
+
+// initTestLedger bootstraps config with the approval of each of its member orgs.
+func initTestLedger(t *testing.T, ledger *mockLedger, config string) {
+	assetTransferCC := SmartContract{}
+	marketConfig, err := parseMarketConfig(config)
+	require.NoError(t, err)
+	for _, org := range marketConfig.MemberOrgs {
+		ledger.as(org)
+		require.NoError(t, assetTransferCC.InitLedger(ledger.ctx, config))
+	}
+}
+
+func TestInitLedgerNeedsEveryMember(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg4Msp)
+	assetTransferCC := SmartContract{}
+	config := testMarketConfigJSON(t, func(c *MarketConfig) {})
+
+	assert.ErrorContains(t, assetTransferCC.InitLedger(transactionContext, config), "only the member orgs", "a non-member can't bootstrap")
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.InitLedger(transactionContext, config))
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.InitLedger(transactionContext, config))
+	assert.Nil(t, ledger.state[configKey], "the config waits for every member")
+
+	ledger.as(myOrg3Msp)
+	other := testMarketConfigJSON(t, func(c *MarketConfig) { c.PlatformFeePercent = 50 })
+	require.NoError(t, assetTransferCC.InitLedger(transactionContext, other))
+	bootstrap, err := assetTransferCC.GetConfigBootstrap(transactionContext)
+	require.NoError(t, err)
+	assert.Equal(t, []string{myOrg3Msp}, bootstrap.Approvals, "a different config starts the bootstrap over")
+
+	initTestLedger(t, ledger, config)
+	assert.NotNil(t, ledger.state[configKey])
+	assert.Nil(t, ledger.state[bootstrapKey])
+	marketConfig, err := assetTransferCC.GetMarketConfig(transactionContext)
+	require.NoError(t, err)
+	assert.Equal(t, 0, marketConfig.PlatformFeePercent)
+}
+
+func TestUpdateConfigNeedsMajority(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+
+	_, err := assetTransferCC.ProposeConfigUpdate(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) {}))
+	assert.Error(t, err, "proposals need an initialised ledger")
+	initTestLedger(t, ledger, testMarketConfigJSON(t, func(c *MarketConfig) {}))
+
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("proposalTx")
+	proposalID, err := assetTransferCC.ProposeConfigUpdate(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) { c.MinBidIncrement = 25 }))
+	require.NoError(t, err)
+	assert.Equal(t, "proposalTx", proposalID)
+
+	assert.Error(t, assetTransferCC.UpdateConfig(transactionContext, proposalID), "one of three members is not a majority")
+	assert.Error(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, true), "the proposer already approved")
+	ledger.as(myOrg4Msp)
+	assert.Error(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, true), "only members vote")
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, true))
+	require.NoError(t, assetTransferCC.UpdateConfig(transactionContext, proposalID))
+	assert.Error(t, assetTransferCC.UpdateConfig(transactionContext, proposalID), "a proposal is applied once")
+
+	config, err := assetTransferCC.GetMarketConfig(transactionContext)
+	require.NoError(t, err)
+	assert.Equal(t, int64(25), config.MinBidIncrement)
+
+	ledger.setTxID("rejectedTx")
+	proposalID, err = assetTransferCC.ProposeConfigUpdate(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) {}))
+	require.NoError(t, err)
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, false))
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, false))
+	proposal, err := assetTransferCC.GetProposal(transactionContext, proposalID)
+	require.NoError(t, err)
+	assert.Equal(t, ProposalStatusRejected, proposal.Status)
+}
+
+func TestUpdateConfigRejectsOvertakenProposal(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	initTestLedger(t, ledger, testMarketConfigJSON(t, func(c *MarketConfig) {}))
+
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("feeTx")
+	feeProposal, err := assetTransferCC.ProposeConfigUpdate(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) { c.PlatformFeePercent = 5 }))
+	require.NoError(t, err)
+	ledger.setTxID("incrementTx")
+	incrementProposal, err := assetTransferCC.ProposeConfigUpdate(transactionContext, testMarketConfigJSON(t, func(c *MarketConfig) { c.MinBidIncrement = 25 }))
+	require.NoError(t, err)
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.VoteOnProposal(transactionContext, feeProposal, true))
+	require.NoError(t, assetTransferCC.VoteOnProposal(transactionContext, incrementProposal, true))
+
+	require.NoError(t, assetTransferCC.UpdateConfig(transactionContext, incrementProposal))
+	assert.ErrorContains(t, assetTransferCC.UpdateConfig(transactionContext, feeProposal), "version", "the fee proposal would undo the increment")
+
+	config, err := assetTransferCC.GetMarketConfig(transactionContext)
+	require.NoError(t, err)
+	assert.Equal(t, 2, config.Version)
+	assert.Equal(t, int64(25), config.MinBidIncrement)
+	assert.Equal(t, 0, config.PlatformFeePercent)
+}
+
+func TestConfigIsReadByTransactions(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(config *MarketConfig) {
+		config.AllowedDateFormats = []string{"02-01-2006"}
+		config.BidLifetimeSeconds = 3600
+		config.MinBidIncrement = 50
+		config.PlatformFeePercent = 10
+	})
+
+	assert.Error(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-02", testMetadata), "date format is not allowed")
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	start := time.Date(2000, 2, 3, 9, 0, 0, 0, time.UTC)
+	ledger.setTime(start)
+	assert.Error(t, assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", start.Add(time.Hour).Format(time.RFC3339), testUsageTerms), "increment is below the configured minimum")
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "200", "", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	ledger.setTime(start.Add(2 * time.Hour))
+	bids, err := assetTransferCC.GetBidsForMyOrg(transactionContext)
+	require.NoError(t, err)
+	assert.Len(t, bids, 0, "the bid expired")
+	assert.Error(t, assetTransferCC.AcceptBid(transactionContext, myOrg2Msp, testDeviceName, testDataDate, "200"))
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "300", "", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("feeTx")
+	require.NoError(t, assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "300"))
+
+	var record TransferRecord
+	ledger.getJSON(t, CreateTransferID(testDeviceName, testDataDate, "feeTx"), &record)
+	assert.Equal(t, int64(30), record.PlatformFee)
+}
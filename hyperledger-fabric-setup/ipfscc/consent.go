@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ConsentClauseExpiry   = "expiresAt"
+	ConsentClausePurposes = "allowedPurposes"
+	ConsentClauseBuyers   = "allowedBuyers"
+)
+
+// Consent records what the residents behind a device agreed their data may be used for. Empty lists allow
+// anything, and a buyer is allowed if either its org or its category from the market config is listed.
+// ExpiresAt is an RFC3339 timestamp, empty for consent that does not expire. Devices without a consent
+// record can be traded freely.
+type Consent struct {
+	DeviceName             string   `json:"deviceName"`
+	GrantorOrg             string   `json:"grantorOrg"`
+	AllowedPurposes        []string `json:"allowedPurposes"`
+	AllowedBuyerOrgs       []string `json:"allowedBuyerOrgs"`
+	AllowedBuyerCategories []string `json:"allowedBuyerCategories"`
+	ExpiresAt              string   `json:"expiresAt"`
+	UpdatedAt              string   `json:"updatedAt"`
+}
+
+// ConsentViolation is returned when a transfer falls outside the consent of a device, and names the clause
+// that blocked it.
+type ConsentViolation struct {
+	DeviceName string
+	Clause     string
+	Reason     string
+}
+
+func (v *ConsentViolation) Error() string {
+	return fmt.Sprintf("consent for device %s blocks the transfer, clause %q: %s", v.DeviceName, v.Clause, v.Reason)
+}
+
+func CreateConsentID(deviceName string) string {
+	return "consent_" + deviceName
+}
+
+func parseConsent(consent string) (*Consent, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(consent)))
+	decoder.DisallowUnknownFields()
+	var parsed Consent
+	err := decoder.Decode(&parsed)
+	if err != nil {
+		return nil, fmt.Errorf("consent is not valid JSON: %v", err)
+	}
+	if parsed.ExpiresAt != "" {
+		_, err = time.Parse(time.RFC3339, parsed.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("consent expiry must be an RFC3339 timestamp: %v", err)
+		}
+	}
+	return &parsed, nil
+}
+
+// check returns a ConsentViolation if a transfer to buyerOrg for purpose is not covered by the consent.
+// An empty buyerOrg only checks the purpose, for listings where the buyer is not known yet.
+func (c *Consent) check(config *MarketConfig, now time.Time, buyerOrg string, purpose string) error {
+	if c.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, c.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("invalid consent expiry: %v", err)
+		}
+		if !now.Before(expiresAt) {
+			return &ConsentViolation{DeviceName: c.DeviceName, Clause: ConsentClauseExpiry, Reason: "consent expired at " + c.ExpiresAt}
+		}
+	}
+	if len(c.AllowedPurposes) > 0 && !contains(c.AllowedPurposes, purpose) {
+		return &ConsentViolation{DeviceName: c.DeviceName, Clause: ConsentClausePurposes, Reason: fmt.Sprintf("purpose %q is not allowed", purpose)}
+	}
+	if buyerOrg == "" || (len(c.AllowedBuyerOrgs) == 0 && len(c.AllowedBuyerCategories) == 0) {
+		return nil
+	}
+	if contains(c.AllowedBuyerOrgs, buyerOrg) {
+		return nil
+	}
+	category := config.OrgCategories[buyerOrg]
+	if category != "" && contains(c.AllowedBuyerCategories, category) {
+		return nil
+	}
+	return &ConsentViolation{DeviceName: c.DeviceName, Clause: ConsentClauseBuyers, Reason: fmt.Sprintf("org %s in category %q is not allowed", buyerOrg, category)}
+}
+
+func (s *SmartContract) getConsent(ctx contractapi.TransactionContextInterface, deviceName string) (*Consent, error) {
+	consentBytes, err := ctx.GetStub().GetState(CreateConsentID(deviceName))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting consent: %v", err)
+	}
+	if consentBytes == nil {
+		return nil, nil
+	}
+
+	var consent Consent
+	err = json.Unmarshal(consentBytes, &consent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal consent JSON: %v", err)
+	}
+	return &consent, nil
+}
+
+// checkConsent fails if the device has a consent record that does not cover a transfer to buyerOrg under terms.
+func (s *SmartContract) checkConsent(ctx contractapi.TransactionContextInterface, deviceName string, buyerOrg string, terms *UsageTerms) error {
+	consent, err := s.getConsent(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+	if consent == nil {
+		return nil
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	purpose := ""
+	if terms != nil {
+		purpose = terms.Purpose
+	}
+	return consent.check(config, now, buyerOrg, purpose)
+}
+
+// GetConsent returns the consent recorded for deviceName.
+func (s *SmartContract) GetConsent(ctx contractapi.TransactionContextInterface, deviceName string) (*Consent, error) {
+	consent, err := s.getConsent(ctx, deviceName)
+	if err != nil {
+		return nil, err
+	}
+	if consent == nil {
+		return nil, fmt.Errorf("no consent recorded for device %s", deviceName)
+	}
+	return consent, nil
+}
+
+// SetConsent records the consent of the residents behind deviceName as a JSON encoded Consent. Only the operator
+// of the device can set it, the org that registered it with RegisterDevice.
+func (s *SmartContract) SetConsent(ctx contractapi.TransactionContextInterface, deviceName string, consent string) error {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	parsed, err := parseConsent(consent)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.getConsent(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.GrantorOrg != mspid {
+		return fmt.Errorf("only %s can change the consent of device %s", existing.GrantorOrg, deviceName)
+	}
+	device, err := s.getDevice(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+	if device == nil || device.OperatorOrg != mspid {
+		return fmt.Errorf("only the operator of device %s can record its consent", deviceName)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	parsed.DeviceName = deviceName
+	parsed.GrantorOrg = mspid
+	parsed.UpdatedAt = now.UTC().Format(time.RFC3339)
+	consentBytes, err := json.Marshal(parsed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal consent to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateConsentID(deviceName), consentBytes)
+}
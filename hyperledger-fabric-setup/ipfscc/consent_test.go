@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertConsentClause(t *testing.T, err error, clause string) {
+	var violation *ConsentViolation
+	require.True(t, errors.As(err, &violation), "expected a consent violation, got %v", err)
+	assert.Equal(t, clause, violation.Clause)
+	assert.Contains(t, err.Error(), clause)
+}
+
+func TestSetConsent(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.RegisterDevice(transactionContext, testDeviceName))
+
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{}`), "only the device operator records consent")
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{"unknown":true}`))
+	assert.Error(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{"expiresAt":"tomorrow"}`))
+	require.NoError(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{"allowedPurposes":["energy research"]}`))
+
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{}`), "new owners cannot change consent")
+
+	consent, err := assetTransferCC.GetConsent(transactionContext, testDeviceName)
+	require.NoError(t, err)
+	assert.Equal(t, myOrg1Msp, consent.GrantorOrg)
+	assert.Equal(t, []string{"energy research"}, consent.AllowedPurposes)
+}
+
+func TestConsentBelongsToTheDeviceOperator(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	assert.Error(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{}`), "unregistered devices have no operator")
+
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.RegisterDevice(transactionContext, testDeviceName), "has not uploaded data", "only producers register a device")
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.RegisterDevice(transactionContext, testDeviceName))
+	device, err := assetTransferCC.GetDevice(transactionContext, testDeviceName)
+	require.NoError(t, err)
+	assert.Equal(t, myOrg1Msp, device.OperatorOrg)
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000", testMetadata),
+		"uploads don't depend on the device registration")
+	assert.ErrorContains(t, assetTransferCC.RegisterDevice(transactionContext, testDeviceName), "already operated by "+myOrg1Msp)
+	assert.Error(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{}`), "other producers can't take over the consent")
+
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{"allowedPurposes":["energy research"]}`))
+}
+
+func TestConsentBlocksTransfers(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) {
+		c.OrgCategories = map[string]string{myOrg2Msp: "research", myOrg3Msp: "advertising"}
+	})
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.RegisterDevice(transactionContext, testDeviceName))
+	require.NoError(t, assetTransferCC.SetConsent(transactionContext, testDeviceName, `{"allowedPurposes":["energy research"],"allowedBuyerCategories":["research"],"expiresAt":"2000-03-01T00:00:00Z"}`))
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	err := assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "100")
+	assertConsentClause(t, err, ConsentClauseBuyers)
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", `{"purpose":"marketing"}`))
+	ledger.as(myOrg1Msp)
+	err = assetTransferCC.GrantLicence(transactionContext, myOrg2Msp, testDeviceName, testDataDate)
+	assertConsentClause(t, err, ConsentClausePurposes)
+
+	err = assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", "2000-02-03T00:00:00Z", `{"purpose":"marketing"}`)
+	assertConsentClause(t, err, ConsentClausePurposes)
+
+	ledger.setTime(time.Date(2000, time.March, 1, 0, 0, 0, 0, time.UTC))
+	err = sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100")
+	assertConsentClause(t, err, ConsentClauseExpiry)
+
+	ledger.setTime(time.Date(2000, time.February, 10, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+}
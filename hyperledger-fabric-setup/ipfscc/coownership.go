@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// OwnerShare is the percentage of a co-owned asset held by one org.
+type OwnerShare struct {
+	Org          string `json:"org"`
+	SharePercent int    `json:"sharePercent"`
+}
+
+// Proceeds is the part of a sale price owed to one of the sellers.
+type Proceeds struct {
+	Org    string `json:"org"`
+	Amount int64  `json:"amount"`
+}
+
+// ShareOf returns the percentage of the asset held by org. A single owner holds 100 percent.
+func (a *DataAsset) ShareOf(org string) int {
+	if len(a.CoOwners) == 0 {
+		if a.OwnerOrg == org {
+			return 100
+		}
+		return 0
+	}
+	for _, share := range a.CoOwners {
+		if share.Org == org {
+			return share.SharePercent
+		}
+	}
+	return 0
+}
+
+// SaleApproval is the approval of a co-owner for the sale of a co-owned asset to BiddingOrg, or to any buyer if
+// BiddingOrg is empty, at MinPrice or more. It holds until the bidding on the asset ends at the end of SaleEpoch.
+// Approvals are kept apart from the bids, so a bidder placing its bid again doesn't drop them.
+type SaleApproval struct {
+	DeviceName  string `json:"deviceName"`
+	Date        string `json:"date"`
+	ApproverOrg string `json:"approverOrg"`
+	BiddingOrg  string `json:"biddingOrg"`
+	MinPrice    int64  `json:"minPrice"`
+	SaleEpoch   int    `json:"saleEpoch"`
+}
+
+func CreateSaleApprovalID(deviceName string, date string, approverOrg string, biddingOrg string) string {
+	return "saleApproval_" + deviceName + "_" + date + "_" + approverOrg + "_" + biddingOrg
+}
+
+func (s *SmartContract) putSaleApproval(ctx contractapi.TransactionContextInterface, approval *SaleApproval) error {
+	approvalBytes, err := json.Marshal(approval)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sale approval to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateSaleApprovalID(approval.DeviceName, approval.Date, approval.ApproverOrg, approval.BiddingOrg), approvalBytes)
+}
+
+// hasSaleApproval reports whether approverOrg approved the sale of asset to the bidder of bid at its price.
+func (s *SmartContract) hasSaleApproval(ctx contractapi.TransactionContextInterface, asset *DataAsset, approverOrg string, bid *DataBid) (bool, error) {
+	price, err := parsePrice(bid.Price)
+	if err != nil {
+		return false, err
+	}
+	for _, biddingOrg := range []string{bid.BiddingOrg, ""} {
+		approvalBytes, err := ctx.GetStub().GetState(CreateSaleApprovalID(asset.AssetName, asset.Date, approverOrg, biddingOrg))
+		if err != nil {
+			return false, fmt.Errorf("error ocurred getting sale approval: %v", err)
+		}
+		if approvalBytes == nil {
+			continue
+		}
+		var approval SaleApproval
+		err = json.Unmarshal(approvalBytes, &approval)
+		if err != nil {
+			return false, fmt.Errorf("failed to unmarshal sale approval JSON: %v", err)
+		}
+		if approval.SaleEpoch == asset.SaleEpoch && price >= approval.MinPrice {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkCoOwnerApproval fails if the co-owners that approved the sale to the bidder of bid hold no more than the
+// approval threshold. The managing owner accepting the bid counts as an approval.
+func (s *SmartContract) checkCoOwnerApproval(ctx contractapi.TransactionContextInterface, asset *DataAsset, bid *DataBid) error {
+	if len(asset.CoOwners) == 0 {
+		return nil
+	}
+
+	approvedShare := asset.ShareOf(bid.CurrentOwnerOrg)
+	for _, share := range asset.CoOwners {
+		if share.Org == bid.CurrentOwnerOrg {
+			continue
+		}
+		approved, err := s.hasSaleApproval(ctx, asset, share.Org, bid)
+		if err != nil {
+			return err
+		}
+		if approved {
+			approvedShare += share.SharePercent
+		}
+	}
+	if approvedShare <= asset.ApprovalThresholdPercent {
+		return fmt.Errorf("co-owners holding %d%% approved the sale, more than %d%% is required", approvedShare, asset.ApprovalThresholdPercent)
+	}
+	return nil
+}
+
+// splitProceeds divides the sale price, less the platform fee, between the co-owners according to their shares.
+// Rounding leftovers go to the managing owner. Single owner assets have no recorded proceeds.
+func splitProceeds(asset *DataAsset, price string, platformFee int64) ([]Proceeds, error) {
+	if len(asset.CoOwners) == 0 {
+		return nil, nil
+	}
+
+	amount, err := parsePrice(price)
+	if err != nil {
+		return nil, fmt.Errorf("proceeds can't be split between co-owners: %v", err)
+	}
+	amount -= platformFee
+	var proceeds []Proceeds
+	remainder := amount
+	for _, share := range asset.CoOwners {
+		part := amount * int64(share.SharePercent) / 100
+		remainder -= part
+		proceeds = append(proceeds, Proceeds{Org: share.Org, Amount: part})
+	}
+	for i := range proceeds {
+		if proceeds[i].Org == asset.OwnerOrg {
+			proceeds[i].Amount += remainder
+		}
+	}
+	return proceeds, nil
+}
+
+func validateOwnerShares(shares []OwnerShare, managingOrg string) error {
+	total := 0
+	seen := map[string]bool{}
+	for _, share := range shares {
+		if share.Org == "" || share.SharePercent <= 0 {
+			return fmt.Errorf("every co-owner needs an org and a positive share")
+		}
+		if seen[share.Org] {
+			return fmt.Errorf("co-owner %s is listed more than once", share.Org)
+		}
+		seen[share.Org] = true
+		total += share.SharePercent
+	}
+	if total != 100 {
+		return fmt.Errorf("co-owner shares add up to %d%%, not 100%%", total)
+	}
+	if !seen[managingOrg] {
+		return fmt.Errorf("the current owner %s must be one of the co-owners", managingOrg)
+	}
+	return nil
+}
+
+// SetCoOwners splits ownership of an asset owned by the calling org alone between the orgs in ownerShares, which is
+// a JSON array of OwnerShare. Sales then need approval from co-owners holding more than approvalThresholdPercent.
+// The symmetric key in the transient map under "symmetricKey" is delivered to every co-owner.
+func (s *SmartContract) SetCoOwners(ctx contractapi.TransactionContextInterface, deviceName string, date string, ownerShares string, approvalThresholdPercent int) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if asset.OwnerOrg != mspid || len(asset.CoOwners) > 0 {
+		return fmt.Errorf("only the single owner of asset %s can set its co-owners", CreateAssetID(deviceName, date))
+	}
+
+	var shares []OwnerShare
+	err = json.Unmarshal([]byte(ownerShares), &shares)
+	if err != nil {
+		return fmt.Errorf("owner shares are not valid JSON: %v", err)
+	}
+	err = validateOwnerShares(shares, mspid)
+	if err != nil {
+		return err
+	}
+	if approvalThresholdPercent < 0 || approvalThresholdPercent >= 100 {
+		return fmt.Errorf("approval threshold must be between 0 and 99 percent, got %d", approvalThresholdPercent)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+	symmetricKey, ok := transientMap["symmetricKey"]
+	if !ok {
+		return fmt.Errorf("symmetricKey must be passed in the transient map to deliver it to the co-owners")
+	}
+	keyData := KeyCIDAsset{
+		Date:         date,
+		DeviceName:   deviceName,
+		IPFS_CID:     asset.IPFS_CID,
+		SymmetricKey: string(symmetricKey),
+	}
+	keyBytes, err := json.Marshal(keyData)
+	if err != nil {
+		return fmt.Errorf("error ocurred marshalling JSON to Byte array: %v", err)
+	}
+	for _, share := range shares {
+		if share.Org == mspid {
+			continue
+		}
+		err = ctx.GetStub().PutPrivateData("_implicit_org_"+share.Org, CreateAssetID(deviceName, date), keyBytes)
+		if err != nil {
+			return fmt.Errorf("error putting key into implicit collection of %s: %v", share.Org, err)
+		}
+		err = s.recordKeyDelivered(ctx, deviceName, date, mspid, share.Org)
+		if err != nil {
+			return err
+		}
+	}
+
+	asset.CoOwners = shares
+	asset.ApprovalThresholdPercent = approvalThresholdPercent
+	return s.putDataAsset(ctx, asset)
+}
+
+// ApproveBid records the calling co-owner's approval of a bid on a co-owned asset. The approval also holds for
+// a later bid of biddingOrg at a higher price, until the bidding on the asset ends.
+func (s *SmartContract) ApproveBid(ctx contractapi.TransactionContextInterface, biddingOrg string, deviceName string, date string, price string) error {
+	asset, mspid, err := s.getAssetToApprove(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+
+	bidID := "bid_" + deviceName + "_" + date + "_" + asset.OwnerOrg + "_" + biddingOrg
+	bidBytes, err := ctx.GetStub().GetState(bidID)
+	if err != nil {
+		return fmt.Errorf("error ocurred getting bid to approve: %v", err)
+	}
+	var bid DataBid
+	err = json.Unmarshal(bidBytes, &bid)
+	if err != nil || price != bid.Price || !bid.Active {
+		return fmt.Errorf("error ocurred processing bid. mismatch between provided bid details, and bid recorded on ledger")
+	}
+	if bid.IsStale(asset) {
+		return fmt.Errorf("bid from %s was placed before the bidding on asset %s ended", biddingOrg, CreateAssetID(deviceName, date))
+	}
+	amount, err := parsePrice(price)
+	if err != nil {
+		return err
+	}
+
+	return s.putSaleApproval(ctx, &SaleApproval{
+		DeviceName:  deviceName,
+		Date:        date,
+		ApproverOrg: mspid,
+		BiddingOrg:  biddingOrg,
+		MinPrice:    amount,
+		SaleEpoch:   asset.SaleEpoch,
+	})
+}
+
+// ApproveSale records the calling co-owner's approval of a sale of a co-owned asset to any buyer at minPrice or
+// more, until the bidding on the asset ends. Co-owners approve auctions this way once the auction is started,
+// as starting it ends the bidding that came before.
+func (s *SmartContract) ApproveSale(ctx contractapi.TransactionContextInterface, deviceName string, date string, minPrice string) error {
+	asset, mspid, err := s.getAssetToApprove(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	amount, err := parsePrice(minPrice)
+	if err != nil {
+		return fmt.Errorf("invalid minimum price: %v", err)
+	}
+
+	return s.putSaleApproval(ctx, &SaleApproval{
+		DeviceName:  deviceName,
+		Date:        date,
+		ApproverOrg: mspid,
+		MinPrice:    amount,
+		SaleEpoch:   asset.SaleEpoch,
+	})
+}
+
+// ApproveLicence records the calling co-owner's approval of the licence licenseeOrg requested for a co-owned
+// asset. It is kept as an approval of a sale to licenseeOrg at the licence price, until the bidding on the
+// asset ends.
+func (s *SmartContract) ApproveLicence(ctx contractapi.TransactionContextInterface, licenseeOrg string, deviceName string, date string) error {
+	asset, mspid, err := s.getAssetToApprove(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	licence, err := s.GetLicence(ctx, licenseeOrg, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if licence.Status != LicenceStatusRequested {
+		return fmt.Errorf("licence for %s is already %s", licenseeOrg, licence.Status)
+	}
+	amount, err := parsePrice(licence.Price)
+	if err != nil {
+		return err
+	}
+
+	return s.putSaleApproval(ctx, &SaleApproval{
+		DeviceName:  deviceName,
+		Date:        date,
+		ApproverOrg: mspid,
+		BiddingOrg:  licenseeOrg,
+		MinPrice:    amount,
+		SaleEpoch:   asset.SaleEpoch,
+	})
+}
+
+// getAssetToApprove returns the co-owned asset of deviceName on date and the calling org, which must be one of
+// its co-owners.
+func (s *SmartContract) getAssetToApprove(ctx contractapi.TransactionContextInterface, deviceName string, date string) (*DataAsset, string, error) {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return nil, "", err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, "", fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(asset.CoOwners) == 0 || asset.ShareOf(mspid) == 0 {
+		return nil, "", fmt.Errorf("only co-owners of asset %s can approve its sale", CreateAssetID(deviceName, date))
+	}
+	return asset, mspid, nil
+}
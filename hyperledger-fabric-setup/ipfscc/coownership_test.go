@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOwnerShares = `[{"org":"` + myOrg1Msp + `","sharePercent":60},{"org":"` + myOrg2Msp + `","sharePercent":40}]`
+
+func TestSetCoOwners(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+	assert.Error(t, assetTransferCC.SetCoOwners(transactionContext, testDeviceName, testDataDate, `[{"org":"`+myOrg2Msp+`","sharePercent":100}]`, 50), "current owner must keep a share")
+	assert.Error(t, assetTransferCC.SetCoOwners(transactionContext, testDeviceName, testDataDate, `[{"org":"`+myOrg1Msp+`","sharePercent":60}]`, 50), "shares must add up to 100")
+	require.NoError(t, assetTransferCC.SetCoOwners(transactionContext, testDeviceName, testDataDate, testOwnerShares, 60))
+
+	var key KeyCIDAsset
+	require.NoError(t, json.Unmarshal(ledger.privateData["_implicit_org_"+myOrg2Msp][CreateAssetID(testDeviceName, testDataDate)], &key))
+	assert.Equal(t, testEncryptionKey, key.SymmetricKey)
+
+	ledger.as(myOrg2Msp)
+	myAssets, err := assetTransferCC.GetMyOrgsDataAssets(transactionContext)
+	require.NoError(t, err)
+	assert.Len(t, myAssets, 1, "co-owners see the asset as their own")
+	otherAssets, err := assetTransferCC.GetOtherOrgsDataAssets(transactionContext)
+	require.NoError(t, err)
+	assert.Len(t, otherAssets, 0)
+}
+
+func TestCoOwnedSaleNeedsApproval(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+	require.NoError(t, assetTransferCC.SetCoOwners(transactionContext, testDeviceName, testDataDate, testOwnerShares, 60))
+
+	assert.Error(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg3Msp, "1001"), "60% is not more than the 60% threshold")
+
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.ApproveBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "1001"), "only co-owners approve")
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.ApproveBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "1001"))
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "1001", "", testResaleUsageTerms),
+		"placing the bid again keeps the approval")
+
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("coOwnedSale")
+	require.NoError(t, assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "1001"))
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg3Msp, asset.OwnerOrg)
+	assert.Empty(t, asset.CoOwners)
+
+	var record TransferRecord
+	ledger.getJSON(t, CreateTransferID(testDeviceName, testDataDate, "coOwnedSale"), &record)
+	assert.Equal(t, []Proceeds{{Org: myOrg1Msp, Amount: 601}, {Org: myOrg2Msp, Amount: 400}}, record.Proceeds)
+}
+
+func TestCoOwnedLicenceNeedsApproval(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+	require.NoError(t, assetTransferCC.SetCoOwners(transactionContext, testDeviceName, testDataDate, testOwnerShares, 60))
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "501", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	assert.ErrorContains(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.GrantLicence(transactionContext, myOrg3Msp, testDeviceName, testDataDate)
+	}), "co-owners", "60% is not more than the 60% threshold")
+
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.ApproveLicence(transactionContext, myOrg3Msp, testDeviceName, testDataDate), "only co-owners approve")
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.ApproveLicence(transactionContext, myOrg3Msp, testDeviceName, testDataDate))
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("coOwnedLicence")
+	require.NoError(t, assetTransferCC.GrantLicence(transactionContext, myOrg3Msp, testDeviceName, testDataDate))
+
+	var record TransferRecord
+	ledger.getJSON(t, CreateTransferID(testDeviceName, testDataDate, "coOwnedLicence"), &record)
+	assert.Equal(t, TransferKindLicence, record.Kind)
+	assert.Equal(t, []Proceeds{{Org: myOrg1Msp, Amount: 301}, {Org: myOrg2Msp, Amount: 200}}, record.Proceeds)
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg1Msp, asset.OwnerOrg, "a licence leaves the ownership as it is")
+}
+
+func TestCoOwnedDutchAuction(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+	require.NoError(t, assetTransferCC.SetCoOwners(transactionContext, testDeviceName, testDataDate, testOwnerShares, 60))
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.ApproveSale(transactionContext, testDeviceName, testDataDate, "500"))
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.StartDutchAuction(transactionContext, testDeviceName, testDataDate, "1000", "100", "100", 3600, testUsageTerms))
+	buy := func() error {
+		_, err := assetTransferCC.BuyAtCurrentPrice(transactionContext, testDeviceName, testDataDate, "1000")
+		return err
+	}
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, submitTx(t, ledger, buy), "approved the sale", "approvals from before the auction ended with the bidding")
+
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.ApproveSale(transactionContext, testDeviceName, testDataDate, "500"), "only co-owners approve")
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.ApproveSale(transactionContext, testDeviceName, testDataDate, "500"))
+	ledger.as(myOrg3Msp)
+	ledger.setTime(time.Date(2000, 2, 2, 18, 0, 0, 0, time.UTC))
+	assert.ErrorContains(t, submitTx(t, ledger, buy), "approved the sale", "400 is below the price the co-owner approved")
+
+	ledger.setTime(time.Date(2000, 2, 2, 16, 0, 0, 0, time.UTC))
+	ledger.setTxID("dutchSale")
+	require.NoError(t, submitTx(t, ledger, buy))
+
+	var record TransferRecord
+	ledger.getJSON(t, CreateTransferID(testDeviceName, testDataDate, "dutchSale"), &record)
+	assert.Equal(t, "600", record.Price)
+	assert.Equal(t, []Proceeds{{Org: myOrg1Msp, Amount: 360}, {Org: myOrg2Msp, Amount: 240}}, record.Proceeds)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Device records the org operating a device, which registered it with RegisterDevice. Only that org can record
+// the consent of the residents behind the device.
+type Device struct {
+	DeviceName   string `json:"deviceName"`
+	OperatorOrg  string `json:"operatorOrg"`
+	RegisteredAt string `json:"registeredAt"`
+}
+
+func CreateDeviceID(deviceName string) string {
+	return "device_" + deviceName
+}
+
+func (s *SmartContract) getDevice(ctx contractapi.TransactionContextInterface, deviceName string) (*Device, error) {
+	deviceBytes, err := ctx.GetStub().GetState(CreateDeviceID(deviceName))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting device: %v", err)
+	}
+	if deviceBytes == nil {
+		return nil, nil
+	}
+
+	var device Device
+	err = json.Unmarshal(deviceBytes, &device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device JSON: %v", err)
+	}
+	return &device, nil
+}
+
+// GetDevice returns the device registered under deviceName.
+func (s *SmartContract) GetDevice(ctx contractapi.TransactionContextInterface, deviceName string) (*Device, error) {
+	device, err := s.getDevice(ctx, deviceName)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("device %s is not registered", deviceName)
+	}
+	return device, nil
+}
+
+// isDeviceProducer reports whether mspid produced any asset of deviceName.
+func (s *SmartContract) isDeviceProducer(ctx contractapi.TransactionContextInterface, deviceName string, mspid string) (bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("data_"+deviceName+"_", "data_"+deviceName+"_~")
+	if err != nil {
+		return false, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		var asset DataAsset
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return false, err
+		}
+		if asset.AssetName == deviceName && asset.OriginalProducerOrg == mspid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RegisterDevice registers the calling org as the operator of deviceName, which lets it record the consent of
+// the residents behind the device. Only an org that uploaded data of the device can register it, and a device
+// is registered once.
+func (s *SmartContract) RegisterDevice(ctx contractapi.TransactionContextInterface, deviceName string) error {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	device, err := s.getDevice(ctx, deviceName)
+	if err != nil {
+		return err
+	}
+	if device != nil {
+		return fmt.Errorf("device %s is already operated by %s", deviceName, device.OperatorOrg)
+	}
+	producer, err := s.isDeviceProducer(ctx, deviceName, mspid)
+	if err != nil {
+		return err
+	}
+	if !producer {
+		return fmt.Errorf("org %s has not uploaded data of device %s", mspid, deviceName)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	deviceBytes, err := json.Marshal(Device{DeviceName: deviceName, OperatorOrg: mspid, RegisteredAt: now.UTC().Format(time.RFC3339)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal device to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateDeviceID(deviceName), deviceBytes)
+}
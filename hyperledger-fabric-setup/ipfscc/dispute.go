@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	DisputeStatusOpen      = "open"
+	DisputeStatusResponded = "responded"
+	DisputeStatusResolved  = "resolved"
+
+	RulingReverseOwnership = "reverseOwnership"
+	RulingRefund           = "refund"
+	RulingLockAsset        = "lockAsset"
+	RulingDismiss          = "dismiss"
+)
+
+// Dispute is opened by the buyer of an accepted transfer when the data it received is not what it paid for.
+// The eligible arbiters vote on a ruling, and the dispute is resolved once a majority of them agree. A lock
+// ruling is lifted the same way, by a majority of UnlockVotes.
+type Dispute struct {
+	DeviceName           string            `json:"deviceName"`
+	Date                 string            `json:"date"`
+	TransferTxID         string            `json:"transferTxID"`
+	TransferKind         string            `json:"transferKind"`
+	BuyerOrg             string            `json:"buyerOrg"`
+	SellerOrg            string            `json:"sellerOrg"`
+	Reason               string            `json:"reason"`
+	EvidenceHashes       []string          `json:"evidenceHashes"`
+	SellerResponse       string            `json:"sellerResponse"`
+	SellerEvidenceHashes []string          `json:"sellerEvidenceHashes"`
+	Votes                map[string]string `json:"votes"`
+	Ruling               string            `json:"ruling"`
+	Status               string            `json:"status"`
+	OpenedAt             string            `json:"openedAt"`
+	ResolvedAt           string            `json:"resolvedAt"`
+	UnlockVotes          []string          `json:"unlockVotes"`
+	UnlockedAt           string            `json:"unlockedAt"`
+}
+
+// DisputeEvent is the payload of every event emitted by the dispute workflow.
+type DisputeEvent struct {
+	DeviceName   string `json:"deviceName"`
+	Date         string `json:"date"`
+	TransferTxID string `json:"transferTxID"`
+	BuyerOrg     string `json:"buyerOrg"`
+	SellerOrg    string `json:"sellerOrg"`
+	Status       string `json:"status"`
+	Ruling       string `json:"ruling"`
+	ActingOrg    string `json:"actingOrg"`
+}
+
+func CreateDisputeID(deviceName string, date string, transferTxID string) string {
+	return "dispute_" + deviceName + "_" + date + "_" + transferTxID
+}
+
+// checkNotLocked fails if a dispute ruling locked the asset.
+func checkNotLocked(asset *DataAsset) error {
+	if asset.Locked {
+		return fmt.Errorf("asset %s is locked by a dispute ruling", CreateAssetID(asset.AssetName, asset.Date))
+	}
+	return nil
+}
+
+// parseEvidenceHashes decodes a JSON array of hex encoded hashes, which must be at least 32 bytes long.
+func parseEvidenceHashes(evidenceHashes string) ([]string, error) {
+	var hashes []string
+	err := json.Unmarshal([]byte(evidenceHashes), &hashes)
+	if err != nil {
+		return nil, fmt.Errorf("evidence hashes are not a valid JSON array: %v", err)
+	}
+	for _, hash := range hashes {
+		digest, err := hex.DecodeString(hash)
+		if err != nil || len(digest) < 32 {
+			return nil, fmt.Errorf("evidence hash %q is not a hex encoded hash of at least 32 bytes", hash)
+		}
+	}
+	return hashes, nil
+}
+
+// getEligibleArbiters returns the orgs that vote on dispute: the arbiter orgs of the market config that are not
+// a party to it or, when there are none, the member orgs that are not.
+func (s *SmartContract) getEligibleArbiters(ctx contractapi.TransactionContextInterface, dispute *Dispute) ([]string, error) {
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, orgs := range [][]string{config.ArbiterOrgs, config.MemberOrgs} {
+		var eligible []string
+		for _, org := range orgs {
+			if org != dispute.BuyerOrg && org != dispute.SellerOrg {
+				eligible = append(eligible, org)
+			}
+		}
+		if len(eligible) > 0 {
+			return eligible, nil
+		}
+	}
+	return nil, fmt.Errorf("no org that is not a party to the dispute can arbitrate it")
+}
+
+func (s *SmartContract) putDispute(ctx contractapi.TransactionContextInterface, dispute *Dispute, actingOrg string, eventName string) error {
+	disputeID := CreateDisputeID(dispute.DeviceName, dispute.Date, dispute.TransferTxID)
+	disputeBytes, err := json.Marshal(dispute)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dispute to JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(disputeID, disputeBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put dispute to the ledger: %v", err)
+	}
+
+	disputeEvent := DisputeEvent{
+		DeviceName:   dispute.DeviceName,
+		Date:         dispute.Date,
+		TransferTxID: dispute.TransferTxID,
+		BuyerOrg:     dispute.BuyerOrg,
+		SellerOrg:    dispute.SellerOrg,
+		Status:       dispute.Status,
+		Ruling:       dispute.Ruling,
+		ActingOrg:    actingOrg,
+	}
+	disputeEventJSON, err := json.Marshal(disputeEvent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dispute event to json: %v", err)
+	}
+	return ctx.GetStub().SetEvent(eventName+"_"+disputeID, disputeEventJSON)
+}
+
+// GetDispute returns the dispute opened against the transfer recorded in transaction transferTxID.
+func (s *SmartContract) GetDispute(ctx contractapi.TransactionContextInterface, deviceName string, date string, transferTxID string) (*Dispute, error) {
+	disputeBytes, err := ctx.GetStub().GetState(CreateDisputeID(deviceName, date, transferTxID))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting dispute: %v", err)
+	}
+	if disputeBytes == nil {
+		return nil, fmt.Errorf("no dispute found for transfer %s", transferTxID)
+	}
+
+	var dispute Dispute
+	err = json.Unmarshal(disputeBytes, &dispute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dispute JSON: %v", err)
+	}
+	return &dispute, nil
+}
+
+// OpenDispute lets the buyer of an accepted transfer contest it. evidenceHashes is a JSON array of hex encoded
+// hashes of the evidence, which is shared off chain.
+func (s *SmartContract) OpenDispute(ctx contractapi.TransactionContextInterface, deviceName string, date string, transferTxID string, reason string, evidenceHashes string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+
+	transfer, err := s.getTransferRecord(ctx, deviceName, date, transferTxID)
+	if err != nil {
+		return err
+	}
+	if transfer.ToOrg != mspid {
+		return fmt.Errorf("only the buyer of a transfer can dispute it")
+	}
+
+	existing, err := ctx.GetStub().GetState(CreateDisputeID(deviceName, date, transferTxID))
+	if err != nil {
+		return fmt.Errorf("error ocurred getting dispute: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("transfer %s is already disputed", transferTxID)
+	}
+
+	if reason == "" {
+		return fmt.Errorf("a dispute needs a reason")
+	}
+	hashes, err := parseEvidenceHashes(evidenceHashes)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	dispute := Dispute{
+		DeviceName:     deviceName,
+		Date:           date,
+		TransferTxID:   transferTxID,
+		TransferKind:   transfer.Kind,
+		BuyerOrg:       transfer.ToOrg,
+		SellerOrg:      transfer.FromOrg,
+		Reason:         reason,
+		EvidenceHashes: hashes,
+		Votes:          map[string]string{},
+		Status:         DisputeStatusOpen,
+		OpenedAt:       now.UTC().Format(time.RFC3339),
+	}
+	return s.putDispute(ctx, &dispute, mspid, "disputeOpened")
+}
+
+// RespondToDispute lets the seller answer a dispute with its own evidence hashes.
+func (s *SmartContract) RespondToDispute(ctx contractapi.TransactionContextInterface, deviceName string, date string, transferTxID string, response string, evidenceHashes string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	dispute, err := s.GetDispute(ctx, deviceName, date, transferTxID)
+	if err != nil {
+		return err
+	}
+	if dispute.SellerOrg != mspid {
+		return fmt.Errorf("only the seller can respond to a dispute")
+	}
+	if dispute.Status == DisputeStatusResolved {
+		return fmt.Errorf("dispute is already resolved")
+	}
+	hashes, err := parseEvidenceHashes(evidenceHashes)
+	if err != nil {
+		return err
+	}
+
+	dispute.SellerResponse = response
+	dispute.SellerEvidenceHashes = hashes
+	dispute.Status = DisputeStatusResponded
+	return s.putDispute(ctx, dispute, mspid, "disputeResponded")
+}
+
+// VoteOnDispute records the ruling an eligible arbiter votes for, see getEligibleArbiters. The ruling is applied
+// as soon as a majority of the eligible arbiters voted for it.
+func (s *SmartContract) VoteOnDispute(ctx contractapi.TransactionContextInterface, deviceName string, date string, transferTxID string, ruling string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	dispute, err := s.GetDispute(ctx, deviceName, date, transferTxID)
+	if err != nil {
+		return err
+	}
+	if dispute.Status == DisputeStatusResolved {
+		return fmt.Errorf("dispute is already resolved")
+	}
+
+	switch ruling {
+	case RulingReverseOwnership, RulingRefund, RulingLockAsset, RulingDismiss:
+	default:
+		return fmt.Errorf("unknown ruling %q", ruling)
+	}
+
+	arbiters, err := s.getEligibleArbiters(ctx, dispute)
+	if err != nil {
+		return err
+	}
+	if !contains(arbiters, mspid) {
+		return fmt.Errorf("%s is not an arbiter for this dispute", mspid)
+	}
+
+	dispute.Votes[mspid] = ruling
+	votesForRuling := 0
+	for _, vote := range dispute.Votes {
+		if vote == ruling {
+			votesForRuling++
+		}
+	}
+	if votesForRuling*2 <= len(arbiters) {
+		return s.putDispute(ctx, dispute, mspid, "disputeVoted")
+	}
+
+	err = s.applyRuling(ctx, dispute, ruling)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	dispute.Ruling = ruling
+	dispute.Status = DisputeStatusResolved
+	dispute.ResolvedAt = now.UTC().Format(time.RFC3339)
+	return s.putDispute(ctx, dispute, mspid, "disputeResolved")
+}
+
+// applyRuling carries out the ruling of a resolved dispute. There is no escrow on the channel, so a refund
+// ruling is only recorded and announced, and the refund itself is settled off chain.
+func (s *SmartContract) applyRuling(ctx contractapi.TransactionContextInterface, dispute *Dispute, ruling string) error {
+	switch ruling {
+	case RulingReverseOwnership:
+		if dispute.TransferKind == TransferKindLicence {
+			licence, err := s.GetLicence(ctx, dispute.BuyerOrg, dispute.DeviceName, dispute.Date)
+			if err != nil {
+				return err
+			}
+			licence.Status = LicenceStatusRevoked
+			licenceBytes, err := json.Marshal(licence)
+			if err != nil {
+				return fmt.Errorf("error ocurred marshalling JSON to Byte array: %v", err)
+			}
+			return ctx.GetStub().PutState(CreateLicenceID(dispute.DeviceName, dispute.Date, dispute.BuyerOrg), licenceBytes)
+		}
+
+		asset, err := s.getDataAsset(ctx, dispute.DeviceName, dispute.Date)
+		if err != nil {
+			return err
+		}
+		if asset.OwnerOrg != dispute.BuyerOrg || len(asset.CoOwners) > 0 {
+			return fmt.Errorf("asset %s is no longer held by the buyer alone, ownership can't be reversed", CreateAssetID(asset.AssetName, asset.Date))
+		}
+		transfer, err := s.getTransferRecord(ctx, dispute.DeviceName, dispute.Date, dispute.TransferTxID)
+		if err != nil {
+			return err
+		}
+		asset.OwnerOrg = dispute.SellerOrg
+		asset.OwnerTerms = transfer.SellerTerms
+		asset.CoOwners = transfer.SellerCoOwners
+		asset.ApprovalThresholdPercent = transfer.SellerApprovalThresholdPercent
+		asset.SaleEpoch++
+		return s.putDataAsset(ctx, asset)
+	case RulingLockAsset:
+		asset, err := s.getDataAsset(ctx, dispute.DeviceName, dispute.Date)
+		if err != nil {
+			return err
+		}
+		asset.Locked = true
+		return s.putDataAsset(ctx, asset)
+	}
+	return nil
+}
+
+// VoteToUnlockAsset lets an eligible arbiter of a dispute whose ruling locked the asset vote to lift the lock,
+// for instance once the parties settled. The asset is unlocked as soon as a majority of the eligible arbiters
+// voted for it.
+func (s *SmartContract) VoteToUnlockAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string, transferTxID string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	dispute, err := s.GetDispute(ctx, deviceName, date, transferTxID)
+	if err != nil {
+		return err
+	}
+	if dispute.Status != DisputeStatusResolved || dispute.Ruling != RulingLockAsset {
+		return fmt.Errorf("the dispute of transfer %s did not lock the asset", transferTxID)
+	}
+	if dispute.UnlockedAt != "" {
+		return fmt.Errorf("the asset was already unlocked at %s", dispute.UnlockedAt)
+	}
+
+	arbiters, err := s.getEligibleArbiters(ctx, dispute)
+	if err != nil {
+		return err
+	}
+	if !contains(arbiters, mspid) {
+		return fmt.Errorf("%s is not an arbiter for this dispute", mspid)
+	}
+	if contains(dispute.UnlockVotes, mspid) {
+		return fmt.Errorf("%s already voted to unlock the asset", mspid)
+	}
+	dispute.UnlockVotes = append(dispute.UnlockVotes, mspid)
+	if len(dispute.UnlockVotes)*2 <= len(arbiters) {
+		return s.putDispute(ctx, dispute, mspid, "disputeUnlockVoted")
+	}
+
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	asset.Locked = false
+	err = s.putDataAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	dispute.UnlockedAt = now.UTC().Format(time.RFC3339)
+	return s.putDispute(ctx, dispute, mspid, "disputeUnlocked")
+}
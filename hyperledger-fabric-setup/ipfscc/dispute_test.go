@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const myOrg4Msp = "Org4Testmsp"
+const myOrg5Msp = "Org5Testmsp"
+
+var testEvidenceHashes = `["` + strings.Repeat("ab", 32) + `"]`
+
+func prepDispute(t *testing.T) (*SmartContract, *mockLedger) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.setTxID("saleTx")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+
+	ledger.setConfig(t, func(config *MarketConfig) {
+		config.ArbiterOrgs = []string{myOrg1Msp, myOrg3Msp, myOrg4Msp, myOrg5Msp}
+	})
+
+	ledger.as(myOrg2Msp)
+	ledger.setTxID("disputeTx")
+	require.NoError(t, assetTransferCC.OpenDispute(ledger.ctx, testDeviceName, testDataDate, "saleTx", "the dataset is empty", testEvidenceHashes))
+	return &assetTransferCC, ledger
+}
+
+func TestOpenDispute(t *testing.T) {
+	assetTransferCC, ledger := prepDispute(t)
+	transactionContext := ledger.ctx
+
+	assert.Equal(t, "disputeOpened_"+CreateDisputeID(testDeviceName, testDataDate, "saleTx"), ledger.lastEvent)
+	assert.Error(t, assetTransferCC.OpenDispute(transactionContext, testDeviceName, testDataDate, "saleTx", "again", testEvidenceHashes), "a transfer is disputed once")
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.OpenDispute(transactionContext, testDeviceName, testDataDate, "saleTx", "not my trade", testEvidenceHashes), "only the buyer disputes")
+	_, err := parseEvidenceHashes(`["not a hash"]`)
+	assert.Error(t, err)
+
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.RespondToDispute(transactionContext, testDeviceName, testDataDate, "saleTx", "it's fine", testEvidenceHashes), "only the seller responds")
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.RespondToDispute(transactionContext, testDeviceName, testDataDate, "saleTx", "it's fine", testEvidenceHashes))
+
+	dispute, err := assetTransferCC.GetDispute(transactionContext, testDeviceName, testDataDate, "saleTx")
+	require.NoError(t, err)
+	assert.Equal(t, DisputeStatusResponded, dispute.Status)
+	assert.Equal(t, "disputeResponded_"+CreateDisputeID(testDeviceName, testDataDate, "saleTx"), ledger.lastEvent)
+}
+
+func TestDisputeRulingReversesOwnership(t *testing.T) {
+	assetTransferCC, ledger := prepDispute(t)
+	transactionContext := ledger.ctx
+
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingDismiss), "parties can't vote")
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingReverseOwnership))
+	ledger.as(myOrg4Msp)
+	require.NoError(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingLockAsset))
+	ledger.as(myOrg5Msp)
+	require.NoError(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingReverseOwnership))
+
+	dispute, err := assetTransferCC.GetDispute(transactionContext, testDeviceName, testDataDate, "saleTx")
+	require.NoError(t, err)
+	assert.Equal(t, DisputeStatusResolved, dispute.Status)
+	assert.Equal(t, RulingReverseOwnership, dispute.Ruling)
+	assert.Equal(t, "disputeResolved_"+CreateDisputeID(testDeviceName, testDataDate, "saleTx"), ledger.lastEvent)
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg1Msp, asset.OwnerOrg)
+}
+
+func TestDisputeRulingLocksAsset(t *testing.T) {
+	assetTransferCC, ledger := prepDispute(t)
+	transactionContext := ledger.ctx
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingLockAsset))
+	ledger.as(myOrg4Msp)
+	require.NoError(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingLockAsset))
+
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms), "locked assets can't be sold")
+
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.VoteToUnlockAsset(transactionContext, testDeviceName, testDataDate, "saleTx"), "parties can't vote")
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.VoteToUnlockAsset(transactionContext, testDeviceName, testDataDate, "saleTx"))
+	assert.Error(t, assetTransferCC.VoteToUnlockAsset(transactionContext, testDeviceName, testDataDate, "saleTx"), "one vote per arbiter")
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms), "one of three arbiters is not a majority")
+	ledger.as(myOrg5Msp)
+	require.NoError(t, assetTransferCC.VoteToUnlockAsset(transactionContext, testDeviceName, testDataDate, "saleTx"))
+	assert.Equal(t, "disputeUnlocked_"+CreateDisputeID(testDeviceName, testDataDate, "saleTx"), ledger.lastEvent)
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testResaleUsageTerms))
+	ledger.as(myOrg4Msp)
+	assert.Error(t, assetTransferCC.VoteToUnlockAsset(transactionContext, testDeviceName, testDataDate, "saleTx"), "the asset is unlocked already")
+}
+
+func TestDisputeWithoutArbitersGoesToMembers(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(config *MarketConfig) {
+		config.MemberOrgs = []string{myOrg1Msp, myOrg2Msp, myOrg3Msp, myOrg4Msp, myOrg5Msp}
+	})
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+	require.NoError(t, assetTransferCC.SetCoOwners(transactionContext, testDeviceName, testDataDate, testOwnerShares, 50))
+	ledger.setTxID("saleTx")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg3Msp, "100"))
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.OpenDispute(transactionContext, testDeviceName, testDataDate, "saleTx", "the dataset is empty", testEvidenceHashes))
+
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingDismiss), "parties can't vote")
+	ledger.as(myOrg4Msp)
+	require.NoError(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingReverseOwnership))
+	ledger.as(myOrg5Msp)
+	require.NoError(t, assetTransferCC.VoteOnDispute(transactionContext, testDeviceName, testDataDate, "saleTx", RulingReverseOwnership))
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg1Msp, asset.OwnerOrg)
+	assert.Equal(t, []OwnerShare{{Org: myOrg1Msp, SharePercent: 60}, {Org: myOrg2Msp, SharePercent: 40}}, asset.CoOwners, "the co-owners of the seller get their shares back")
+	assert.Equal(t, 50, asset.ApprovalThresholdPercent)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ErasureStatusOpen     = "open"
+	ErasureStatusComplete = "complete"
+)
+
+// ErasureRequest asks every org holding the keys of a device's assets between StartDate and EndDate to
+// delete them. HolderOrgs are the orgs the keys were ever delivered to with TransferEncKey, and the request is
+// complete once all of them have confirmed. NotifiedOrgs also include the current owners and licensees.
+// Until the request is complete the assets can't be sold or licensed, and their keys can't be delivered.
+type ErasureRequest struct {
+	RequestID     string            `json:"requestID"`
+	DeviceName    string            `json:"deviceName"`
+	StartDate     string            `json:"startDate"`
+	EndDate       string            `json:"endDate"`
+	RequesterOrg  string            `json:"requesterOrg"`
+	Reason        string            `json:"reason"`
+	AssetIDs      []string          `json:"assetIDs"`
+	HolderOrgs    []string          `json:"holderOrgs"`
+	NotifiedOrgs  []string          `json:"notifiedOrgs"`
+	Confirmations map[string]string `json:"confirmations"`
+	Status        string            `json:"status"`
+	FiledAt       string            `json:"filedAt"`
+	CompletedAt   string            `json:"completedAt"`
+}
+
+func CreateErasureRequestID(txID string) string {
+	return "erasure_" + txID
+}
+
+// IsComplete reports whether every holder of the keys has confirmed that it deleted its copy.
+func (r *ErasureRequest) IsComplete() bool {
+	for _, org := range r.HolderOrgs {
+		if _, ok := r.Confirmations[org]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func CreateOpenErasureID(assetID string) string {
+	return "openErasure_" + assetID
+}
+
+// checkNoOpenErasure checks that the asset of deviceName on date is not named in an open erasure request.
+func (s *SmartContract) checkNoOpenErasure(ctx contractapi.TransactionContextInterface, deviceName string, date string) error {
+	assetID := CreateAssetID(deviceName, date)
+	requestID, err := ctx.GetStub().GetState(CreateOpenErasureID(assetID))
+	if err != nil {
+		return fmt.Errorf("error ocurred getting open erasure request: %v", err)
+	}
+	if requestID != nil {
+		return fmt.Errorf("asset %s has the open erasure request %s", assetID, requestID)
+	}
+	return nil
+}
+
+// putErasureRequest stores request and marks its assets while it is open, so they can be checked with a point
+// read, and unmarks them once it is complete.
+func (s *SmartContract) putErasureRequest(ctx contractapi.TransactionContextInterface, request *ErasureRequest, eventName string) error {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal erasure request to JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(CreateErasureRequestID(request.RequestID), requestBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put erasure request: %v", err)
+	}
+	for _, assetID := range request.AssetIDs {
+		if request.Status == ErasureStatusOpen {
+			err = ctx.GetStub().PutState(CreateOpenErasureID(assetID), []byte(request.RequestID))
+		} else {
+			err = ctx.GetStub().DelState(CreateOpenErasureID(assetID))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to put open erasure request: %v", err)
+		}
+	}
+	return ctx.GetStub().SetEvent(eventName+"_"+request.RequestID, requestBytes)
+}
+
+// GetErasureRequest returns the erasure request filed in transaction requestID.
+func (s *SmartContract) GetErasureRequest(ctx contractapi.TransactionContextInterface, requestID string) (*ErasureRequest, error) {
+	requestBytes, err := ctx.GetStub().GetState(CreateErasureRequestID(requestID))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting erasure request: %v", err)
+	}
+	if requestBytes == nil {
+		return nil, fmt.Errorf("erasure request %s does not exist", requestID)
+	}
+
+	var request ErasureRequest
+	err = json.Unmarshal(requestBytes, &request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal erasure request JSON: %v", err)
+	}
+	return &request, nil
+}
+
+// getGrantedLicensees returns the orgs holding a granted licence for the asset of deviceName on date.
+func (s *SmartContract) getGrantedLicensees(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(CreateLicenceID(deviceName, date, ""), CreateLicenceID(deviceName, date, "~"))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var licensees []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var licence DataLicence
+		err = json.Unmarshal(queryResponse.Value, &licence)
+		if err != nil {
+			return nil, err
+		}
+		if licence.Status == LicenceStatusGranted && licence.DeviceName == deviceName && licence.Date == date {
+			licensees = append(licensees, licence.LicenseeOrg)
+		}
+	}
+	return licensees, nil
+}
+
+// FileErasureRequest lets the operator of a device, the original producer of its assets, ask every org that
+// received their keys to delete them. The current owners, licensees and key holders are notified by an event.
+// An asset can be named in one open erasure request at a time.
+func (s *SmartContract) FileErasureRequest(ctx contractapi.TransactionContextInterface, deviceName string, startDate string, endDate string, reason string) (string, error) {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return "", err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	start, err := config.ParseDate(startDate)
+	if err != nil {
+		return "", err
+	}
+	end, err := config.ParseDate(endDate)
+	if err != nil {
+		return "", err
+	}
+	if end.Before(start) {
+		return "", fmt.Errorf("end date %s is before start date %s", endDate, startDate)
+	}
+
+	// Daily and windowed assets whose data overlaps the days from startDate to endDate, inclusive.
+	assets, err := s.getDeviceAssetsOverlapping(ctx, config, deviceName, start, end.AddDate(0, 0, 1))
+	if err != nil {
+		return "", err
+	}
+	if len(assets) == 0 {
+		return "", fmt.Errorf("device %s has no assets between %s and %s", deviceName, startDate, endDate)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	request := &ErasureRequest{
+		RequestID:     ctx.GetStub().GetTxID(),
+		DeviceName:    deviceName,
+		StartDate:     startDate,
+		EndDate:       endDate,
+		RequesterOrg:  mspid,
+		Reason:        reason,
+		Confirmations: map[string]string{},
+		Status:        ErasureStatusOpen,
+		FiledAt:       now.UTC().Format(time.RFC3339),
+	}
+	notify := func(org string) {
+		if org != "" && org != mspid && !contains(request.NotifiedOrgs, org) {
+			request.NotifiedOrgs = append(request.NotifiedOrgs, org)
+		}
+	}
+	for _, asset := range assets {
+		if asset.OriginalProducerOrg != mspid {
+			return "", fmt.Errorf("only the operator of device %s can request erasure of its data", deviceName)
+		}
+		err = s.checkNoOpenErasure(ctx, asset.AssetName, asset.Date)
+		if err != nil {
+			return "", err
+		}
+		request.AssetIDs = append(request.AssetIDs, CreateAssetID(asset.AssetName, asset.Date))
+
+		recipients, err := s.getKeyRecipients(ctx, asset.AssetName, asset.Date)
+		if err != nil {
+			return "", err
+		}
+		for _, recipient := range recipients {
+			if recipient != mspid && !contains(request.HolderOrgs, recipient) {
+				request.HolderOrgs = append(request.HolderOrgs, recipient)
+			}
+			notify(recipient)
+		}
+		notify(asset.OwnerOrg)
+		for _, coOwner := range asset.CoOwners {
+			notify(coOwner.Org)
+		}
+		licensees, err := s.getGrantedLicensees(ctx, asset.AssetName, asset.Date)
+		if err != nil {
+			return "", err
+		}
+		for _, licensee := range licensees {
+			notify(licensee)
+		}
+	}
+	if request.IsComplete() {
+		request.Status = ErasureStatusComplete
+		request.CompletedAt = request.FiledAt
+	}
+
+	err = s.putErasureRequest(ctx, request, "erasureRequested")
+	if err != nil {
+		return "", err
+	}
+	return request.RequestID, nil
+}
+
+// ConfirmErasure records that the calling org deleted its copies of the keys named in erasure request
+// requestID. The request is complete once every holder of the keys has confirmed.
+func (s *SmartContract) ConfirmErasure(ctx contractapi.TransactionContextInterface, requestID string) error {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	request, err := s.GetErasureRequest(ctx, requestID)
+	if err != nil {
+		return err
+	}
+	if !contains(request.NotifiedOrgs, mspid) {
+		return fmt.Errorf("org %s was not asked to erase data for request %s", mspid, requestID)
+	}
+	if _, ok := request.Confirmations[mspid]; ok {
+		return fmt.Errorf("org %s already confirmed erasure request %s", mspid, requestID)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if request.Confirmations == nil {
+		request.Confirmations = map[string]string{}
+	}
+	request.Confirmations[mspid] = now.UTC().Format(time.RFC3339)
+
+	eventName := "erasureConfirmed"
+	if request.Status == ErasureStatusOpen && request.IsComplete() {
+		request.Status = ErasureStatusComplete
+		request.CompletedAt = now.UTC().Format(time.RFC3339)
+		eventName = "erasureCompleted"
+	}
+	return s.putErasureRequest(ctx, request, eventName)
+}
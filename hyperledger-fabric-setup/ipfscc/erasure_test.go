@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErasureRequest(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000", testMetadata))
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "10-02-2000", testMetadata))
+
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	require.NoError(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, "03-02-2000", "50", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.GrantLicence(transactionContext, myOrg3Msp, testDeviceName, "03-02-2000"))
+	// Org2 sells the asset on and buys it back, so the key is owed to it again.
+	ledger.setTxID("resaleTx")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg2Msp, myOrg3Msp, "100"))
+	require.NoError(t, assetTransferCC.TransferEncKey(transactionContext, myOrg3Msp, testDeviceName, testDataDate))
+	ledger.setTxID("buyBackTx")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg3Msp, myOrg2Msp, "100"))
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, "03-02-2000", "50", testUsageTerms))
+
+	ledger.as(myOrg2Msp)
+	_, err := assetTransferCC.FileErasureRequest(transactionContext, testDeviceName, "01-02-2000", "05-02-2000", "consent withdrawn")
+	assert.Error(t, err, "only the device operator files erasure requests")
+	ledger.as(myOrg1Msp)
+	_, err = assetTransferCC.FileErasureRequest(transactionContext, testDeviceName, "05-02-2000", "01-02-2000", "consent withdrawn")
+	assert.Error(t, err)
+	_, err = assetTransferCC.FileErasureRequest(transactionContext, testDeviceName, "01-03-2000", "05-03-2000", "consent withdrawn")
+	assert.Error(t, err, "there are no assets in the range")
+
+	ledger.setTxID("erasureTx")
+	requestID, err := assetTransferCC.FileErasureRequest(transactionContext, testDeviceName, "01-02-2000", "05-02-2000", "consent withdrawn")
+	require.NoError(t, err)
+	assert.Equal(t, "erasureRequested_erasureTx", ledger.lastEvent)
+
+	request, err := assetTransferCC.GetErasureRequest(transactionContext, requestID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{CreateAssetID(testDeviceName, testDataDate), CreateAssetID(testDeviceName, "03-02-2000")}, request.AssetIDs)
+	assert.ElementsMatch(t, []string{myOrg2Msp, myOrg3Msp}, request.HolderOrgs, "the key holders include Org2, which is owed the key again")
+	assert.ElementsMatch(t, []string{myOrg2Msp, myOrg3Msp}, request.NotifiedOrgs)
+	assert.Equal(t, ErasureStatusOpen, request.Status)
+
+	_, err = assetTransferCC.FileErasureRequest(transactionContext, testDeviceName, "02-02-2000", "02-02-2000", "consent withdrawn")
+	assert.ErrorContains(t, err, "erasure", "an asset is in one open erasure request at a time")
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate), "erasure")
+	assert.ErrorContains(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg2Msp, myOrg1Msp, "100"), "erasure")
+	ledger.as(myOrg1Msp)
+	assert.ErrorContains(t, assetTransferCC.GrantLicence(transactionContext, myOrg2Msp, testDeviceName, "03-02-2000"), "erasure")
+
+	ledger.as(myOrg4Msp)
+	assert.Error(t, assetTransferCC.ConfirmErasure(transactionContext, requestID), "only notified orgs confirm")
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.ConfirmErasure(transactionContext, requestID))
+	assert.Error(t, assetTransferCC.ConfirmErasure(transactionContext, requestID), "an org confirms once")
+	assert.Equal(t, "erasureConfirmed_erasureTx", ledger.lastEvent)
+	request, err = assetTransferCC.GetErasureRequest(transactionContext, requestID)
+	require.NoError(t, err)
+	assert.Equal(t, ErasureStatusOpen, request.Status, "Org2 still has to confirm")
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.ConfirmErasure(transactionContext, requestID))
+	assert.Equal(t, "erasureCompleted_erasureTx", ledger.lastEvent)
+	var completed ErasureRequest
+	require.NoError(t, json.Unmarshal(ledger.events[ledger.lastEvent], &completed))
+	assert.Equal(t, ErasureStatusComplete, completed.Status)
+	assert.NotEmpty(t, completed.CompletedAt)
+	assert.NoError(t, assetTransferCC.checkNoOpenErasure(transactionContext, testDeviceName, testDataDate),
+		"the assets are released once the request is complete")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// externalChaincodeName is the name this chaincode is deployed under on every channel.
+const externalChaincodeName = "ipfscc"
+
+// ExternalAssetReference is a reference to an asset on another channel, verified against that channel's ledger
+// when it was linked. OwnerOrg and IPFS_CID are the values read from the other channel at LinkedAt.
+type ExternalAssetReference struct {
+	Channel     string `json:"channel"`
+	DeviceName  string `json:"deviceName"`
+	Date        string `json:"date"`
+	OwnerOrg    string `json:"ownerOrg"`
+	IPFS_CID    string `json:"IPFS_CID"`
+	CIDv1       string `json:"cidV1"`
+	LinkedByOrg string `json:"linkedByOrg"`
+	LinkedAt    string `json:"linkedAt"`
+	LinkTxID    string `json:"linkTxID"`
+}
+
+func CreateExternalAssetReferenceID(channel string, deviceName string, date string) string {
+	return "externalAsset_" + channel + "_" + deviceName + "_" + date
+}
+
+// readExternalAsset queries this chaincode on channel for the asset of deviceName on date. Peers only allow
+// reads across channels, so nothing is written on channel.
+func (s *SmartContract) readExternalAsset(ctx contractapi.TransactionContextInterface, channel string, deviceName string, date string) (*DataAsset, error) {
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(config.ExternalChannels, channel) {
+		return nil, fmt.Errorf("channel %s is not one of the external channels %v", channel, config.ExternalChannels)
+	}
+	if channel == ctx.GetStub().GetChannelID() {
+		return nil, fmt.Errorf("asset %s is on this channel", CreateAssetID(deviceName, date))
+	}
+
+	args := [][]byte{[]byte("GetAssetByID"), []byte(deviceName + "_" + date)}
+	response := ctx.GetStub().InvokeChaincode(externalChaincodeName, args, channel)
+	if response.Status != shim.OK {
+		return nil, fmt.Errorf("error ocurred querying channel %s: %s", channel, response.Message)
+	}
+	var asset DataAsset
+	err = json.Unmarshal(response.Payload, &asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	if asset.AssetName != deviceName || asset.Date != date {
+		return nil, fmt.Errorf("the asset %s does not exist on channel %s", CreateAssetID(deviceName, date), channel)
+	}
+	return &asset, nil
+}
+
+// GetExternalAsset reads the asset of deviceName on date from channel, one of the external channels in the
+// market config, without recording anything.
+func (s *SmartContract) GetExternalAsset(ctx contractapi.TransactionContextInterface, channel string, deviceName string, date string) (*DataAsset, error) {
+	return s.readExternalAsset(ctx, channel, deviceName, date)
+}
+
+// LinkExternalAsset records a reference to the asset of deviceName on date on channel, after checking on that
+// channel's ledger that the asset exists and is stored under cid, the CID the caller cites. Linking an asset
+// again refreshes its owner and link time.
+func (s *SmartContract) LinkExternalAsset(ctx contractapi.TransactionContextInterface, channel string, deviceName string, date string, cid string) error {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	cited, err := ParseCID(cid)
+	if err != nil {
+		return err
+	}
+	asset, err := s.readExternalAsset(ctx, channel, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if assetCIDv1(asset) != cited.CIDv1 {
+		return fmt.Errorf("asset %s on channel %s is stored under CID %s, not %s", CreateAssetID(deviceName, date), channel, asset.IPFS_CID, cid)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	referenceBytes, err := json.Marshal(ExternalAssetReference{
+		Channel:     channel,
+		DeviceName:  deviceName,
+		Date:        date,
+		OwnerOrg:    asset.OwnerOrg,
+		IPFS_CID:    asset.IPFS_CID,
+		CIDv1:       cited.CIDv1,
+		LinkedByOrg: mspid,
+		LinkedAt:    now.UTC().Format(time.RFC3339),
+		LinkTxID:    txID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal external asset reference to JSON: %v", err)
+	}
+	referenceID := CreateExternalAssetReferenceID(channel, deviceName, date)
+	err = ctx.GetStub().PutState(referenceID, referenceBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put external asset reference: %v", err)
+	}
+	return ctx.GetStub().SetEvent("externalAssetLinked_"+referenceID, referenceBytes)
+}
+
+// GetExternalAssetReference returns the recorded reference to the asset of deviceName on date on channel.
+func (s *SmartContract) GetExternalAssetReference(ctx contractapi.TransactionContextInterface, channel string, deviceName string, date string) (*ExternalAssetReference, error) {
+	referenceBytes, err := ctx.GetStub().GetState(CreateExternalAssetReferenceID(channel, deviceName, date))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting external asset reference: %v", err)
+	}
+	if referenceBytes == nil {
+		return nil, fmt.Errorf("asset %s on channel %s was never linked", CreateAssetID(deviceName, date), channel)
+	}
+
+	var reference ExternalAssetReference
+	err = json.Unmarshal(referenceBytes, &reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal external asset reference JSON: %v", err)
+	}
+	return &reference, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testExternalChannel = "communitydatachannel"
+
+// prepExternalChannel answers cross-channel queries of ledger with the chaincode running on a second mocked ledger.
+func prepExternalChannel(t *testing.T, ledger *mockLedger) *mockLedger {
+	_, remoteLedger := prepLedgerMocks(myOrg2Msp)
+	ledger.stub.GetChannelIDReturns("researchchannel")
+	ledger.stub.InvokeChaincodeCalls(func(name string, args [][]byte, channel string) peer.Response {
+		if name != externalChaincodeName || channel != testExternalChannel {
+			return shim.Error("chaincode " + name + " not found on channel " + channel)
+		}
+		asset, err := (&SmartContract{}).GetAssetByID(remoteLedger.ctx, string(args[1]))
+		require.NoError(t, err)
+		payload, err := json.Marshal(asset)
+		require.NoError(t, err)
+		return shim.Success(payload)
+	})
+	return remoteLedger
+}
+
+func TestLinkExternalAsset(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	remoteLedger := prepExternalChannel(t, ledger)
+	uploadTestAsset(t, remoteLedger.ctx, remoteLedger, myOrg2Msp)
+
+	assert.ErrorContains(t, assetTransferCC.LinkExternalAsset(transactionContext, testExternalChannel, testDeviceName, testDataDate, testCID), "not one of the external channels")
+	ledger.setConfig(t, func(c *MarketConfig) {
+		c.ExternalChannels = []string{testExternalChannel, "otherchannel", "researchchannel"}
+	})
+	assert.ErrorContains(t, assetTransferCC.LinkExternalAsset(transactionContext, "otherchannel", testDeviceName, testDataDate, testCID), "not found")
+	assert.ErrorContains(t, assetTransferCC.LinkExternalAsset(transactionContext, "researchchannel", testDeviceName, testDataDate, testCID), "on this channel")
+	assert.ErrorContains(t, assetTransferCC.LinkExternalAsset(transactionContext, testExternalChannel, testDeviceName, "03-02-2000", testCID), "does not exist")
+	assert.ErrorContains(t, assetTransferCC.LinkExternalAsset(transactionContext, testExternalChannel, testDeviceName, testDataDate, "bafkreie5nqv6kd3qnfjupgvz34woh3oksc3iau6abmyajn7qvtf6d2ho34"), "is stored under CID")
+
+	asset, err := assetTransferCC.GetExternalAsset(transactionContext, testExternalChannel, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, myOrg2Msp, asset.OwnerOrg)
+
+	ledger.setTxID("linkTx")
+	require.NoError(t, assetTransferCC.LinkExternalAsset(transactionContext, testExternalChannel, testDeviceName, testDataDate, testCIDv1), "the CIDv1 form of the CID verifies")
+	assert.Equal(t, "externalAssetLinked_externalAsset_"+testExternalChannel+"_"+testDeviceName+"_"+testDataDate, ledger.lastEvent)
+
+	reference, err := assetTransferCC.GetExternalAssetReference(transactionContext, testExternalChannel, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, myOrg2Msp, reference.OwnerOrg)
+	assert.Equal(t, testCID, reference.IPFS_CID)
+	assert.Equal(t, testCIDv1, reference.CIDv1)
+	assert.Equal(t, myOrg1Msp, reference.LinkedByOrg)
+	assert.Equal(t, "linkTx", reference.LinkTxID)
+
+	_, err = assetTransferCC.GetExternalAssetReference(transactionContext, testExternalChannel, testDeviceName, "03-02-2000")
+	assert.Error(t, err)
+}
@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -24,10 +25,58 @@ type SmartContract struct {
 }
 
 type DataAsset struct {
-	AssetName string `json:"assetName"`
-	Date      string `json:"date"`
-	IPFS_CID  string `json:"IPFS_CID"`
-	OwnerOrg  string `json:"ownerOrg"`
+	AssetName           string `json:"assetName"`
+	Date                string `json:"date"`
+	IPFS_CID            string `json:"IPFS_CID"`
+	OwnerOrg            string `json:"ownerOrg"`
+	OriginalProducerOrg string `json:"originalProducerOrg"`
+	ResalePolicy        string `json:"resalePolicy"`
+	RoyaltyPercent      int    `json:"royaltyPercent"`
+	// CoOwners is empty for assets that are owned by OwnerOrg alone. When it is set, OwnerOrg is one of the
+	// co-owners and manages bids on their behalf.
+	CoOwners                 []OwnerShare `json:"coOwners"`
+	ApprovalThresholdPercent int          `json:"approvalThresholdPercent"`
+	// Locked is set by a dispute ruling and stops the asset from being sold or licensed.
+	Locked bool `json:"locked"`
+	// RetentionDays limits how many days after Date the data may be kept decryptable, 0 keeps it indefinitely.
+	// Once it passes the key is shredded and Shredded is set. ShredPendingOrgs are the key holders that have
+	// not yet removed the key from their implicit collection, see ShredExpiredKey.
+	RetentionDays    int      `json:"retentionDays"`
+	Shredded         bool     `json:"shredded"`
+	ShreddedAt       string   `json:"shreddedAt"`
+	ShredPendingOrgs []string `json:"shredPendingOrgs"`
+	// QualityScore is the average score of the quality attestations posted by validator orgs.
+	QualityScore            int `json:"qualityScore"`
+	QualityAttestationCount int `json:"qualityAttestationCount"`
+	// QualityAttestations are filled in by the asset queries, they are stored under their own keys.
+	QualityAttestations []*QualityAttestation `json:"qualityAttestations,omitempty"`
+	// Metadata is nil for assets uploaded before metadata was required.
+	Metadata *AssetMetadata `json:"metadata"`
+	// ContentID is the parsed IPFS_CID, nil for assets uploaded before CIDs were validated.
+	ContentID *ContentID `json:"contentID"`
+	// WindowStart, WindowEnd and Granularity are set for windowed assets, whose Date holds their window, see
+	// UploadWindowedDataAsAsset. They are empty for assets covering a whole date.
+	WindowStart string `json:"windowStart"`
+	WindowEnd   string `json:"windowEnd"`
+	Granularity string `json:"granularity"`
+	// Version starts at 1 and grows each time SupersedeAsset replaces the data with a correction, recording
+	// when and why in CorrectedAt and CorrectionReason. PreviousVersionID is the key of the replaced AssetVersion.
+	// Assets uploaded before corrections were possible read 0 and count as version 1.
+	Version           int    `json:"version"`
+	CorrectedAt       string `json:"correctedAt"`
+	CorrectionReason  string `json:"correctionReason"`
+	PreviousVersionID string `json:"previousVersionID"`
+	// DerivedFrom lists the IDs of the source assets of a derived asset, see UploadDerivedDataAsAsset, and
+	// InheritedRestrictions the resale restrictions it inherits from them.
+	DerivedFrom           []string                `json:"derivedFrom"`
+	InheritedRestrictions []*InheritedRestriction `json:"inheritedRestrictions"`
+	// SaleEpoch grows each time the bidding on the asset ends, when it is sold, auctioned or shredded. Bids
+	// record the epoch they were placed in and go stale when it moves on, see DataBid.IsStale, so ending the
+	// bidding doesn't have to scan and rewrite the bids.
+	SaleEpoch int `json:"saleEpoch"`
+	// OwnerTerms are the usage terms OwnerOrg bought the asset under, nil while the producer holds it and for
+	// assets bought before they were kept. They decide whether OwnerOrg may resell or license the asset.
+	OwnerTerms *UsageTerms `json:"ownerTerms"`
 }
 
 type KeyCIDAsset struct {
@@ -45,13 +94,33 @@ type BidApproval struct {
 }
 
 type DataBid struct {
-	AdditionalCommitments string `json:"additionalCommitments"`
-	BiddingOrg            string `json:"biddingOrg"`
-	CurrentOwnerOrg       string `json:"currentOwnerOrg"`
-	DeviceName            string `json:"deviceName"`
-	Date                  string `json:"date"`
-	Price                 string `json:"price"`
-	Active                bool   `json:"active"`
+	AdditionalCommitments string      `json:"additionalCommitments"`
+	BiddingOrg            string      `json:"biddingOrg"`
+	CurrentOwnerOrg       string      `json:"currentOwnerOrg"`
+	DeviceName            string      `json:"deviceName"`
+	Date                  string      `json:"date"`
+	Price                 string      `json:"price"`
+	Terms                 *UsageTerms `json:"terms"`
+	Active                bool        `json:"active"`
+	AuctionBid            bool        `json:"auctionBid"`
+	AcceptedTxID          string      `json:"acceptedTxID"`
+	ExpiresAt             string      `json:"expiresAt"`
+	SaleEpoch             int         `json:"saleEpoch"`
+}
+
+// IsExpired reports whether the bid outlived the bid lifetime configured when it was placed.
+func (b *DataBid) IsExpired(now time.Time) bool {
+	if b.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, b.ExpiresAt)
+	return err == nil && !now.Before(expiresAt)
+}
+
+// IsStale reports whether the bidding the bid was placed in has ended, because asset changed hands or its
+// sale epoch moved on since.
+func (b *DataBid) IsStale(asset *DataAsset) bool {
+	return b.CurrentOwnerOrg != asset.OwnerOrg || b.SaleEpoch != asset.SaleEpoch
 }
 
 func CreateAssetID(deviceName string, date string) (assetID string) {
@@ -59,6 +128,18 @@ func CreateAssetID(deviceName string, date string) (assetID string) {
 	return result
 }
 
+// getTxTime returns the transaction timestamp chosen by the client, which is the same on every endorsing peer.
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error ocurred getting transaction timestamp: %v", err)
+	}
+	if txTimestamp == nil {
+		return time.Time{}, fmt.Errorf("transaction timestamp is not set")
+	}
+	return txTimestamp.AsTime(), nil
+}
+
 func (s *SmartContract) GetAssetOwner(ctx contractapi.TransactionContextInterface, deviceName string, date string) (string, error) {
 	assetID := CreateAssetID(deviceName, date)
 
@@ -74,6 +155,35 @@ func (s *SmartContract) GetAssetOwner(ctx contractapi.TransactionContextInterfac
 	return assetJSON.OwnerOrg, nil
 }
 
+// getDataAsset reads the asset of deviceName on date, and fails if it does not exist.
+func (s *SmartContract) getDataAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string) (*DataAsset, error) {
+	assetID := CreateAssetID(deviceName, date)
+	assetBytes, err := ctx.GetStub().GetState(assetID)
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting asset: %v", err)
+	}
+	if assetBytes == nil {
+		return nil, fmt.Errorf("the asset %s does not exist", assetID)
+	}
+
+	var asset DataAsset
+	err = json.Unmarshal(assetBytes, &asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &asset, nil
+}
+
+func (s *SmartContract) putDataAsset(ctx contractapi.TransactionContextInterface, asset *DataAsset) error {
+	stored := *asset
+	stored.QualityAttestations = nil
+	assetBytes, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal asset to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateAssetID(asset.AssetName, asset.Date), assetBytes)
+}
+
 // GetAssetByID, assetID is: <assetName>_<date>,
 func (s *SmartContract) GetAssetByID(ctx contractapi.TransactionContextInterface, assetId string) (*DataAsset, error) {
 	assetId = "data_" + assetId
@@ -84,6 +194,12 @@ func (s *SmartContract) GetAssetByID(ctx contractapi.TransactionContextInterface
 	if err != nil {
 		return nil, fmt.Errorf("error ocurred getting asset JSON: %v", err)
 	}
+	if assetJSON.QualityAttestationCount > 0 {
+		assetJSON.QualityAttestations, err = s.GetQualityAttestations(ctx, assetJSON.AssetName, assetJSON.Date)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &assetJSON, nil
 }
 
@@ -112,6 +228,10 @@ func (s *SmartContract) GetAllDataAssets(ctx contractapi.TransactionContextInter
 		assets = append(assets, &asset)
 	}
 
+	err = s.addQualityAttestations(ctx, assets)
+	if err != nil {
+		return nil, err
+	}
 	return assets, nil
 }
 
@@ -143,11 +263,15 @@ func (s *SmartContract) GetMyOrgsDataAssets(ctx contractapi.TransactionContextIn
 			return nil, err
 		}
 
-		if asset.OwnerOrg == mspid {
+		if asset.OwnerOrg == mspid || asset.ShareOf(mspid) > 0 {
 			assets = append(assets, &asset)
 		}
 	}
 
+	err = s.addQualityAttestations(ctx, assets)
+	if err != nil {
+		return nil, err
+	}
 	return assets, nil
 }
 
@@ -179,49 +303,120 @@ func (s *SmartContract) GetOtherOrgsDataAssets(ctx contractapi.TransactionContex
 			return nil, err
 		}
 
-		if asset.OwnerOrg != mspid {
+		if asset.OwnerOrg != mspid && asset.ShareOf(mspid) == 0 {
 			assets = append(assets, &asset)
 		}
 	}
 
+	err = s.addQualityAttestations(ctx, assets)
+	if err != nil {
+		return nil, err
+	}
 	return assets, nil
 }
 
-func (s *SmartContract) UploadDataAsAsset(ctx contractapi.TransactionContextInterface, deviceName string, cid string, date string) error {
+// UploadDataAsAsset records a new asset for the data of deviceName on date, stored on IPFS under cid.
+// metadata is a JSON encoded AssetMetadata describing the data.
+func (s *SmartContract) UploadDataAsAsset(ctx contractapi.TransactionContextInterface, deviceName string, cid string, date string, metadata string) error {
+	asset, err := s.newDailyDataAsset(ctx, deviceName, cid, date, metadata)
+	if err != nil {
+		return err
+	}
+	return s.putDataAsset(ctx, asset)
+}
+
+// newDailyDataAsset validates an upload covering the whole of date and returns the asset to record for it.
+func (s *SmartContract) newDailyDataAsset(ctx contractapi.TransactionContextInterface, deviceName string, cid string, date string, metadata string) (*DataAsset, error) {
+	asset, err := s.newDataAsset(ctx, deviceName, cid, date, metadata)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dataDate, err := config.ParseDate(date)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkNoOverlappingAsset(ctx, config, deviceName, dataDate, dataDate.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// newDataAsset validates an upload by the calling org and returns the asset to record for it, keyed by date.
+func (s *SmartContract) newDataAsset(ctx contractapi.TransactionContextInterface, deviceName string, cid string, date string, metadata string) (*DataAsset, error) {
 	id := CreateAssetID(deviceName, date)
+	err := s.checkOperational(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 	exists, err := s.AssetExists(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if exists {
-		return fmt.Errorf("the asset %s already exists", id)
+		return nil, fmt.Errorf("the asset %s already exists", id)
 	}
-
-	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	assetMetadata, err := ParseAssetMetadata(metadata)
 	if err != nil {
-		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+		return nil, err
 	}
-
-	asset := DataAsset{
-		AssetName: deviceName,
-		Date:      date,
-		IPFS_CID:  cid,
-		OwnerOrg:  mspid,
+	contentID, err := ParseCID(cid)
+	if err != nil {
+		return nil, err
 	}
-	assetBytes, err := json.Marshal(asset)
+
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error ocurred getting MSPID: %v", err)
 	}
 
-	return ctx.GetStub().PutState(id, assetBytes)
+	return &DataAsset{
+		AssetName:           deviceName,
+		Date:                date,
+		IPFS_CID:            cid,
+		OwnerOrg:            mspid,
+		OriginalProducerOrg: mspid,
+		ResalePolicy:        ResalePolicyAllowed,
+		Metadata:            assetMetadata,
+		ContentID:           contentID,
+		Version:             1,
+	}, nil
 }
 
 func (s *SmartContract) UploadKeyPrivateData(ctx contractapi.TransactionContextInterface, deviceName string, IPFS_CID string, date string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
 	mspid, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
 		return fmt.Errorf("error ocurred getting MSPID: %v", err)
 	}
 	privateCollectionName := "_implicit_org_" + mspid
+	_, err = ParseCID(IPFS_CID)
+	if err != nil {
+		return err
+	}
+
+	assetBytes, err := ctx.GetStub().GetState(CreateAssetID(deviceName, date))
+	if err != nil {
+		return fmt.Errorf("error ocurred getting asset: %v", err)
+	}
+	if assetBytes != nil {
+		var asset DataAsset
+		err = json.Unmarshal(assetBytes, &asset)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+		err = checkNotShredded(&asset)
+		if err != nil {
+			return err
+		}
+	}
 
 	transientMap, err := ctx.GetStub().GetTransient()
 	if err != nil {
@@ -230,6 +425,11 @@ func (s *SmartContract) UploadKeyPrivateData(ctx contractapi.TransactionContextI
 	symmetricKeyBytes := transientMap["symmetricKey"]
 	symmetricKey := string(symmetricKeyBytes)
 
+	return putKeyPrivateData(ctx, privateCollectionName, deviceName, IPFS_CID, date, symmetricKey)
+}
+
+// putKeyPrivateData stores the key of the asset of deviceName on date in privateCollectionName.
+func putKeyPrivateData(ctx contractapi.TransactionContextInterface, privateCollectionName string, deviceName string, IPFS_CID string, date string, symmetricKey string) error {
 	keyData := KeyCIDAsset{
 		Date:         date,
 		DeviceName:   deviceName,
@@ -283,15 +483,78 @@ func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface,
 /*
 Model:
 IoT data prefix:       data_<deviceName>_<date_
+Windowed data  :       data_<deviceName>_<windowStart>_<windowEnd>
+                       The window <windowStart>_<windowEnd> of a windowed asset takes the place of <date> in
+                       every key below.
+WindowIndex    :       windowIndex_<deviceName>_<yyyy-mm-dd>
 DataBid prefix :       bid_<deviceName>_<date>_<CurrentOwnerOrg>_<BiddingOrg>
 BidApproval    :       bidApproval_<newOwnerOrg>_<oldOwnerOrg>_<deviceName>_<date>
+SaleApproval   :       saleApproval_<deviceName>_<date>_<ApproverOrg>_<BiddingOrg>, BiddingOrg is empty for
+                       the approval of any buyer
+DataAuction    :       auction_<deviceName>_<date>
+DataLicence    :       licence_<deviceName>_<date>_<LicenseeOrg>
+TransferRecord :       transfer_<deviceName>_<date>_<txID>
+RoyaltyRecord  :       royalty_<producerOrg>_<deviceName>_<date>_<txID>
+Dispute        :       dispute_<deviceName>_<date>_<transferTxID>
+KeyDelivery    :       keyDelivery_<deviceName>_<date>_<RecipientOrg>
+KeyRenewal     :       keyRenewal_<deviceName>_<date>_<RecipientOrg>
+KeyReceipt     :       keyReceipt_<deviceName>_<date>_<RecipientOrg>_<txID>
+Rating         :       rating_<RatedOrg>_<deviceName>_<date>_<transferTxID>_<RaterOrg>
+MarketConfig   :       config
+ConfigBootstrap:       bootstrap
+Proposal       :       proposal_<txID>
+Pause          :       pause
+AssetFreeze    :       freeze_asset_<deviceName>_<date>
+OrgFreeze      :       freeze_org_<MSPID>
+ErasureRequest :       erasure_<txID>
+OpenErasure    :       openErasure_<deviceName>_<date>
+Consent        :       consent_<deviceName>
+Device         :       device_<deviceName>
+Quality        :       quality_<deviceName>_<date>_<ValidatorOrg>
+PinAttestation :       pin_<CIDv1>_<StorageOrg>
+Challenge      :       challenge_<StorageOrg>_<txID>
+OpenChallenge  :       openChallenge_<StorageOrg>_<deviceName>_<date>_<ChallengerOrg>
+AssetVersion   :       assetVersion_<deviceName>_<date>_<version>
+LineageEdge    :       lineageUp_<derivedAssetID>_<sourceAssetID> and lineageDown_<sourceAssetID>_<derivedAssetID>
+ExternalAsset  :       externalAsset_<channel>_<deviceName>_<date>
 */
 
-func (s *SmartContract) BidForData(ctx contractapi.TransactionContextInterface, deviceName string, date string, price string, additionalCommitments string) error {
-	currentAssetOwner, err := s.GetAssetOwner(ctx, deviceName, date)
+// BidForData places a bid on an asset. usageTerms is a JSON encoded UsageTerms object, additionalCommitments
+// is kept as a free text remark.
+func (s *SmartContract) BidForData(ctx contractapi.TransactionContextInterface, deviceName string, date string, price string, additionalCommitments string, usageTerms string) error {
+	_, err := parseOfferPrice(price)
+	if err != nil {
+		return err
+	}
+	terms, err := ParseUsageTerms(usageTerms)
+	if err != nil {
+		return err
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
 	if err != nil {
 		return fmt.Errorf("failed to get Asset Owner %v", err)
 	}
+	err = s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	err = s.checkPurpose(ctx, terms)
+	if err != nil {
+		return err
+	}
+	currentAssetOwner := asset.OwnerOrg
+	err = checkResaleAllowed(asset, currentAssetOwner)
+	if err != nil {
+		return err
+	}
+	err = checkNotLocked(asset)
+	if err != nil {
+		return err
+	}
+	err = checkNotShredded(asset)
+	if err != nil {
+		return err
+	}
 	biddingOrg, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
 		return fmt.Errorf("failed to get Client Identity %v", err)
@@ -306,60 +569,62 @@ func (s *SmartContract) BidForData(ctx contractapi.TransactionContextInterface,
 		Date:                  date,
 		Price:                 price,
 		AdditionalCommitments: additionalCommitments,
+		Terms:                 terms,
 		Active:                true,
+		SaleEpoch:             asset.SaleEpoch,
 	}
 
-	bidDataBytes, err := json.Marshal(bidData)
-	if err != nil {
-		return fmt.Errorf("error ocurred marshalling JSON to Byte array: %v", err)
-	}
-	return ctx.GetStub().PutState(bidID, bidDataBytes)
-}
-
-func (s *SmartContract) InactivateAllBidsForThisData(ctx contractapi.TransactionContextInterface, currentOwnerOrg string, deviceName string, date string) error {
-	startKey := "bid_" + deviceName + "_" + date + "_" + currentOwnerOrg
-	endKey := "bid_" + deviceName + "_" + date + "_" + currentOwnerOrg + "_~"
-
-	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	auction, err := s.getAuction(ctx, deviceName, date)
 	if err != nil {
 		return err
 	}
-	defer resultsIterator.Close()
-
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	if auction != nil && auction.Status == AuctionStatusOpen {
+		err = s.placeAuctionBid(ctx, auction, &bidData)
 		if err != nil {
 			return err
 		}
-
-		var bid DataBid
-		key := queryResponse.Key
-		err = json.Unmarshal(queryResponse.Value, &bid)
+	} else {
+		config, err := s.getMarketConfig(ctx)
 		if err != nil {
-			return fmt.Errorf("error unmarshalling query response into DataBid object: %v", err)
+			return err
 		}
-
-		bid.Active = false
-		updatedBidBytes, err := json.Marshal(bid)
-		if err != nil {
-			return fmt.Errorf("error marshaling bid into new object: %v", err)
+		if config.BidLifetimeSeconds > 0 {
+			now, err := getTxTime(ctx)
+			if err != nil {
+				return err
+			}
+			bidData.ExpiresAt = now.Add(time.Duration(config.BidLifetimeSeconds) * time.Second).UTC().Format(time.RFC3339)
 		}
+	}
 
-		ctx.GetStub().PutState(key, updatedBidBytes)
-		if err != nil {
-			return fmt.Errorf("error updating state for key: %v", key)
-		}
+	bidDataBytes, err := json.Marshal(bidData)
+	if err != nil {
+		return fmt.Errorf("error ocurred marshalling JSON to Byte array: %v", err)
+	}
+	err = ctx.GetStub().PutState(bidID, bidDataBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put bid to the ledger: %v", err)
+	}
 
+	if bidData.AuctionBid {
+		return s.putAuction(ctx, auction)
 	}
 	return nil
 }
 
+// GetBidsForMyOrg returns the open bids for the assets of the calling org, leaving out bids that expired or went
+// stale. It scans every bid, so it is meant to be evaluated rather than submitted.
 func (s *SmartContract) GetBidsForMyOrg(ctx contractapi.TransactionContextInterface) ([]*DataBid, error) {
 	mspid, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
 		return nil, fmt.Errorf("error ocurred getting MSPID: %v", err)
 	}
 
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	startKey := "bid_"
 	endKey := "bid_~"
 
@@ -370,6 +635,7 @@ func (s *SmartContract) GetBidsForMyOrg(ctx contractapi.TransactionContextInterf
 	defer resultsIterator.Close()
 
 	var bids []*DataBid
+	assets := map[string]*DataAsset{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
@@ -381,7 +647,19 @@ func (s *SmartContract) GetBidsForMyOrg(ctx contractapi.TransactionContextInterf
 			return nil, err
 		}
 
-		if bid.CurrentOwnerOrg == mspid && bid.Active == true {
+		if bid.CurrentOwnerOrg != mspid || bid.Active != true || bid.IsExpired(now) {
+			continue
+		}
+		assetID := CreateAssetID(bid.DeviceName, bid.Date)
+		asset, ok := assets[assetID]
+		if !ok {
+			asset, err = s.getDataAsset(ctx, bid.DeviceName, bid.Date)
+			if err != nil {
+				return nil, err
+			}
+			assets[assetID] = asset
+		}
+		if !bid.IsStale(asset) {
 			bids = append(bids, &bid)
 		}
 	}
@@ -405,7 +683,59 @@ func (s *SmartContract) AcceptBid(ctx contractapi.TransactionContextInterface, b
 	if err != nil || biddingOrg != bidJSON.BiddingOrg || clientMspid != bidJSON.CurrentOwnerOrg || price != bidJSON.Price || !bidJSON.Active {
 		return fmt.Errorf("error ocurred processing bid. mismatch between provided bid details, and bid recorded on ledger")
 	}
-	s.InactivateAllBidsForThisData(ctx, clientMspid, deviceName, date)
+
+	if bidJSON.AuctionBid {
+		return fmt.Errorf("bid from %s was placed in an auction, it is settled by CloseAuction", biddingOrg)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if bidJSON.IsExpired(now) {
+		return fmt.Errorf("bid from %s expired at %s", biddingOrg, bidJSON.ExpiresAt)
+	}
+
+	return s.transferAssetToBidder(ctx, &bidJSON)
+}
+
+// checkBidAcceptable checks that bid can still be accepted on asset without writing anything, so callers can
+// tell a sale that can't go through apart from one that failed.
+func (s *SmartContract) checkBidAcceptable(ctx contractapi.TransactionContextInterface, asset *DataAsset, bid *DataBid) error {
+	err := s.checkOrgNotFrozen(ctx, bid.BiddingOrg)
+	if err != nil {
+		return err
+	}
+	err = checkResaleAllowed(asset, bid.CurrentOwnerOrg)
+	if err != nil {
+		return err
+	}
+	err = checkNotLocked(asset)
+	if err != nil {
+		return err
+	}
+	err = checkNotShredded(asset)
+	if err != nil {
+		return err
+	}
+	err = s.checkNoOpenErasure(ctx, bid.DeviceName, bid.Date)
+	if err != nil {
+		return err
+	}
+	if bid.IsStale(asset) {
+		return fmt.Errorf("bid from %s was placed before the bidding on asset %s ended", bid.BiddingOrg, CreateAssetID(bid.DeviceName, bid.Date))
+	}
+	err = s.checkCoOwnerApproval(ctx, asset, bid)
+	if err != nil {
+		return err
+	}
+	return s.checkConsent(ctx, bid.DeviceName, bid.BiddingOrg, bid.Terms)
+}
+
+// transferAssetToBidder hands the asset over to the bidding org of an accepted bid, ends the bidding on the
+// asset and emits the bidApproval event. It reads no bids other than the accepted one, so bids placed
+// concurrently don't conflict with the sale.
+func (s *SmartContract) transferAssetToBidder(ctx contractapi.TransactionContextInterface, bid *DataBid) error {
+	deviceName, date := bid.DeviceName, bid.Date
 
 	assetID := CreateAssetID(deviceName, date)
 	asetBytes, err := ctx.GetStub().GetState(assetID)
@@ -417,8 +747,50 @@ func (s *SmartContract) AcceptBid(ctx contractapi.TransactionContextInterface, b
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal JSON: %v", err)
 	}
+	err = s.checkOperational(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	err = s.checkBidAcceptable(ctx, &assetJSON, bid)
+	if err != nil {
+		return err
+	}
+	err = s.accrueRoyalty(ctx, &assetJSON, bid.CurrentOwnerOrg, bid.Price)
+	if err != nil {
+		return err
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	platformFee, err := computePlatformFee(config, bid.Price)
+	if err != nil {
+		return err
+	}
+	proceeds, err := splitProceeds(&assetJSON, bid.Price, platformFee)
+	if err != nil {
+		return err
+	}
+	// The accepted bid is written before the asset, whose new sale epoch leaves the other bids stale.
+	bidID := "bid_" + deviceName + "_" + date + "_" + bid.CurrentOwnerOrg + "_" + bid.BiddingOrg
+	bid.Active = false
+	bid.AcceptedTxID = ctx.GetStub().GetTxID()
+	acceptedBidBytes, err := json.Marshal(bid)
+	if err != nil {
+		return fmt.Errorf("error ocurred marshalling JSON to Byte array: %v", err)
+	}
+	err = ctx.GetStub().PutState(bidID, acceptedBidBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put accepted bid to the ledger: %v", err)
+	}
 
-	assetJSON.OwnerOrg = biddingOrg
+	sellerTerms := assetJSON.OwnerTerms
+	sellerCoOwners, sellerApprovalThresholdPercent := assetJSON.CoOwners, assetJSON.ApprovalThresholdPercent
+	assetJSON.OwnerOrg = bid.BiddingOrg
+	assetJSON.OwnerTerms = bid.Terms
+	assetJSON.CoOwners = nil
+	assetJSON.ApprovalThresholdPercent = 0
+	assetJSON.SaleEpoch++
 	updatedAssetBytes, err := json.Marshal(assetJSON)
 	if err != nil {
 		return fmt.Errorf("failed to marhsal new Asset to JSON: %v", err)
@@ -429,7 +801,7 @@ func (s *SmartContract) AcceptBid(ctx contractapi.TransactionContextInterface, b
 	}
 
 	bidApprovalEvent := BidApproval{
-		Date: date, DeviceName: deviceName, NewOwnerOrg: biddingOrg, OriginalOwnerOrg: clientMspid,
+		Date: date, DeviceName: deviceName, NewOwnerOrg: bid.BiddingOrg, OriginalOwnerOrg: bid.CurrentOwnerOrg,
 	}
 	bidApprovalEventJSON, err := json.Marshal(bidApprovalEvent)
 	if err != nil {
@@ -437,19 +809,54 @@ func (s *SmartContract) AcceptBid(ctx contractapi.TransactionContextInterface, b
 	}
 
 	// bidApproval_<newOwnerOrg>_<oldOwnerOrg>_<deviceName>_<date>
-	bidApprovalId := "bidApproval_" + biddingOrg + "_" + clientMspid + "_" + deviceName + "_" + date
+	bidApprovalId := "bidApproval_" + bid.BiddingOrg + "_" + bid.CurrentOwnerOrg + "_" + deviceName + "_" + date
 	ctx.GetStub().SetEvent(bidApprovalId, bidApprovalEventJSON)
 
-	return nil
+	return s.putTransferRecord(ctx, &TransferRecord{
+		Kind:                           TransferKindSale,
+		DeviceName:                     deviceName,
+		Date:                           date,
+		FromOrg:                        bid.CurrentOwnerOrg,
+		ToOrg:                          bid.BiddingOrg,
+		Price:                          bid.Price,
+		Terms:                          bid.Terms,
+		BidID:                          bidID,
+		PlatformFee:                    platformFee,
+		Proceeds:                       proceeds,
+		SellerTerms:                    sellerTerms,
+		SellerCoOwners:                 sellerCoOwners,
+		SellerApprovalThresholdPercent: sellerApprovalThresholdPercent,
+	})
 }
 
 func (s *SmartContract) TransferEncKey(ctx contractapi.TransactionContextInterface, newOwnerOrg string, deviceName string, date string) error {
 	newOwnerCollectionName := "_implicit_org_" + newOwnerOrg
 	keyId := CreateAssetID(deviceName, date)
-	asset, err := s.GetAssetByID(ctx, deviceName+"_"+date)
+	asset, err := s.getDataAsset(ctx, deviceName, date)
 	if err != nil {
 		return fmt.Errorf("error getting asset by ID: %v", err)
 	}
+	err = checkNotShredded(asset)
+	if err != nil {
+		return err
+	}
+	err = s.checkOperational(ctx, keyId)
+	if err != nil {
+		return err
+	}
+
+	clientMspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	err = s.checkKeySender(ctx, deviceName, date, clientMspid, newOwnerOrg)
+	if err != nil {
+		return err
+	}
+	err = s.checkNoOpenErasure(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
 
 	transientMap, err := ctx.GetStub().GetTransient()
 	if err != nil {
@@ -470,6 +877,26 @@ func (s *SmartContract) TransferEncKey(ctx contractapi.TransactionContextInterfa
 		return fmt.Errorf("error putting private data into new owners implicit collection: %v", err)
 	}
 
+	// The key of a corrected version is a renewal, unless the org was still owed the key it bought.
+	renewed, err := s.recordKeyRenewed(ctx, deviceName, date, clientMspid, newOwnerOrg)
+	if err != nil {
+		return err
+	}
+	delivery, err := s.getKeyDelivery(ctx, deviceName, date, newOwnerOrg)
+	if err != nil {
+		return err
+	}
+	if !renewed || delivery == nil || delivery.Status != KeyDeliveryStatusDelivered {
+		err = s.recordKeyDelivered(ctx, deviceName, date, clientMspid, newOwnerOrg)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.putKeyReceipt(ctx, deviceName, date, clientMspid, newOwnerOrg)
+	if err != nil {
+		return err
+	}
+
 	// //Lines below were commented as we don't want to delete the private key for the old owner org.
 	// clientMspid, err := ctx.GetClientIdentity().GetMSPID()
 	// if err != nil {
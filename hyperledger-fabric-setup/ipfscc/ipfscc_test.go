@@ -14,7 +14,9 @@ import (
 	"fmt"
 	"ipfscc/mocks"
 	"os"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
@@ -22,6 +24,7 @@ import (
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mocks/transaction.go -fake-name TransactionContext . transactionContext
@@ -50,11 +53,18 @@ type transientInputForKey struct {
 
 const myOrg1Msp = "Org1Testmsp"
 const myOrg1Clientid = "myOrg1Userid"
+const myOrg2Msp = "Org2Testmsp"
+const myOrg3Msp = "Org3Testmsp"
 
 const testDeviceName = "testDevice_4000"
-const testCID = "42421337"
+const testCID = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
 const testDataDate = "02-02-2000"
 const testEncryptionKey = "a1234"
+const testMetadata = `{"schemaVersion":1,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"readingCount":1440,"byteSize":52000,"compression":"deflate","encryptionAlgorithm":"AES-256-ECB"}`
+const testUsageTerms = `{"purpose":"energy research","retentionDays":30,"resaleAllowed":false,"attributionRequired":true}`
+
+// testResaleUsageTerms let the buyer resell, sellTestAsset sells under them so assets can be sold on.
+const testResaleUsageTerms = `{"purpose":"energy research","retentionDays":30,"resaleAllowed":true,"attributionRequired":true}`
 
 func TestCreateAssetID(t *testing.T) {
 	// Use require here because if this function doesn't work, everything is bound to be false, and we use it in the tests for some assertions too!
@@ -70,7 +80,7 @@ func TestUploadDataAsAsset(t *testing.T) {
 	assetTransferCC := SmartContract{}
 
 	// No transient map
-	err := assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, testDataDate)
+	err := assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, testDataDate, testMetadata)
 	assert.NoError(t, err)
 	putStateCallCount := chaincodeStub.PutStateCallCount()
 	assert.Equal(t, putStateCallCount, 1)
@@ -191,34 +201,32 @@ func TestGetMyOrgsDataAssets(t *testing.T) {
 }
 
 func TestTransferEncKey(t *testing.T) {
-	transactionContext, chaincodeStub := prepMocks(myOrg1Msp, myOrg1Clientid)
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
 	assetTransferCC := SmartContract{}
-
-	testNewOwnerOrg := "newOwnerOrg"
-
-	asset := DataAsset{
-		AssetName: testDeviceName,
-		Date:      testDataDate,
-		IPFS_CID:  testCID,
-		OwnerOrg:  "anotherOrgsData",
-	}
-	assetBytes, _ := json.Marshal(asset)
-	chaincodeStub.GetStateReturnsOnCall(0, assetBytes, nil)
-
-	symmetricKeyBytes := []byte(testEncryptionKey)
-	assetPropMap := map[string][]byte{
-		"symmetricKey": symmetricKeyBytes,
-	}
-	chaincodeStub.GetTransientReturns(assetPropMap, nil)
-
-	err := assetTransferCC.TransferEncKey(transactionContext, testNewOwnerOrg, testDeviceName, testDataDate)
-	assert.NoError(t, err)
-
-	_, _, argBytes := chaincodeStub.PutPrivateDataArgsForCall(0)
-	var argData KeyCIDAsset
-	json.Unmarshal(argBytes, &argData)
-	assert.Equal(t, argData.IPFS_CID, testCID)
-	assert.Equal(t, argData.SymmetricKey, testEncryptionKey)
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+
+	assert.ErrorContains(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate), "no key",
+		"no key is owed before a sale")
+	ledger.setTxID("saleTx")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate), "owed to "+myOrg2Msp+" by "+myOrg1Msp,
+		"only the seller delivers the key")
+
+	ledger.as(myOrg1Msp)
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate)
+	}))
+	var keyData KeyCIDAsset
+	require.NoError(t, json.Unmarshal(ledger.privateData["_implicit_org_"+myOrg2Msp][CreateAssetID(testDeviceName, testDataDate)], &keyData))
+	assert.Equal(t, testCID, keyData.IPFS_CID)
+	assert.Equal(t, testEncryptionKey, keyData.SymmetricKey)
+	var delivery KeyDelivery
+	ledger.getJSON(t, CreateKeyDeliveryID(testDeviceName, testDataDate, myOrg2Msp), &delivery)
+	assert.Equal(t, KeyDeliveryStatusDelivered, delivery.Status)
+	assert.Equal(t, myOrg1Msp, delivery.SenderOrg)
+	assert.Equal(t, "saleTx", delivery.TransferTxID)
 }
 
 func TestGetOtherOrgsDataAssets(t *testing.T) {
@@ -356,6 +364,8 @@ func TestGetBidsForMyOrg(t *testing.T) {
 	mockIterator.NextReturns(&queryresult.KV{Value: expectedBidBytes}, nil)
 
 	chaincodeStub.GetStateByRangeReturns(mockIterator, nil)
+	assetBytes, _ := json.Marshal(DataAsset{AssetName: testDeviceName, Date: testDataDate, OwnerOrg: myOrg1Msp})
+	chaincodeStub.GetStateReturns(assetBytes, nil)
 
 	bidsForMyOrg, err := assetTransferCC.GetBidsForMyOrg(transactionContext)
 	argOne, argTwo := chaincodeStub.GetStateByRangeArgsForCall(0)
@@ -433,7 +443,7 @@ func TestBidForData(t *testing.T) {
 
 	chaincodeStub.GetStateReturnsOnCall(0, expectedAssetBytes, nil)
 
-	err := assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, testBidPrice, testBidCommitments)
+	err := assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, testBidPrice, testBidCommitments, testUsageTerms)
 	assert.NoError(t, err)
 
 	_, putStateArgBytes := chaincodeStub.PutStateArgsForCall(0)
@@ -444,41 +454,6 @@ func TestBidForData(t *testing.T) {
 	assert.Equal(t, putStateArg.BiddingOrg, myOrg1Msp)
 }
 
-func TestInactivateAllBidsForThisData(t *testing.T) {
-	transactionContext, chaincodeStub := prepMocks(myOrg1Msp, myOrg1Clientid)
-	assetTransferCC := SmartContract{}
-
-	const testBidPrice = "1001"
-	const biddingOrg = "biddingOrg"
-
-	expectedBid := DataBid{
-		AdditionalCommitments: "",
-		BiddingOrg:            biddingOrg,
-		CurrentOwnerOrg:       myOrg1Msp,
-		DeviceName:            testDeviceName,
-		Date:                  testDataDate,
-		Price:                 testBidPrice,
-		Active:                true,
-	}
-	expectedBidBytes, _ := json.Marshal(expectedBid)
-
-	mockIterator := &mocks.StateQueryIterator{}
-	mockIterator.HasNextReturnsOnCall(0, true)
-	mockIterator.HasNextReturnsOnCall(1, false)
-	mockIterator.NextReturns(&queryresult.KV{Value: expectedBidBytes}, nil)
-
-	chaincodeStub.GetStateByRangeReturns(mockIterator, nil)
-
-	err := assetTransferCC.InactivateAllBidsForThisData(transactionContext, myOrg1Msp, testDeviceName, testDataDate)
-	assert.NoError(t, err)
-
-	_, putStateBytes := chaincodeStub.PutStateArgsForCall(0)
-	var putState DataBid
-	err = json.Unmarshal(putStateBytes, &putState)
-	assert.NoError(t, err)
-	assert.False(t, putState.Active)
-}
-
 func prepMocks(orgMSP, clientId string) (*mocks.TransactionContext, *mocks.ChaincodeStub) {
 	chaincodeStub := &mocks.ChaincodeStub{}
 	transactionContext := &mocks.TransactionContext{}
@@ -490,6 +465,8 @@ func prepMocks(orgMSP, clientId string) (*mocks.TransactionContext, *mocks.Chain
 	// set matching msp ID using peer shim env variable
 	os.Setenv("CORE_PEER_LOCALMSPID", orgMSP)
 	transactionContext.GetClientIdentityReturns(clientIdentity)
+	chaincodeStub.GetTxTimestampReturns(timestamppb.New(time.Date(2000, 2, 2, 12, 0, 0, 0, time.UTC)), nil)
+	chaincodeStub.GetStateByRangeReturns(&mocks.StateQueryIterator{}, nil)
 	return transactionContext, chaincodeStub
 }
 
@@ -509,3 +486,225 @@ func getMockStateByRangeIterator(ownerOrg string) *mocks.StateQueryIterator {
 
 	return mockIterator
 }
+
+// mockLedger backs a ChaincodeStub mock with in-memory world state and private data, so that
+// transactions that read back what earlier transactions wrote can be tested end to end.
+type mockLedger struct {
+	state       map[string][]byte
+	privateData map[string]map[string][]byte
+	events      map[string][]byte
+	lastEvent   string
+	txID        string
+	clientID    *mocks.ClientIdentity
+	stub        *mocks.ChaincodeStub
+	ctx         *mocks.TransactionContext
+}
+
+func prepLedgerMocks(orgMSP string) (*mocks.TransactionContext, *mockLedger) {
+	transactionContext, chaincodeStub := prepMocks(orgMSP, myOrg1Clientid)
+	ledger := &mockLedger{
+		state:       map[string][]byte{},
+		privateData: map[string]map[string][]byte{},
+		events:      map[string][]byte{},
+		txID:        "tx0",
+		clientID:    transactionContext.GetClientIdentity().(*mocks.ClientIdentity),
+		stub:        chaincodeStub,
+		ctx:         transactionContext,
+	}
+
+	chaincodeStub.GetStateCalls(func(key string) ([]byte, error) {
+		return ledger.state[key], nil
+	})
+	chaincodeStub.PutStateCalls(func(key string, value []byte) error {
+		ledger.state[key] = value
+		return nil
+	})
+	chaincodeStub.DelStateCalls(func(key string) error {
+		delete(ledger.state, key)
+		return nil
+	})
+	chaincodeStub.GetStateByRangeCalls(func(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+		var keys []string
+		for key := range ledger.state {
+			if key >= startKey && (endKey == "" || key < endKey) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		mockIterator := &mocks.StateQueryIterator{}
+		for i, key := range keys {
+			mockIterator.HasNextReturnsOnCall(i, true)
+			mockIterator.NextReturnsOnCall(i, &queryresult.KV{Key: key, Value: ledger.state[key]}, nil)
+		}
+		mockIterator.HasNextReturnsOnCall(len(keys), false)
+		return mockIterator, nil
+	})
+	chaincodeStub.GetPrivateDataCalls(func(collection string, key string) ([]byte, error) {
+		return ledger.privateData[collection][key], nil
+	})
+	chaincodeStub.PutPrivateDataCalls(func(collection string, key string, value []byte) error {
+		if ledger.privateData[collection] == nil {
+			ledger.privateData[collection] = map[string][]byte{}
+		}
+		ledger.privateData[collection][key] = value
+		return nil
+	})
+	chaincodeStub.DelPrivateDataCalls(func(collection string, key string) error {
+		delete(ledger.privateData[collection], key)
+		return nil
+	})
+	chaincodeStub.PurgePrivateDataCalls(func(collection string, key string) error {
+		delete(ledger.privateData[collection], key)
+		return nil
+	})
+	chaincodeStub.GetTxIDCalls(func() string {
+		return ledger.txID
+	})
+	chaincodeStub.SetEventCalls(func(name string, payload []byte) error {
+		ledger.events[name] = payload
+		ledger.lastEvent = name
+		return nil
+	})
+
+	return transactionContext, ledger
+}
+
+// as switches the org that submits the following transactions.
+func (l *mockLedger) as(orgMSP string) {
+	l.clientID.GetMSPIDReturns(orgMSP, nil)
+}
+
+func (l *mockLedger) setTxID(txID string) {
+	l.txID = txID
+}
+
+func (l *mockLedger) setTime(t time.Time) {
+	l.stub.GetTxTimestampReturns(timestamppb.New(t), nil)
+}
+
+func (l *mockLedger) getJSON(t *testing.T, key string, v interface{}) {
+	value, ok := l.state[key]
+	require.True(t, ok, "key %s not found in world state", key)
+	require.NoError(t, json.Unmarshal(value, v))
+}
+
+// setConfig stores the default market config with the test's changes applied.
+func (l *mockLedger) setConfig(t *testing.T, change func(config *MarketConfig)) {
+	config := defaultMarketConfig()
+	config.MemberOrgs = []string{myOrg1Msp, myOrg2Msp, myOrg3Msp}
+	change(config)
+	require.NoError(t, config.Validate())
+	l.putJSON(t, configKey, config)
+}
+
+func (l *mockLedger) putJSON(t *testing.T, key string, v interface{}) {
+	value, err := json.Marshal(v)
+	require.NoError(t, err)
+	l.state[key] = value
+}
+
+// txSimulation is the read and write set of a transaction endorsed against the committed world state.
+type txSimulation struct {
+	reads  []string
+	ranges [][2]string
+	writes map[string][]byte
+}
+
+// simulateTx endorses tx the way a peer does: reads see the committed state only, and writes are collected
+// in the write set instead of being applied.
+func simulateTx(t *testing.T, ledger *mockLedger, tx func() error) *txSimulation {
+	sim, err := endorseTx(ledger, tx)
+	require.NoError(t, err)
+	return sim
+}
+
+// submitTx endorses tx and commits it alone in a block, so tx doesn't see its own writes like on a peer. The
+// writes of a tx that fails are dropped.
+func submitTx(t *testing.T, ledger *mockLedger, tx func() error) error {
+	sim, err := endorseTx(ledger, tx)
+	if err != nil {
+		return err
+	}
+	require.True(t, commitBlock(ledger, []*txSimulation{sim})[0])
+	return nil
+}
+
+func endorseTx(ledger *mockLedger, tx func() error) (*txSimulation, error) {
+	sim := &txSimulation{writes: map[string][]byte{}}
+	getState, getStateByRange := ledger.stub.GetStateStub, ledger.stub.GetStateByRangeStub
+	putState, delState := ledger.stub.PutStateStub, ledger.stub.DelStateStub
+	defer func() {
+		ledger.stub.GetStateCalls(getState)
+		ledger.stub.GetStateByRangeCalls(getStateByRange)
+		ledger.stub.PutStateCalls(putState)
+		ledger.stub.DelStateCalls(delState)
+	}()
+
+	ledger.stub.GetStateCalls(func(key string) ([]byte, error) {
+		sim.reads = append(sim.reads, key)
+		return getState(key)
+	})
+	ledger.stub.GetStateByRangeCalls(func(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+		sim.ranges = append(sim.ranges, [2]string{startKey, endKey})
+		return getStateByRange(startKey, endKey)
+	})
+	ledger.stub.PutStateCalls(func(key string, value []byte) error {
+		sim.writes[key] = value
+		return nil
+	})
+	ledger.stub.DelStateCalls(func(key string) error {
+		sim.writes[key] = nil
+		return nil
+	})
+	return sim, tx()
+}
+
+// commitBlock validates the transactions of a block in order, and applies the writes of those that pass. A
+// transaction fails if a transaction before it in the block wrote a key it read, or a key in a range it scanned.
+// It returns whether each transaction passed.
+func commitBlock(ledger *mockLedger, block []*txSimulation) []bool {
+	written := map[string]bool{}
+	valid := make([]bool, len(block))
+	for i, sim := range block {
+		valid[i] = true
+		for _, key := range sim.reads {
+			valid[i] = valid[i] && !written[key]
+		}
+		for _, keyRange := range sim.ranges {
+			for key := range written {
+				valid[i] = valid[i] && (key < keyRange[0] || key >= keyRange[1])
+			}
+		}
+		if !valid[i] {
+			continue
+		}
+		for key, value := range sim.writes {
+			written[key] = true
+			if value == nil {
+				delete(ledger.state, key)
+			} else {
+				ledger.state[key] = value
+			}
+		}
+	}
+	return valid
+}
+
+func uploadTestAsset(t *testing.T, ctx *mocks.TransactionContext, ledger *mockLedger, ownerOrg string) {
+	ledger.as(ownerOrg)
+	assetTransferCC := SmartContract{}
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.UploadDataAsAsset(ctx, testDeviceName, testCID, testDataDate, testMetadata)
+	}))
+}
+
+func sellTestAsset(t *testing.T, assetTransferCC *SmartContract, ledger *mockLedger, fromOrg string, toOrg string, price string) error {
+	transactionContext := ledger.ctx
+	ledger.as(toOrg)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, price, "", testResaleUsageTerms))
+	ledger.as(fromOrg)
+	return submitTx(t, ledger, func() error {
+		return assetTransferCC.AcceptBid(transactionContext, toOrg, testDeviceName, testDataDate, price)
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	KeyDeliveryStatusPending   = "pending"
+	KeyDeliveryStatusDelivered = "delivered"
+)
+
+// KeyDelivery tracks the symmetric key of an asset owed to, or delivered to, an org. TransferTxID is the
+// transaction that made the key owed. Keys of corrected versions are tracked apart, see KeyRenewal.
+type KeyDelivery struct {
+	DeviceName   string `json:"deviceName"`
+	Date         string `json:"date"`
+	SenderOrg    string `json:"senderOrg"`
+	RecipientOrg string `json:"recipientOrg"`
+	TransferTxID string `json:"transferTxID"`
+	DueBy        string `json:"dueBy"`
+	DeliveredAt  string `json:"deliveredAt"`
+	Status       string `json:"status"`
+}
+
+func CreateKeyDeliveryID(deviceName string, date string, recipientOrg string) string {
+	return "keyDelivery_" + deviceName + "_" + date + "_" + recipientOrg
+}
+
+// IsLate reports whether the key was, or still is, delivered after its due time.
+func (d *KeyDelivery) IsLate(now time.Time) bool {
+	if d.DueBy == "" {
+		return false
+	}
+	dueBy, err := time.Parse(time.RFC3339, d.DueBy)
+	if err != nil {
+		return false
+	}
+	if d.Status == KeyDeliveryStatusPending {
+		return now.After(dueBy)
+	}
+	deliveredAt, err := time.Parse(time.RFC3339, d.DeliveredAt)
+	if err != nil {
+		return false
+	}
+	return deliveredAt.After(dueBy)
+}
+
+func (s *SmartContract) putKeyDelivery(ctx contractapi.TransactionContextInterface, delivery *KeyDelivery) error {
+	deliveryBytes, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key delivery to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateKeyDeliveryID(delivery.DeviceName, delivery.Date, delivery.RecipientOrg), deliveryBytes)
+}
+
+func (s *SmartContract) getKeyDelivery(ctx contractapi.TransactionContextInterface, deviceName string, date string, recipientOrg string) (*KeyDelivery, error) {
+	deliveryBytes, err := ctx.GetStub().GetState(CreateKeyDeliveryID(deviceName, date, recipientOrg))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting key delivery: %v", err)
+	}
+	if deliveryBytes == nil {
+		return nil, nil
+	}
+
+	var delivery KeyDelivery
+	err = json.Unmarshal(deliveryBytes, &delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key delivery JSON: %v", err)
+	}
+	return &delivery, nil
+}
+
+// checkKeySender checks that senderOrg owes recipientOrg the key of the asset of deviceName on date: as the
+// seller or licensor named in the transfer the key is owed for, or as the owner that renewed the key of a
+// corrected version, which recordKeyRenewed checks.
+func (s *SmartContract) checkKeySender(ctx contractapi.TransactionContextInterface, deviceName string, date string, senderOrg string, recipientOrg string) error {
+	renewal, err := s.getKeyRenewal(ctx, deviceName, date, recipientOrg)
+	if err != nil {
+		return err
+	}
+	if renewal != nil && renewal.Status == KeyDeliveryStatusPending {
+		return nil
+	}
+	delivery, err := s.getKeyDelivery(ctx, deviceName, date, recipientOrg)
+	if err != nil {
+		return err
+	}
+	if delivery == nil {
+		return fmt.Errorf("no key of asset %s is owed to %s", CreateAssetID(deviceName, date), recipientOrg)
+	}
+	owingOrg := delivery.SenderOrg
+	if delivery.TransferTxID != "" {
+		transfer, err := s.getTransferRecord(ctx, deviceName, date, delivery.TransferTxID)
+		if err != nil {
+			return err
+		}
+		owingOrg = transfer.FromOrg
+	}
+	if owingOrg != senderOrg {
+		return fmt.Errorf("the key of asset %s is owed to %s by %s", CreateAssetID(deviceName, date), recipientOrg, owingOrg)
+	}
+	return nil
+}
+
+// recordKeyDelivered marks the key of an asset as delivered to recipientOrg, and records the delivery if
+// it was not owed because of a sale. The sender of an owed key is kept as it was recorded.
+func (s *SmartContract) recordKeyDelivered(ctx contractapi.TransactionContextInterface, deviceName string, date string, senderOrg string, recipientOrg string) error {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	delivery, err := s.getKeyDelivery(ctx, deviceName, date, recipientOrg)
+	if err != nil {
+		return err
+	}
+	if delivery == nil {
+		delivery = &KeyDelivery{DeviceName: deviceName, Date: date, SenderOrg: senderOrg, RecipientOrg: recipientOrg}
+	}
+	delivery.DeliveredAt = now.UTC().Format(time.RFC3339)
+	delivery.Status = KeyDeliveryStatusDelivered
+	return s.putKeyDelivery(ctx, delivery)
+}
+
+// KeyReceipt records one delivery of the key of an asset with TransferEncKey. Receipts are only ever added, so
+// unlike KeyDelivery, which is reset when the key is owed again, they keep every org that ever got the key.
+type KeyReceipt struct {
+	DeviceName   string `json:"deviceName"`
+	Date         string `json:"date"`
+	SenderOrg    string `json:"senderOrg"`
+	RecipientOrg string `json:"recipientOrg"`
+	TxID         string `json:"txID"`
+	ReceivedAt   string `json:"receivedAt"`
+}
+
+func CreateKeyReceiptID(deviceName string, date string, recipientOrg string, txID string) string {
+	return "keyReceipt_" + deviceName + "_" + date + "_" + recipientOrg + "_" + txID
+}
+
+func (s *SmartContract) putKeyReceipt(ctx contractapi.TransactionContextInterface, deviceName string, date string, senderOrg string, recipientOrg string) error {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	receipt := KeyReceipt{
+		DeviceName:   deviceName,
+		Date:         date,
+		SenderOrg:    senderOrg,
+		RecipientOrg: recipientOrg,
+		TxID:         ctx.GetStub().GetTxID(),
+		ReceivedAt:   now.UTC().Format(time.RFC3339),
+	}
+	receiptBytes, err := json.Marshal(receipt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key receipt to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateKeyReceiptID(deviceName, date, recipientOrg, receipt.TxID), receiptBytes)
+}
+
+// getKeyRecipients returns every org the key of the asset of deviceName on date was ever delivered to.
+func (s *SmartContract) getKeyRecipients(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]string, error) {
+	startKey := "keyReceipt_" + deviceName + "_" + date + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, startKey+"~")
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var recipients []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var receipt KeyReceipt
+		err = json.Unmarshal(queryResponse.Value, &receipt)
+		if err != nil {
+			return nil, err
+		}
+		// The prefix of one date can match a window starting on it, so the receipt is checked too.
+		if receipt.DeviceName == deviceName && receipt.Date == date && !contains(recipients, receipt.RecipientOrg) {
+			recipients = append(recipients, receipt.RecipientOrg)
+		}
+	}
+	return recipients, nil
+}
+
+func (s *SmartContract) getKeyDeliveries(ctx contractapi.TransactionContextInterface, startKey string, endKey string) ([]*KeyDelivery, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var deliveries []*KeyDelivery
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var delivery KeyDelivery
+		err = json.Unmarshal(queryResponse.Value, &delivery)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
+
+// GetKeyDeliveriesForAsset returns every key delivery owed or made for the asset of deviceName on date.
+func (s *SmartContract) GetKeyDeliveriesForAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]*KeyDelivery, error) {
+	startKey := "keyDelivery_" + deviceName + "_" + date + "_"
+	endKey := "keyDelivery_" + deviceName + "_" + date + "_~"
+	return s.getKeyDeliveries(ctx, startKey, endKey)
+}
+
+// KeyHolderViaProducer and KeyHolderViaCoOwnership complement the transfer kinds a key holder can get its key through.
+const (
+	KeyHolderViaProducer    = "producer"
+	KeyHolderViaCoOwnership = "coOwnership"
+)
+
+// KeyHolder is an org that holds the key of an asset, how it got it, and the purpose it declared for the data.
+type KeyHolder struct {
+	Org          string `json:"org"`
+	Via          string `json:"via"`
+	Purpose      string `json:"purpose"`
+	TransferTxID string `json:"transferTxID"`
+	DeliveredAt  string `json:"deliveredAt"`
+}
+
+// GetKeyHoldersForAsset lets auditors see every org holding the key of the asset of deviceName on date, and
+// the purpose each declared when it bought or licensed the data. Shredded assets have no key holders.
+func (s *SmartContract) GetKeyHoldersForAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]*KeyHolder, error) {
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return nil, err
+	}
+	holders := []*KeyHolder{}
+	if asset.Shredded {
+		return holders, nil
+	}
+	holders = append(holders, &KeyHolder{Org: asset.OriginalProducerOrg, Via: KeyHolderViaProducer})
+
+	transfers, err := s.GetTransfersForAsset(ctx, deviceName, date)
+	if err != nil {
+		return nil, err
+	}
+	transfersByTxID := map[string]*TransferRecord{}
+	for _, transfer := range transfers {
+		transfersByTxID[transfer.TxID] = transfer
+	}
+
+	deliveries, err := s.GetKeyDeliveriesForAsset(ctx, deviceName, date)
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != KeyDeliveryStatusDelivered || delivery.RecipientOrg == asset.OriginalProducerOrg {
+			continue
+		}
+		holder := &KeyHolder{
+			Org:          delivery.RecipientOrg,
+			Via:          KeyHolderViaCoOwnership,
+			TransferTxID: delivery.TransferTxID,
+			DeliveredAt:  delivery.DeliveredAt,
+		}
+		if transfer, ok := transfersByTxID[delivery.TransferTxID]; ok {
+			holder.Via = transfer.Kind
+			if transfer.Terms != nil {
+				holder.Purpose = transfer.Terms.Purpose
+			}
+		}
+		holders = append(holders, holder)
+	}
+	return holders, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// LineageEdge records that DerivedAssetID was computed from SourceAssetID. It is stored under both a
+// lineageUp_ and a lineageDown_ key, so lineage can be walked in either direction.
+type LineageEdge struct {
+	SourceAssetID  string `json:"sourceAssetID"`
+	DerivedAssetID string `json:"derivedAssetID"`
+	UploaderOrg    string `json:"uploaderOrg"`
+	CreatedAt      string `json:"createdAt"`
+}
+
+// InheritedRestriction is a resale restriction a derived asset inherits from the producer of some of its
+// sources. Unlike the resale policy of the derived asset itself, it applies to every sale of the derived asset,
+// including those by its uploader.
+type InheritedRestriction struct {
+	ProducerOrg    string   `json:"producerOrg"`
+	ResalePolicy   string   `json:"resalePolicy"`
+	RoyaltyPercent int      `json:"royaltyPercent"`
+	SourceAssetIDs []string `json:"sourceAssetIDs"`
+}
+
+// AssetLineage holds every lineage edge reachable from AssetID, walking towards its sources in Upstream and
+// towards the assets derived from it in Downstream.
+type AssetLineage struct {
+	AssetID    string         `json:"assetID"`
+	Upstream   []*LineageEdge `json:"upstream"`
+	Downstream []*LineageEdge `json:"downstream"`
+}
+
+func CreateLineageUpstreamID(derivedAssetID string, sourceAssetID string) string {
+	return "lineageUp_" + derivedAssetID + "_" + sourceAssetID
+}
+
+func CreateLineageDownstreamID(sourceAssetID string, derivedAssetID string) string {
+	return "lineageDown_" + sourceAssetID + "_" + derivedAssetID
+}
+
+// sourceRestriction returns the resale restriction the uploader of a derived asset takes on by using source,
+// nil if it may resell the source freely. A licensee whose terms forbid resale may not resell derived data either.
+func sourceRestriction(source *DataAsset, uploaderOrg string, licence *DataLicence) *InheritedRestriction {
+	if source.OriginalProducerOrg == "" || source.OriginalProducerOrg == uploaderOrg {
+		return nil
+	}
+	policy, royaltyPercent := source.ResalePolicy, source.RoyaltyPercent
+	if licence != nil && licence.Terms != nil && !licence.Terms.ResaleAllowed {
+		policy, royaltyPercent = ResalePolicyNone, 0
+	}
+	if policy != ResalePolicyNone && policy != ResalePolicyRoyalty {
+		return nil
+	}
+	return &InheritedRestriction{ProducerOrg: source.OriginalProducerOrg, ResalePolicy: policy, RoyaltyPercent: royaltyPercent}
+}
+
+// mergeRestriction adds the restriction of sourceAssetID to restrictions, keeping one restriction per producer:
+// forbidding resale outranks a royalty, and the highest royalty applies.
+func mergeRestriction(restrictions []*InheritedRestriction, restriction *InheritedRestriction, sourceAssetID string) []*InheritedRestriction {
+	for _, existing := range restrictions {
+		if existing.ProducerOrg != restriction.ProducerOrg {
+			continue
+		}
+		if !contains(existing.SourceAssetIDs, sourceAssetID) {
+			existing.SourceAssetIDs = append(existing.SourceAssetIDs, sourceAssetID)
+		}
+		if existing.ResalePolicy == ResalePolicyNone || restriction.ResalePolicy == ResalePolicyNone {
+			existing.ResalePolicy, existing.RoyaltyPercent = ResalePolicyNone, 0
+		} else if restriction.RoyaltyPercent > existing.RoyaltyPercent {
+			existing.RoyaltyPercent = restriction.RoyaltyPercent
+		}
+		return restrictions
+	}
+	restriction.SourceAssetIDs = []string{sourceAssetID}
+	return append(restrictions, restriction)
+}
+
+// checkSourceRights checks that mspid owns, co-owns or holds a granted licence for the source asset, and
+// returns the licence it relies on, nil if it owns the source.
+func (s *SmartContract) checkSourceRights(ctx contractapi.TransactionContextInterface, source *DataAsset, mspid string) (*DataLicence, error) {
+	if source.ShareOf(mspid) > 0 {
+		return nil, nil
+	}
+	licence, err := s.GetLicence(ctx, mspid, source.AssetName, source.Date)
+	if err != nil || licence.Status != LicenceStatusGranted {
+		return nil, fmt.Errorf("org %s neither owns nor is licensed for the source asset %s", mspid, CreateAssetID(source.AssetName, source.Date))
+	}
+	return licence, nil
+}
+
+// UploadDerivedDataAsAsset records a new asset for a dataset computed from other assets, such as an aggregate,
+// named datasetName and dated date. sourceAssetIDs is a JSON array of the IDs of the source assets, which the
+// calling org must own, co-own or be licensed for. Lineage edges are recorded for every source, and the resale
+// restrictions of the sources carry over to the derived asset, see InheritedRestriction.
+func (s *SmartContract) UploadDerivedDataAsAsset(ctx contractapi.TransactionContextInterface, datasetName string, cid string, date string, metadata string, sourceAssetIDs string) error {
+	var sourceIDs []string
+	err := json.Unmarshal([]byte(sourceAssetIDs), &sourceIDs)
+	if err != nil {
+		return fmt.Errorf("source asset IDs are not a valid JSON array: %v", err)
+	}
+	if len(sourceIDs) == 0 {
+		return fmt.Errorf("a derived asset needs at least one source asset")
+	}
+	err = s.checkOperational(ctx, sourceIDs...)
+	if err != nil {
+		return err
+	}
+	asset, err := s.newDailyDataAsset(ctx, datasetName, cid, date, metadata)
+	if err != nil {
+		return err
+	}
+	derivedID := CreateAssetID(datasetName, date)
+
+	var restrictions []*InheritedRestriction
+	for i, sourceID := range sourceIDs {
+		if contains(sourceIDs[:i], sourceID) {
+			return fmt.Errorf("source asset %s is listed twice", sourceID)
+		}
+		sourceBytes, err := ctx.GetStub().GetState(sourceID)
+		if err != nil {
+			return fmt.Errorf("error ocurred getting asset: %v", err)
+		}
+		if sourceBytes == nil {
+			return fmt.Errorf("the source asset %s does not exist", sourceID)
+		}
+		var source DataAsset
+		err = json.Unmarshal(sourceBytes, &source)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+		err = checkNotShredded(&source)
+		if err != nil {
+			return err
+		}
+		licence, err := s.checkSourceRights(ctx, &source, asset.OwnerOrg)
+		if err != nil {
+			return err
+		}
+		restriction := sourceRestriction(&source, asset.OwnerOrg, licence)
+		if restriction != nil {
+			restrictions = mergeRestriction(restrictions, restriction, sourceID)
+		}
+		// Restrictions the source inherited from its own sources carry over as well.
+		for _, inherited := range source.InheritedRestrictions {
+			if inherited.ProducerOrg != asset.OwnerOrg {
+				restrictions = mergeRestriction(restrictions, &InheritedRestriction{
+					ProducerOrg: inherited.ProducerOrg, ResalePolicy: inherited.ResalePolicy, RoyaltyPercent: inherited.RoyaltyPercent,
+				}, sourceID)
+			}
+		}
+	}
+	totalRoyaltyPercent := 0
+	for _, restriction := range restrictions {
+		totalRoyaltyPercent += restriction.RoyaltyPercent
+	}
+	if totalRoyaltyPercent > 100 {
+		return fmt.Errorf("the sources of asset %s claim %d percent royalties, more than the sale price", derivedID, totalRoyaltyPercent)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sourceID := range sourceIDs {
+		edgeBytes, err := json.Marshal(LineageEdge{
+			SourceAssetID:  sourceID,
+			DerivedAssetID: derivedID,
+			UploaderOrg:    asset.OwnerOrg,
+			CreatedAt:      now.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal lineage edge to JSON: %v", err)
+		}
+		err = ctx.GetStub().PutState(CreateLineageUpstreamID(derivedID, sourceID), edgeBytes)
+		if err != nil {
+			return fmt.Errorf("failed to put lineage edge: %v", err)
+		}
+		err = ctx.GetStub().PutState(CreateLineageDownstreamID(sourceID, derivedID), edgeBytes)
+		if err != nil {
+			return fmt.Errorf("failed to put lineage edge: %v", err)
+		}
+	}
+
+	asset.DerivedFrom = sourceIDs
+	asset.InheritedRestrictions = restrictions
+	return s.putDataAsset(ctx, asset)
+}
+
+// getLineageEdges returns the edges stored under prefix for assetID, the derived asset of upstream edges and
+// the source asset of downstream edges.
+func (s *SmartContract) getLineageEdges(ctx contractapi.TransactionContextInterface, prefix string, assetID string, upstream bool) ([]*LineageEdge, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix+assetID+"_", prefix+assetID+"_~")
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var edges []*LineageEdge
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var edge LineageEdge
+		err = json.Unmarshal(queryResponse.Value, &edge)
+		if err != nil {
+			return nil, err
+		}
+		// Asset IDs that start with assetID and an underscore fall in the same range.
+		if (upstream && edge.DerivedAssetID == assetID) || (!upstream && edge.SourceAssetID == assetID) {
+			edges = append(edges, &edge)
+		}
+	}
+	return edges, nil
+}
+
+// walkLineage collects the edges reachable from assetID in one direction, breadth first.
+func (s *SmartContract) walkLineage(ctx contractapi.TransactionContextInterface, assetID string, upstream bool) ([]*LineageEdge, error) {
+	prefix := "lineageDown_"
+	if upstream {
+		prefix = "lineageUp_"
+	}
+	walked := []*LineageEdge{}
+	visited := []string{assetID}
+	queue := []string{assetID}
+	for len(queue) > 0 {
+		edges, err := s.getLineageEdges(ctx, prefix, queue[0], upstream)
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, edge := range edges {
+			walked = append(walked, edge)
+			next := edge.DerivedAssetID
+			if upstream {
+				next = edge.SourceAssetID
+			}
+			if !contains(visited, next) {
+				visited = append(visited, next)
+				queue = append(queue, next)
+			}
+		}
+	}
+	return walked, nil
+}
+
+// GetAssetLineage returns the lineage of the asset with assetID: every asset it was derived from, directly or
+// through other derived assets, and every asset derived from it.
+func (s *SmartContract) GetAssetLineage(ctx contractapi.TransactionContextInterface, assetID string) (*AssetLineage, error) {
+	exists, err := s.AssetExists(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("the asset %s does not exist", assetID)
+	}
+	upstream, err := s.walkLineage(ctx, assetID, true)
+	if err != nil {
+		return nil, err
+	}
+	downstream, err := s.walkLineage(ctx, assetID, false)
+	if err != nil {
+		return nil, err
+	}
+	return &AssetLineage{AssetID: assetID, Upstream: upstream, Downstream: downstream}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDatasetName = "regionalAverage"
+
+func TestUploadDerivedDataAsAsset(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.SetResalePolicy(transactionContext, testDeviceName, testDataDate, ResalePolicyRoyalty, 10))
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	sourceID := CreateAssetID(testDeviceName, testDataDate)
+	derivedID := CreateAssetID(testDatasetName, testDataDate)
+
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `["`+sourceID+`"]`), "neither owns nor is licensed")
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `[]`))
+	assert.Error(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `["data_unknown_02-02-2000"]`))
+	assert.Error(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `["`+sourceID+`","`+sourceID+`"]`))
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `["`+sourceID+`"]`)
+	}))
+
+	derived, err := assetTransferCC.GetAssetByID(transactionContext, testDatasetName+"_"+testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, myOrg2Msp, derived.OriginalProducerOrg)
+	assert.Equal(t, []string{sourceID}, derived.DerivedFrom)
+	require.Len(t, derived.InheritedRestrictions, 1)
+	assert.Equal(t, InheritedRestriction{ProducerOrg: myOrg1Msp, ResalePolicy: ResalePolicyRoyalty, RoyaltyPercent: 10, SourceAssetIDs: []string{sourceID}}, *derived.InheritedRestrictions[0])
+
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, "03-02-2000", testMetadata, `["`+derivedID+`"]`)
+	}))
+	derivedTwice, err := assetTransferCC.GetAssetByID(transactionContext, testDatasetName+"_03-02-2000")
+	require.NoError(t, err)
+	require.Len(t, derivedTwice.InheritedRestrictions, 1, "restrictions carry over through derived sources")
+	assert.Equal(t, []string{derivedID}, derivedTwice.InheritedRestrictions[0].SourceAssetIDs)
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDatasetName, testDataDate, "200", "", testUsageTerms))
+	ledger.as(myOrg2Msp)
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDatasetName, testDataDate, "200")
+	}))
+	ledger.as(myOrg1Msp)
+	royalties, err := assetTransferCC.GetRoyaltiesForMyOrg(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, royalties, 1, "the uploader's first sale of the derived asset owes the source producer a royalty")
+	assert.Equal(t, testDatasetName, royalties[0].DeviceName)
+	assert.Equal(t, myOrg2Msp, royalties[0].SellerOrg)
+	assert.Equal(t, int64(20), royalties[0].Amount)
+}
+
+func TestDerivedAssetInheritsLicenceTerms(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	sourceID := CreateAssetID(testDeviceName, testDataDate)
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", testUsageTerms))
+	assert.Error(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `["`+sourceID+`"]`), "the licence is not granted yet")
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.GrantLicence(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `["`+sourceID+`"]`))
+
+	derived, err := assetTransferCC.GetAssetByID(transactionContext, testDatasetName+"_"+testDataDate)
+	require.NoError(t, err)
+	require.Len(t, derived.InheritedRestrictions, 1)
+	assert.Equal(t, ResalePolicyNone, derived.InheritedRestrictions[0].ResalePolicy, "the licence terms forbid resale")
+
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDatasetName, testDataDate, "200", "", testUsageTerms), "does not allow resale")
+}
+
+func TestGetAssetLineage(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000", testMetadata))
+	first, second := CreateAssetID(testDeviceName, testDataDate), CreateAssetID(testDeviceName, "03-02-2000")
+	derived, derivedTwice := CreateAssetID(testDatasetName, testDataDate), CreateAssetID(testDatasetName+"Weekly", testDataDate)
+	require.NoError(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName, testCID, testDataDate, testMetadata, `["`+first+`","`+second+`"]`))
+	require.NoError(t, assetTransferCC.UploadDerivedDataAsAsset(transactionContext, testDatasetName+"Weekly", testCID, testDataDate, testMetadata, `["`+derived+`","`+first+`"]`))
+
+	lineage, err := assetTransferCC.GetAssetLineage(transactionContext, derivedTwice)
+	require.NoError(t, err)
+	assert.Len(t, lineage.Upstream, 4)
+	assert.Empty(t, lineage.Downstream)
+
+	lineage, err = assetTransferCC.GetAssetLineage(transactionContext, first)
+	require.NoError(t, err)
+	assert.Empty(t, lineage.Upstream)
+	require.Len(t, lineage.Downstream, 3)
+	downstream := []string{}
+	for _, edge := range lineage.Downstream {
+		downstream = append(downstream, edge.DerivedAssetID)
+	}
+	assert.ElementsMatch(t, []string{derived, derivedTwice, derivedTwice}, downstream)
+
+	lineage, err = assetTransferCC.GetAssetLineage(transactionContext, derived)
+	require.NoError(t, err)
+	assert.Len(t, lineage.Upstream, 2)
+	require.Len(t, lineage.Downstream, 1)
+	assert.Equal(t, derivedTwice, lineage.Downstream[0].DerivedAssetID)
+
+	_, err = assetTransferCC.GetAssetLineage(transactionContext, "data_unknown_02-02-2000")
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Leaves and internal nodes are hashed with different prefixes, as in RFC 6962, so that an internal node can't
+// be passed off as a leaf with a shorter proof.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// merkleLeaf hashes data into a leaf of a Merkle tree.
+func merkleLeaf(data []byte) []byte {
+	leaf := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return leaf[:]
+}
+
+// merkleParent hashes two sibling nodes of a Merkle tree.
+func merkleParent(left []byte, right []byte) []byte {
+	parent := sha256.Sum256(append(append([]byte{merkleNodePrefix}, left...), right...))
+	return parent[:]
+}
+
+// merkleRoot returns the root of the Merkle tree over leaves, each hashed with merkleLeaf. Each level pairs neighbouring nodes, and the
+// last node of a level with an odd number of nodes is carried up unchanged.
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	level := leaves
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleParent(level[i], level[i+1]))
+			}
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// verifyMerkleProof reports whether leaf is the leaf at index of a Merkle tree with leafCount leaves and root.
+func verifyMerkleProof(leaf []byte, index int64, leafCount int64, proof [][]byte, root []byte) bool {
+	if index < 0 || index >= leafCount {
+		return false
+	}
+	node := leaf
+	width := leafCount
+	for width > 1 {
+		sibling := index ^ 1
+		if sibling < width {
+			if len(proof) == 0 {
+				return false
+			}
+			if index%2 == 0 {
+				node = merkleParent(node, proof[0])
+			} else {
+				node = merkleParent(proof[0], node)
+			}
+			proof = proof[1:]
+		}
+		index /= 2
+		width = (width + 1) / 2
+	}
+	return len(proof) == 0 && bytes.Equal(node, root)
+}
+
+// parseHexHashes decodes a JSON array of hex encoded sha2-256 hashes.
+func parseHexHashes(hashes string) ([][]byte, error) {
+	var encoded []string
+	err := json.Unmarshal([]byte(hashes), &encoded)
+	if err != nil {
+		return nil, fmt.Errorf("hashes are not a valid JSON array: %v", err)
+	}
+	decoded := make([][]byte, len(encoded))
+	for i, hash := range encoded {
+		decoded[i], err = parseHexHash(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decoded, nil
+}
+
+// parseHexHash decodes a hex encoded sha2-256 hash.
+func parseHexHash(hash string) ([]byte, error) {
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != sha256.Size {
+		return nil, fmt.Errorf("%q is not a hex encoded sha2-256 hash", hash)
+	}
+	return decoded, nil
+}
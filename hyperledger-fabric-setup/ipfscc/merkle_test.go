@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// merkleProof returns the sibling hashes needed to recompute the root from the leaf at index.
+func merkleProof(leaves [][]byte, index int) [][]byte {
+	var proof [][]byte
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleParent(level[i], level[i+1]))
+			}
+		}
+		level = next
+		index /= 2
+	}
+	return proof
+}
+
+// merkleLeaves hashes each of data into a leaf.
+func merkleLeaves(data [][]byte) [][]byte {
+	leaves := make([][]byte, len(data))
+	for i, item := range data {
+		leaves[i] = merkleLeaf(item)
+	}
+	return leaves
+}
+
+func testBlockHashes(count int) [][]byte {
+	hashes := make([][]byte, count)
+	for i := range hashes {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("block %d", i)))
+		hashes[i] = hash[:]
+	}
+	return hashes
+}
+
+func TestVerifyMerkleProof(t *testing.T) {
+	for _, count := range []int{1, 2, 5, 8} {
+		leaves := merkleLeaves(testBlockHashes(count))
+		root := merkleRoot(leaves)
+		for index := range leaves {
+			proof := merkleProof(leaves, index)
+			assert.True(t, verifyMerkleProof(leaves[index], int64(index), int64(count), proof, root), "leaf %d of %d", index, count)
+			if count > 1 {
+				assert.False(t, verifyMerkleProof(leaves[(index+1)%count], int64(index), int64(count), proof, root), "wrong leaf %d of %d", index, count)
+			}
+		}
+	}
+	leaves := merkleLeaves(testBlockHashes(4))
+	assert.False(t, verifyMerkleProof(leaves[0], 4, 4, nil, merkleRoot(leaves)), "index out of range")
+}
+
+func TestMerkleProofRejectsInternalNodeAsLeaf(t *testing.T) {
+	leaves := merkleLeaves(testBlockHashes(4))
+	root := merkleRoot(leaves)
+	// Without domain separation, the two children of an internal node hash into it, so they could be passed off
+	// as the data of one leaf of a tree half the size.
+	data := append(append([]byte{}, leaves[0]...), leaves[1]...)
+	assert.NotEqual(t, merkleParent(leaves[0], leaves[1]), merkleLeaf(data))
+	assert.False(t, verifyMerkleProof(merkleLeaf(data), 0, 2, [][]byte{merkleParent(leaves[2], leaves[3])}, root))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// AssetMetadata describes the data behind an asset so buyers can judge it before buying.
+// SchemaVersion selects the metadataSchema it is validated against.
+type AssetMetadata struct {
+	SchemaVersion           int    `json:"schemaVersion"`
+	SensorType              string `json:"sensorType"`
+	Unit                    string `json:"unit"`
+	SamplingIntervalSeconds int64  `json:"samplingIntervalSeconds"`
+	ReadingCount            int64  `json:"readingCount"`
+	ByteSize                int64  `json:"byteSize"`
+	Compression             string `json:"compression"`
+	EncryptionAlgorithm     string `json:"encryptionAlgorithm"`
+	// BlockCount and BlockMerkleRoot commit to the IPFS blocks of the ciphertext from schema version 2 on.
+	// BlockMerkleRoot is the hex encoded root of the Merkle tree, see merkleRoot, whose leaves
+	// are the sha2-256 hashes of the blocks hashed with merkleLeaf.
+	BlockCount      int64  `json:"blockCount,omitempty"`
+	BlockMerkleRoot string `json:"blockMerkleRoot,omitempty"`
+	// ReadingsMerkleRoot commits to the individual readings from schema version 3 on, so that one reading can be
+	// proven without revealing the rest, see VerifyReadingInclusion. It is the hex encoded root of the Merkle
+	// tree whose leaves are the ReadingCount readings, each serialised as JSON by the gateway and hashed with merkleLeaf.
+	ReadingsMerkleRoot string `json:"readingsMerkleRoot,omitempty"`
+}
+
+// fieldRule states whether a metadata schema forbids, allows or requires an optional field.
+type fieldRule int
+
+const (
+	fieldForbidden fieldRule = iota
+	fieldOptional
+	fieldRequired
+)
+
+// metadataSchema lists the values a version of AssetMetadata accepts.
+type metadataSchema struct {
+	Compressions         []string
+	EncryptionAlgorithms []string
+	BlockMerkleRoot      fieldRule
+	ReadingsMerkleRoot   fieldRule
+}
+
+// metadataSchemas holds every metadata version the chaincode accepts. Assets keep the version they were
+// uploaded with, so versions are only added, never changed.
+var metadataSchemas = map[int]*metadataSchema{
+	1: {
+		Compressions:         []string{"none", "deflate", "gzip", "zstd"},
+		EncryptionAlgorithms: []string{"AES-256-ECB", "AES-256-CBC", "AES-256-GCM", "ChaCha20-Poly1305"},
+	},
+	2: {
+		Compressions:         []string{"none", "deflate", "gzip", "zstd"},
+		EncryptionAlgorithms: []string{"AES-256-ECB", "AES-256-CBC", "AES-256-GCM", "ChaCha20-Poly1305"},
+		BlockMerkleRoot:      fieldRequired,
+	},
+	3: {
+		Compressions:         []string{"none", "deflate", "gzip", "zstd"},
+		EncryptionAlgorithms: []string{"AES-256-ECB", "AES-256-CBC", "AES-256-GCM", "ChaCha20-Poly1305"},
+		BlockMerkleRoot:      fieldOptional,
+		ReadingsMerkleRoot:   fieldRequired,
+	},
+}
+
+// ParseAssetMetadata decodes metadata passed to the chaincode as JSON and validates it against its schema.
+func ParseAssetMetadata(metadata string) (*AssetMetadata, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(metadata)))
+	decoder.DisallowUnknownFields()
+
+	var parsed AssetMetadata
+	err := decoder.Decode(&parsed)
+	if err != nil {
+		return nil, fmt.Errorf("asset metadata is not valid JSON: %v", err)
+	}
+	err = parsed.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func (m *AssetMetadata) Validate() error {
+	schema, ok := metadataSchemas[m.SchemaVersion]
+	if !ok {
+		return fmt.Errorf("unknown asset metadata schema version %d", m.SchemaVersion)
+	}
+	if m.SensorType == "" || m.Unit == "" {
+		return fmt.Errorf("asset metadata must state a sensor type and unit")
+	}
+	if m.SamplingIntervalSeconds <= 0 {
+		return fmt.Errorf("asset metadata sampling interval must be greater than zero")
+	}
+	if m.ReadingCount < 0 || m.ByteSize < 0 {
+		return fmt.Errorf("asset metadata reading count and byte size must not be negative")
+	}
+	if !contains(schema.Compressions, m.Compression) {
+		return fmt.Errorf("compression %q is not one of %v", m.Compression, schema.Compressions)
+	}
+	if !contains(schema.EncryptionAlgorithms, m.EncryptionAlgorithm) {
+		return fmt.Errorf("encryption algorithm %q is not one of %v", m.EncryptionAlgorithm, schema.EncryptionAlgorithms)
+	}
+	hasBlockRoot := m.BlockCount != 0 || m.BlockMerkleRoot != ""
+	err := schema.BlockMerkleRoot.check(m.SchemaVersion, "block Merkle root", hasBlockRoot)
+	if err != nil {
+		return err
+	}
+	if hasBlockRoot {
+		err = validateMerkleRoot("block", m.BlockCount, m.BlockMerkleRoot)
+		if err != nil {
+			return err
+		}
+	}
+	hasReadingsRoot := m.ReadingsMerkleRoot != ""
+	err = schema.ReadingsMerkleRoot.check(m.SchemaVersion, "readings Merkle root", hasReadingsRoot)
+	if err != nil {
+		return err
+	}
+	if hasReadingsRoot {
+		return validateMerkleRoot("reading", m.ReadingCount, m.ReadingsMerkleRoot)
+	}
+	return nil
+}
+
+func (r fieldRule) check(schemaVersion int, field string, present bool) error {
+	if present && r == fieldForbidden {
+		return fmt.Errorf("asset metadata schema version %d has no %s", schemaVersion, field)
+	}
+	if !present && r == fieldRequired {
+		return fmt.Errorf("asset metadata schema version %d requires a %s", schemaVersion, field)
+	}
+	return nil
+}
+
+// validateMerkleRoot checks a Merkle root over count leaves of the kind named by leaves.
+func validateMerkleRoot(leaves string, count int64, root string) error {
+	if count <= 0 {
+		return fmt.Errorf("asset metadata %s count must be greater than zero", leaves)
+	}
+	decoded, err := hex.DecodeString(root)
+	if err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("asset metadata %s Merkle root must be a hex encoded sha2-256 hash", leaves)
+	}
+	return nil
+}
+
+// MetadataFilter selects assets by their metadata. Empty fields match any asset.
+type MetadataFilter struct {
+	SensorType                 string `json:"sensorType"`
+	Unit                       string `json:"unit"`
+	MaxSamplingIntervalSeconds int64  `json:"maxSamplingIntervalSeconds"`
+	MinReadingCount            int64  `json:"minReadingCount"`
+	Compression                string `json:"compression"`
+	EncryptionAlgorithm        string `json:"encryptionAlgorithm"`
+}
+
+// ParseMetadataFilter decodes a JSON encoded MetadataFilter. An empty string matches every asset.
+func ParseMetadataFilter(filter string) (*MetadataFilter, error) {
+	var parsed MetadataFilter
+	if filter == "" {
+		return &parsed, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(filter)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&parsed)
+	if err != nil {
+		return nil, fmt.Errorf("metadata filter is not valid JSON: %v", err)
+	}
+	return &parsed, nil
+}
+
+// Matches reports whether the metadata of asset passes the filter. Assets uploaded without metadata only
+// pass an empty filter.
+func (f *MetadataFilter) Matches(asset *DataAsset) bool {
+	if *f == (MetadataFilter{}) {
+		return true
+	}
+	m := asset.Metadata
+	if m == nil {
+		return false
+	}
+	if f.SensorType != "" && f.SensorType != m.SensorType {
+		return false
+	}
+	if f.Unit != "" && f.Unit != m.Unit {
+		return false
+	}
+	if f.MaxSamplingIntervalSeconds > 0 && m.SamplingIntervalSeconds > f.MaxSamplingIntervalSeconds {
+		return false
+	}
+	if m.ReadingCount < f.MinReadingCount {
+		return false
+	}
+	if f.Compression != "" && f.Compression != m.Compression {
+		return false
+	}
+	if f.EncryptionAlgorithm != "" && f.EncryptionAlgorithm != m.EncryptionAlgorithm {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAssetMetadata(t *testing.T) {
+	metadata, err := ParseAssetMetadata(testMetadata)
+	require.NoError(t, err)
+	assert.Equal(t, 1, metadata.SchemaVersion)
+	assert.Equal(t, "kWh", metadata.Unit)
+	assert.Equal(t, int64(1440), metadata.ReadingCount)
+
+	_, err = ParseAssetMetadata("")
+	assert.Error(t, err, "metadata is required")
+	_, err = ParseAssetMetadata(`{"schemaVersion":99,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"AES-256-GCM"}`)
+	assert.ErrorContains(t, err, "schema version")
+	_, err = ParseAssetMetadata(`{"schemaVersion":1,"unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"AES-256-GCM"}`)
+	assert.Error(t, err, "sensor type is required")
+	_, err = ParseAssetMetadata(`{"schemaVersion":1,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":0,"compression":"none","encryptionAlgorithm":"AES-256-GCM"}`)
+	assert.Error(t, err, "sampling interval must be positive")
+	_, err = ParseAssetMetadata(`{"schemaVersion":1,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"rar","encryptionAlgorithm":"AES-256-GCM"}`)
+	assert.ErrorContains(t, err, "compression")
+	_, err = ParseAssetMetadata(`{"schemaVersion":1,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"ROT13"}`)
+	assert.ErrorContains(t, err, "encryption algorithm")
+	_, err = ParseAssetMetadata(`{"schemaVersion":1,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"AES-256-GCM","colour":"red"}`)
+	assert.Error(t, err, "unknown fields are rejected")
+
+	_, err = ParseAssetMetadata(`{"schemaVersion":1,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"AES-256-GCM","blockCount":4,"blockMerkleRoot":"9d6c2be50f706953479ab9df2ce3edca90b68053c00b3004b7f0accbe1e8eedf"}`)
+	assert.Error(t, err, "version 1 has no block Merkle root")
+	metadata, err = ParseAssetMetadata(`{"schemaVersion":2,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"AES-256-GCM","blockCount":4,"blockMerkleRoot":"9d6c2be50f706953479ab9df2ce3edca90b68053c00b3004b7f0accbe1e8eedf"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), metadata.BlockCount)
+	_, err = ParseAssetMetadata(`{"schemaVersion":2,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"AES-256-GCM","blockCount":4,"blockMerkleRoot":"9d6c"}`)
+	assert.ErrorContains(t, err, "Merkle root")
+	_, err = ParseAssetMetadata(`{"schemaVersion":2,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":60,"compression":"none","encryptionAlgorithm":"AES-256-GCM"}`)
+	assert.Error(t, err, "version 2 needs the block Merkle root")
+}
+
+func TestSearchDataAssetsByMetadata(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000",
+		`{"schemaVersion":1,"sensorType":"thermometer","unit":"C","samplingIntervalSeconds":600,"readingCount":144,"byteSize":4000,"compression":"none","encryptionAlgorithm":"AES-256-GCM"}`))
+	assert.Error(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "04-02-2000", `{}`))
+
+	asset, err := assetTransferCC.GetAssetByID(transactionContext, testDeviceName+"_"+testDataDate)
+	require.NoError(t, err)
+	require.NotNil(t, asset.Metadata)
+	assert.Equal(t, "energy meter", asset.Metadata.SensorType)
+
+	assets, err := assetTransferCC.SearchDataAssets(transactionContext, 0, `{"unit":"kWh"}`)
+	require.NoError(t, err)
+	require.Len(t, assets, 1)
+	assert.Equal(t, testDataDate, assets[0].Date)
+
+	assets, err = assetTransferCC.SearchDataAssets(transactionContext, 0, `{"maxSamplingIntervalSeconds":300,"minReadingCount":1000}`)
+	require.NoError(t, err)
+	assert.Len(t, assets, 1)
+
+	assets, err = assetTransferCC.SearchDataAssets(transactionContext, 0, `{"encryptionAlgorithm":"AES-256-GCM"}`)
+	require.NoError(t, err)
+	require.Len(t, assets, 1)
+	assert.Equal(t, "03-02-2000", assets[0].Date)
+
+	assets, err = assetTransferCC.SearchDataAssets(transactionContext, 0, "")
+	require.NoError(t, err)
+	assert.Len(t, assets, 2)
+
+	_, err = assetTransferCC.SearchDataAssets(transactionContext, 0, `{"colour":"red"}`)
+	assert.Error(t, err)
+}
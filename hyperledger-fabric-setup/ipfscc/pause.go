@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ProposalKindPause       = "pause"
+	ProposalKindFreezeAsset = "freezeAsset"
+	ProposalKindFreezeOrg   = "freezeOrg"
+
+	pauseKey = "pause"
+)
+
+// EmergencyControl is the payload of pause and freeze proposals, and of the event emitted when one is applied.
+// Subject is the asset ID or MSP ID that is frozen, and is empty for the contract wide pause.
+type EmergencyControl struct {
+	Kind       string `json:"kind"`
+	Subject    string `json:"subject"`
+	Enabled    bool   `json:"enabled"`
+	ProposalID string `json:"proposalID"`
+	AppliedAt  string `json:"appliedAt"`
+}
+
+func CreateAssetFreezeID(assetID string) string {
+	return "freeze_asset_" + assetID
+}
+
+func CreateOrgFreezeID(mspid string) string {
+	return "freeze_org_" + mspid
+}
+
+func emergencyControlKey(kind string, subject string) string {
+	switch kind {
+	case ProposalKindFreezeAsset:
+		return CreateAssetFreezeID(subject)
+	case ProposalKindFreezeOrg:
+		return CreateOrgFreezeID(subject)
+	}
+	return pauseKey
+}
+
+func (s *SmartContract) isControlEnabled(ctx contractapi.TransactionContextInterface, key string) (bool, error) {
+	controlBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("error ocurred getting emergency control: %v", err)
+	}
+	return controlBytes != nil, nil
+}
+
+// checkOperational fails if the contract is paused, the calling org is frozen, or one of assetIDs is frozen.
+// Every state changing transaction calls it, except the governance transactions that lift a pause or freeze.
+func (s *SmartContract) checkOperational(ctx contractapi.TransactionContextInterface, assetIDs ...string) error {
+	paused, err := s.isControlEnabled(ctx, pauseKey)
+	if err != nil {
+		return err
+	}
+	if paused {
+		return fmt.Errorf("the contract is paused")
+	}
+
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	err = s.checkOrgNotFrozen(ctx, mspid)
+	if err != nil {
+		return err
+	}
+
+	for _, assetID := range assetIDs {
+		frozen, err := s.isControlEnabled(ctx, CreateAssetFreezeID(assetID))
+		if err != nil {
+			return err
+		}
+		if frozen {
+			return fmt.Errorf("asset %s is frozen", assetID)
+		}
+	}
+	return nil
+}
+
+func (s *SmartContract) checkOrgNotFrozen(ctx contractapi.TransactionContextInterface, mspid string) error {
+	frozen, err := s.isControlEnabled(ctx, CreateOrgFreezeID(mspid))
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return fmt.Errorf("org %s is frozen", mspid)
+	}
+	return nil
+}
+
+func (s *SmartContract) proposeEmergencyControl(ctx contractapi.TransactionContextInterface, kind string, subject string, enabled bool) (string, error) {
+	payload, err := json.Marshal(EmergencyControl{Kind: kind, Subject: subject, Enabled: enabled})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal emergency control to JSON: %v", err)
+	}
+	return s.createProposal(ctx, kind, string(payload))
+}
+
+// ProposeContractPause proposes pausing, or resuming, every state changing transaction of the contract.
+func (s *SmartContract) ProposeContractPause(ctx contractapi.TransactionContextInterface, paused bool) (string, error) {
+	return s.proposeEmergencyControl(ctx, ProposalKindPause, "", paused)
+}
+
+// ProposeAssetFreeze proposes freezing, or unfreezing, the asset of deviceName on date.
+func (s *SmartContract) ProposeAssetFreeze(ctx contractapi.TransactionContextInterface, deviceName string, date string, frozen bool) (string, error) {
+	return s.proposeEmergencyControl(ctx, ProposalKindFreezeAsset, CreateAssetID(deviceName, date), frozen)
+}
+
+// ProposeOrgFreeze proposes freezing, or unfreezing, every state changing transaction submitted by mspid.
+func (s *SmartContract) ProposeOrgFreeze(ctx contractapi.TransactionContextInterface, mspid string, frozen bool) (string, error) {
+	if mspid == "" {
+		return "", fmt.Errorf("an org to freeze is required")
+	}
+	return s.proposeEmergencyControl(ctx, ProposalKindFreezeOrg, mspid, frozen)
+}
+
+// ApplyEmergencyControl applies a pause or freeze proposal once a majority of the member orgs approved it,
+// and emits an event with the change.
+func (s *SmartContract) ApplyEmergencyControl(ctx contractapi.TransactionContextInterface, proposalID string) error {
+	proposal, err := s.GetProposal(ctx, proposalID)
+	if err != nil {
+		return err
+	}
+	switch proposal.Kind {
+	case ProposalKindPause, ProposalKindFreezeAsset, ProposalKindFreezeOrg:
+	default:
+		return fmt.Errorf("proposal %s is not a pause or freeze proposal", proposalID)
+	}
+	proposal, err = s.approvedProposal(ctx, proposalID, proposal.Kind)
+	if err != nil {
+		return err
+	}
+
+	var control EmergencyControl
+	err = json.Unmarshal([]byte(proposal.Payload), &control)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal emergency control: %v", err)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	control.ProposalID = proposalID
+	control.AppliedAt = now.UTC().Format(time.RFC3339)
+
+	controlBytes, err := json.Marshal(control)
+	if err != nil {
+		return fmt.Errorf("failed to marshal emergency control to JSON: %v", err)
+	}
+	key := emergencyControlKey(control.Kind, control.Subject)
+	if control.Enabled {
+		err = ctx.GetStub().PutState(key, controlBytes)
+	} else {
+		err = ctx.GetStub().DelState(key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update emergency control: %v", err)
+	}
+
+	proposal.Status = ProposalStatusApplied
+	err = s.putProposal(ctx, proposal)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent("emergencyControl_"+control.Kind, controlBytes)
+}
+
+// IsContractPaused reports whether state changing transactions are paused.
+func (s *SmartContract) IsContractPaused(ctx contractapi.TransactionContextInterface) (bool, error) {
+	return s.isControlEnabled(ctx, pauseKey)
+}
+
+// IsAssetFrozen reports whether the asset of deviceName on date is frozen.
+func (s *SmartContract) IsAssetFrozen(ctx contractapi.TransactionContextInterface, deviceName string, date string) (bool, error) {
+	return s.isControlEnabled(ctx, CreateAssetFreezeID(CreateAssetID(deviceName, date)))
+}
+
+// IsOrgFrozen reports whether transactions submitted by mspid are frozen.
+func (s *SmartContract) IsOrgFrozen(ctx contractapi.TransactionContextInterface, mspid string) (bool, error) {
+	return s.isControlEnabled(ctx, CreateOrgFreezeID(mspid))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// applyTestEmergencyControl lets a majority of the test member orgs approve and apply a pause or freeze proposal.
+func applyTestEmergencyControl(t *testing.T, assetTransferCC *SmartContract, ledger *mockLedger, propose func() (string, error)) {
+	ledger.as(myOrg1Msp)
+	proposalID, err := propose()
+	require.NoError(t, err)
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.VoteOnProposal(ledger.ctx, proposalID, true))
+	require.NoError(t, assetTransferCC.ApplyEmergencyControl(ledger.ctx, proposalID))
+}
+
+func TestContractPause(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) {})
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.setTxID("pauseTx")
+	proposalID, err := assetTransferCC.ProposeContractPause(transactionContext, true)
+	require.NoError(t, err)
+	assert.Error(t, assetTransferCC.ApplyEmergencyControl(transactionContext, proposalID), "one of three members is not a majority")
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, true))
+	require.NoError(t, assetTransferCC.ApplyEmergencyControl(transactionContext, proposalID))
+
+	paused, err := assetTransferCC.IsContractPaused(transactionContext)
+	require.NoError(t, err)
+	assert.True(t, paused)
+	assert.Equal(t, "emergencyControl_"+ProposalKindPause, ledger.lastEvent)
+	var control EmergencyControl
+	require.NoError(t, json.Unmarshal(ledger.events[ledger.lastEvent], &control))
+	assert.True(t, control.Enabled)
+	assert.Equal(t, proposalID, control.ProposalID)
+
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms), "paused")
+	ledger.as(myOrg1Msp)
+	assert.ErrorContains(t, assetTransferCC.UploadDataAsAsset(transactionContext, "otherDevice", testCID, testDataDate, testMetadata), "paused")
+	_, err = assetTransferCC.GetAssetOwner(transactionContext, testDeviceName, testDataDate)
+	assert.NoError(t, err, "reads keep working while paused")
+
+	ledger.setTxID("resumeTx")
+	applyTestEmergencyControl(t, &assetTransferCC, ledger, func() (string, error) {
+		return assetTransferCC.ProposeContractPause(transactionContext, false)
+	})
+	paused, err = assetTransferCC.IsContractPaused(transactionContext)
+	require.NoError(t, err)
+	assert.False(t, paused)
+	assert.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+}
+
+func TestAssetFreeze(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) {})
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+
+	ledger.setTxID("freezeTx")
+	applyTestEmergencyControl(t, &assetTransferCC, ledger, func() (string, error) {
+		return assetTransferCC.ProposeAssetFreeze(transactionContext, testDeviceName, testDataDate, true)
+	})
+	frozen, err := assetTransferCC.IsAssetFrozen(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.True(t, frozen)
+
+	ledger.as(myOrg1Msp)
+	assert.ErrorContains(t, assetTransferCC.AcceptBid(transactionContext, myOrg2Msp, testDeviceName, testDataDate, "100"), "frozen")
+	assert.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, "otherDevice", testCID, testDataDate, testMetadata), "other assets are not frozen")
+
+	ledger.setTxID("unfreezeTx")
+	applyTestEmergencyControl(t, &assetTransferCC, ledger, func() (string, error) {
+		return assetTransferCC.ProposeAssetFreeze(transactionContext, testDeviceName, testDataDate, false)
+	})
+	ledger.as(myOrg1Msp)
+	assert.NoError(t, assetTransferCC.AcceptBid(transactionContext, myOrg2Msp, testDeviceName, testDataDate, "100"))
+}
+
+func TestOrgFreeze(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) {})
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+
+	_, err := assetTransferCC.ProposeOrgFreeze(transactionContext, "", true)
+	assert.Error(t, err)
+	ledger.setTxID("freezeTx")
+	applyTestEmergencyControl(t, &assetTransferCC, ledger, func() (string, error) {
+		return assetTransferCC.ProposeOrgFreeze(transactionContext, myOrg3Msp, true)
+	})
+	frozen, err := assetTransferCC.IsOrgFrozen(transactionContext, myOrg3Msp)
+	require.NoError(t, err)
+	assert.True(t, frozen)
+
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "200", "", testUsageTerms), "frozen")
+	ledger.as(myOrg1Msp)
+	assert.ErrorContains(t, assetTransferCC.AcceptBid(transactionContext, myOrg3Msp, testDeviceName, testDataDate, "100"), "frozen", "a frozen org cannot receive assets")
+	assert.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+
+	ledger.as(myOrg3Msp)
+	_, err = assetTransferCC.ProposeOrgFreeze(transactionContext, myOrg3Msp, false)
+	assert.ErrorContains(t, err, "frozen", "a frozen org cannot propose")
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("unfreezeTx")
+	proposalID, err := assetTransferCC.ProposeOrgFreeze(transactionContext, myOrg3Msp, false)
+	require.NoError(t, err)
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, true), "frozen", "a frozen org cannot vote")
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.VoteOnProposal(transactionContext, proposalID, true))
+	require.NoError(t, assetTransferCC.ApplyEmergencyControl(transactionContext, proposalID))
+	frozen, err = assetTransferCC.IsOrgFrozen(transactionContext, myOrg3Msp)
+	require.NoError(t, err)
+	assert.False(t, frozen)
+}
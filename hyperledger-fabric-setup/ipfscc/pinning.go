@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PinAttestation is posted by a storage provider org to state that it pins a CID on the IPFS cluster peers
+// in ClusterPeerIDs. CID is the normalised CIDv1, so attestations for the CIDv0 and CIDv1 forms match.
+type PinAttestation struct {
+	CID               string   `json:"cid"`
+	StorageOrg        string   `json:"storageOrg"`
+	ReplicationFactor int      `json:"replicationFactor"`
+	ClusterPeerIDs    []string `json:"clusterPeerIDs"`
+	AttestedAt        string   `json:"attestedAt"`
+}
+
+// PinStatus is the latest pin attestation of an asset, nil if the CID of the asset was never attested.
+type PinStatus struct {
+	DeviceName        string          `json:"deviceName"`
+	Date              string          `json:"date"`
+	CID               string          `json:"cid"`
+	LatestAttestation *PinAttestation `json:"latestAttestation"`
+}
+
+func CreatePinAttestationID(cid string, storageOrg string) string {
+	return "pin_" + cid + "_" + storageOrg
+}
+
+// getPinAttestations returns every attestation posted for the CIDv1 cid.
+func (s *SmartContract) getPinAttestations(ctx contractapi.TransactionContextInterface, cid string) ([]*PinAttestation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(CreatePinAttestationID(cid, ""), CreatePinAttestationID(cid, "~"))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var attestations []*PinAttestation
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var attestation PinAttestation
+		err = json.Unmarshal(queryResponse.Value, &attestation)
+		if err != nil {
+			return nil, err
+		}
+		attestations = append(attestations, &attestation)
+	}
+	return attestations, nil
+}
+
+// assetCIDv1 returns the normalised CIDv1 of an asset, parsing IPFS_CID for assets uploaded before CIDs were
+// validated. It is empty if IPFS_CID is not a valid CID.
+func assetCIDv1(asset *DataAsset) string {
+	if asset.ContentID != nil {
+		return asset.ContentID.CIDv1
+	}
+	contentID, err := ParseCID(asset.IPFS_CID)
+	if err != nil {
+		return ""
+	}
+	return contentID.CIDv1
+}
+
+// PostPinAttestation lets a storage provider org from the market config attest that it pins cid with
+// replicationFactor copies on the IPFS cluster peers in clusterPeerIDs, a JSON array of peer IDs.
+// A later attestation by the same org replaces its earlier one.
+func (s *SmartContract) PostPinAttestation(ctx contractapi.TransactionContextInterface, cid string, replicationFactor int, clusterPeerIDs string) error {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !contains(config.StorageProviderOrgs, mspid) {
+		return fmt.Errorf("org %s is not a storage provider", mspid)
+	}
+	contentID, err := ParseCID(cid)
+	if err != nil {
+		return err
+	}
+
+	var peerIDs []string
+	err = json.Unmarshal([]byte(clusterPeerIDs), &peerIDs)
+	if err != nil {
+		return fmt.Errorf("cluster peer IDs are not a valid JSON array: %v", err)
+	}
+	if replicationFactor < 1 || replicationFactor > len(peerIDs) {
+		return fmt.Errorf("replication factor must be between 1 and the %d cluster peers listed, got %d", len(peerIDs), replicationFactor)
+	}
+	for _, peerID := range peerIDs {
+		if peerID == "" {
+			return fmt.Errorf("cluster peer IDs must not be empty")
+		}
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	attestation := PinAttestation{
+		CID:               contentID.CIDv1,
+		StorageOrg:        mspid,
+		ReplicationFactor: replicationFactor,
+		ClusterPeerIDs:    peerIDs,
+		AttestedAt:        now.UTC().Format(time.RFC3339),
+	}
+	attestationBytes, err := json.Marshal(attestation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pin attestation to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreatePinAttestationID(attestation.CID, mspid), attestationBytes)
+}
+
+// GetPinAttestationsForAsset lets buyers check that the data of an asset is still pinned before they bid.
+func (s *SmartContract) GetPinAttestationsForAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]*PinAttestation, error) {
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return nil, err
+	}
+	cid := assetCIDv1(asset)
+	if cid == "" {
+		return nil, fmt.Errorf("asset %s does not have a valid CID", CreateAssetID(deviceName, date))
+	}
+	return s.getPinAttestations(ctx, cid)
+}
+
+// GetStalePinnedAssets returns the assets whose latest pin attestation is more than maxAgeSeconds old, or
+// that were never attested.
+func (s *SmartContract) GetStalePinnedAssets(ctx contractapi.TransactionContextInterface, maxAgeSeconds int64) ([]*PinStatus, error) {
+	if maxAgeSeconds < 0 {
+		return nil, fmt.Errorf("maximum age must not be negative")
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	threshold := now.Add(-time.Duration(maxAgeSeconds) * time.Second)
+
+	assets, err := s.GetAllDataAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var stale []*PinStatus
+	for _, asset := range assets {
+		status := &PinStatus{DeviceName: asset.AssetName, Date: asset.Date, CID: assetCIDv1(asset)}
+		if status.CID != "" {
+			attestations, err := s.getPinAttestations(ctx, status.CID)
+			if err != nil {
+				return nil, err
+			}
+			// RFC3339 timestamps in UTC sort the same as the times they encode.
+			for _, attestation := range attestations {
+				if status.LatestAttestation == nil || attestation.AttestedAt > status.LatestAttestation.AttestedAt {
+					status.LatestAttestation = attestation
+				}
+			}
+		}
+		if status.LatestAttestation != nil {
+			attestedAt, err := time.Parse(time.RFC3339, status.LatestAttestation.AttestedAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pin attestation time: %v", err)
+			}
+			if !attestedAt.Before(threshold) {
+				continue
+			}
+		}
+		stale = append(stale, status)
+	}
+	return stale, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testClusterPeerIDs = `["12D3KooWBQ8hM5cTw9bRoCp2bEqkpBYG8XXcKdWxRzdVAU9Ffgmv","12D3KooWDfnYfk1EShDRAc8r8Yy3a6xHE1pVQgFFU8eXFxzvvtne"]`
+
+func TestPinAttestations(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) { c.StorageProviderOrgs = []string{myOrg3Msp} })
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCIDv1, "03-02-2000", testMetadata))
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, "otherDevice", "f01551220"+"9d6c2be50f706953479ab9df2ce3edca90b68053c00b3004b7f0accbe1e8eedf", testDataDate, testMetadata))
+
+	assert.Error(t, assetTransferCC.PostPinAttestation(transactionContext, testCID, 2, testClusterPeerIDs), "only storage providers attest")
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.PostPinAttestation(transactionContext, "42421337", 2, testClusterPeerIDs))
+	assert.Error(t, assetTransferCC.PostPinAttestation(transactionContext, testCID, 3, testClusterPeerIDs), "more copies than peers")
+	assert.Error(t, assetTransferCC.PostPinAttestation(transactionContext, testCID, 0, testClusterPeerIDs))
+	assert.Error(t, assetTransferCC.PostPinAttestation(transactionContext, testCID, 1, "peer"))
+	require.NoError(t, assetTransferCC.PostPinAttestation(transactionContext, testCID, 2, testClusterPeerIDs))
+
+	attestations, err := assetTransferCC.GetPinAttestationsForAsset(transactionContext, testDeviceName, "03-02-2000")
+	require.NoError(t, err)
+	require.Len(t, attestations, 1, "the CIDv0 and CIDv1 forms of a CID share attestations")
+	assert.Equal(t, testCIDv1, attestations[0].CID)
+	assert.Equal(t, 2, attestations[0].ReplicationFactor)
+	assert.Len(t, attestations[0].ClusterPeerIDs, 2)
+
+	stale, err := assetTransferCC.GetStalePinnedAssets(transactionContext, 3600)
+	require.NoError(t, err)
+	require.Len(t, stale, 1, "the raw CID was never attested")
+	assert.Equal(t, "otherDevice", stale[0].DeviceName)
+	assert.Nil(t, stale[0].LatestAttestation)
+
+	ledger.setTime(time.Date(2000, time.February, 2, 14, 0, 0, 0, time.UTC))
+	stale, err = assetTransferCC.GetStalePinnedAssets(transactionContext, 3600)
+	require.NoError(t, err)
+	require.Len(t, stale, 3)
+	assert.NotNil(t, stale[2].LatestAttestation)
+
+	require.NoError(t, assetTransferCC.PostPinAttestation(transactionContext, testCIDv1, 1, testClusterPeerIDs))
+	stale, err = assetTransferCC.GetStalePinnedAssets(transactionContext, 3600)
+	require.NoError(t, err)
+	assert.Len(t, stale, 1)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// QualityAttestation is posted by a validator org after checking the data behind an asset. Signature is the
+// base64 encoded ECDSA signature of SignedPayload by the certificate the validator submits the attestation
+// with, so the attestation can be verified outside of the channel as well.
+type QualityAttestation struct {
+	DeviceName          string `json:"deviceName"`
+	Date                string `json:"date"`
+	ValidatorOrg        string `json:"validatorOrg"`
+	CompletenessPercent int    `json:"completenessPercent"`
+	SampleCount         int64  `json:"sampleCount"`
+	SensorGapCount      int64  `json:"sensorGapCount"`
+	ChecksumVerified    bool   `json:"checksumVerified"`
+	Signature           string `json:"signature"`
+	AttestedAt          string `json:"attestedAt"`
+}
+
+func CreateQualityAttestationID(deviceName string, date string, validatorOrg string) string {
+	return "quality_" + deviceName + "_" + date + "_" + validatorOrg
+}
+
+// SignedPayload returns the bytes the validator signs.
+func (a *QualityAttestation) SignedPayload() []byte {
+	return []byte(fmt.Sprintf("%s|%s|%d|%d|%d|%t", a.DeviceName, a.Date, a.CompletenessPercent, a.SampleCount, a.SensorGapCount, a.ChecksumVerified))
+}
+
+// Score rates the attested data from 0 to 100. Data whose checksum did not verify cannot be trusted and
+// scores 0, otherwise the score is the completeness percentage.
+func (a *QualityAttestation) Score() int {
+	if !a.ChecksumVerified {
+		return 0
+	}
+	return a.CompletenessPercent
+}
+
+func (a *QualityAttestation) Validate() error {
+	if a.CompletenessPercent < 0 || a.CompletenessPercent > 100 {
+		return fmt.Errorf("completeness must be between 0 and 100 percent, got %d", a.CompletenessPercent)
+	}
+	if a.SampleCount < 0 || a.SensorGapCount < 0 {
+		return fmt.Errorf("sample and sensor gap counts must not be negative")
+	}
+	return nil
+}
+
+// verifyAttestationSignature checks the signature of the attestation against the certificate of the caller.
+func verifyAttestationSignature(ctx contractapi.TransactionContextInterface, attestation *QualityAttestation) error {
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting client certificate: %v", err)
+	}
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("quality attestations must be signed with an ECDSA key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(attestation.Signature)
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64: %v", err)
+	}
+	digest := sha256.Sum256(attestation.SignedPayload())
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		return fmt.Errorf("signature does not match the quality attestation")
+	}
+	return nil
+}
+
+// GetQualityAttestations returns the attestations posted for the asset of deviceName on date.
+func (s *SmartContract) GetQualityAttestations(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]*QualityAttestation, error) {
+	startKey := CreateQualityAttestationID(deviceName, date, "")
+	endKey := CreateQualityAttestationID(deviceName, date, "~")
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var attestations []*QualityAttestation
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var attestation QualityAttestation
+		err = json.Unmarshal(queryResponse.Value, &attestation)
+		if err != nil {
+			return nil, err
+		}
+		// Other devices whose name starts with deviceName and an underscore fall in the same range.
+		if attestation.DeviceName == deviceName && attestation.Date == date {
+			attestations = append(attestations, &attestation)
+		}
+	}
+	return attestations, nil
+}
+
+// addQualityAttestations fills in the quality attestations of assets, reading all attestations in one range.
+// Nothing is read if none of the assets has been attested.
+func (s *SmartContract) addQualityAttestations(ctx contractapi.TransactionContextInterface, assets []*DataAsset) error {
+	attested := false
+	for _, asset := range assets {
+		attested = attested || asset.QualityAttestationCount > 0
+	}
+	if !attested {
+		return nil
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByRange("quality_", "quality_~")
+	if err != nil {
+		return fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	attestationsByAsset := map[string][]*QualityAttestation{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		var attestation QualityAttestation
+		err = json.Unmarshal(queryResponse.Value, &attestation)
+		if err != nil {
+			return err
+		}
+		assetID := CreateAssetID(attestation.DeviceName, attestation.Date)
+		attestationsByAsset[assetID] = append(attestationsByAsset[assetID], &attestation)
+	}
+	for _, asset := range assets {
+		asset.QualityAttestations = attestationsByAsset[CreateAssetID(asset.AssetName, asset.Date)]
+	}
+	return nil
+}
+
+// PostQualityAttestation lets a validator org from the market config attest to the quality of an asset.
+// attestation is a JSON encoded QualityAttestation, and a validator's later attestation replaces its earlier one.
+// The quality score of the asset is the average score of its attestations.
+func (s *SmartContract) PostQualityAttestation(ctx contractapi.TransactionContextInterface, deviceName string, date string, attestation string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !contains(config.ValidatorOrgs, mspid) {
+		return fmt.Errorf("org %s is not a quality validator", mspid)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if asset.OwnerOrg == mspid || asset.OriginalProducerOrg == mspid {
+		return fmt.Errorf("org %s cannot attest to the quality of its own asset", mspid)
+	}
+
+	var posted QualityAttestation
+	err = json.Unmarshal([]byte(attestation), &posted)
+	if err != nil {
+		return fmt.Errorf("quality attestation is not valid JSON: %v", err)
+	}
+	posted.DeviceName = deviceName
+	posted.Date = date
+	posted.ValidatorOrg = mspid
+	err = posted.Validate()
+	if err != nil {
+		return err
+	}
+	err = verifyAttestationSignature(ctx, &posted)
+	if err != nil {
+		return err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	posted.AttestedAt = now.UTC().Format(time.RFC3339)
+
+	// The range query does not see the attestation written by this transaction, so it is added separately.
+	attestations, err := s.GetQualityAttestations(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	total, count := posted.Score(), 1
+	for _, previous := range attestations {
+		if previous.ValidatorOrg != mspid {
+			total += previous.Score()
+			count++
+		}
+	}
+
+	attestationBytes, err := json.Marshal(posted)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quality attestation to JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(CreateQualityAttestationID(deviceName, date, mspid), attestationBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put quality attestation: %v", err)
+	}
+
+	asset.QualityScore = total / count
+	asset.QualityAttestationCount = count
+	return s.putDataAsset(ctx, asset)
+}
+
+// SearchDataAssets returns every asset with a quality score of at least minQualityScore whose metadata
+// matches metadataFilter, a JSON encoded MetadataFilter that may be empty.
+func (s *SmartContract) SearchDataAssets(ctx contractapi.TransactionContextInterface, minQualityScore int, metadataFilter string) ([]*DataAsset, error) {
+	filter, err := ParseMetadataFilter(metadataFilter)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := s.GetAllDataAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matching []*DataAsset
+	for _, asset := range assets {
+		if asset.QualityScore >= minQualityScore && filter.Matches(asset) {
+			matching = append(matching, asset)
+		}
+	}
+	return matching, nil
+}
+
+// GetBidsForMyOrgWithMinQuality returns the bids for the calling org's assets with a quality score of at
+// least minQualityScore.
+func (s *SmartContract) GetBidsForMyOrgWithMinQuality(ctx contractapi.TransactionContextInterface, minQualityScore int) ([]*DataBid, error) {
+	bids, err := s.GetBidsForMyOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matching []*DataBid
+	for _, bid := range bids {
+		asset, err := s.getDataAsset(ctx, bid.DeviceName, bid.Date)
+		if err != nil {
+			return nil, err
+		}
+		if asset.QualityScore >= minQualityScore {
+			matching = append(matching, bid)
+		}
+	}
+	return matching, nil
+}
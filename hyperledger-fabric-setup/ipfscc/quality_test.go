@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// prepValidatorIdentity gives the mocked client a certificate and returns the key to sign attestations with.
+func prepValidatorIdentity(t *testing.T, ledger *mockLedger) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "validator"},
+		NotBefore:    time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certBytes)
+	require.NoError(t, err)
+	ledger.clientID.GetX509CertificateReturns(cert, nil)
+	return key
+}
+
+func signedTestAttestation(t *testing.T, key *ecdsa.PrivateKey, attestation QualityAttestation) string {
+	attestation.DeviceName = testDeviceName
+	attestation.Date = testDataDate
+	digest := sha256.Sum256(attestation.SignedPayload())
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	attestation.Signature = base64.StdEncoding.EncodeToString(signature)
+	attestationBytes, err := json.Marshal(attestation)
+	require.NoError(t, err)
+	return string(attestationBytes)
+}
+
+func TestPostQualityAttestation(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) { c.ValidatorOrgs = []string{myOrg1Msp, myOrg2Msp, myOrg3Msp} })
+	key := prepValidatorIdentity(t, ledger)
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	complete := signedTestAttestation(t, key, QualityAttestation{CompletenessPercent: 90, SampleCount: 1440, SensorGapCount: 2, ChecksumVerified: true})
+	assert.Error(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, complete), "producers do not attest their own assets")
+	ledger.as(myOrg4Msp)
+	assert.Error(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, complete), "only validators attest")
+
+	ledger.as(myOrg2Msp)
+	tampered := signedTestAttestation(t, key, QualityAttestation{CompletenessPercent: 50, ChecksumVerified: true})
+	tampered = tampered[:len(tampered)-1] + `,"completenessPercent":100}`
+	assert.ErrorContains(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, tampered), "signature")
+	assert.Error(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, signedTestAttestation(t, key, QualityAttestation{CompletenessPercent: 101})))
+	require.NoError(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, complete))
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, signedTestAttestation(t, key, QualityAttestation{CompletenessPercent: 70, ChecksumVerified: true})))
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, signedTestAttestation(t, key, QualityAttestation{CompletenessPercent: 80, ChecksumVerified: true})), "a validator can update its attestation")
+
+	attestations, err := assetTransferCC.GetQualityAttestations(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	require.Len(t, attestations, 2)
+	assert.Equal(t, 80, attestations[0].CompletenessPercent)
+
+	asset, err := assetTransferCC.GetAssetByID(transactionContext, testDeviceName+"_"+testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, 75, asset.QualityScore)
+	assert.Equal(t, 2, asset.QualityAttestationCount)
+	assert.Equal(t, attestations, asset.QualityAttestations)
+
+	otherAssets, err := assetTransferCC.GetOtherOrgsDataAssets(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, otherAssets, 1)
+	assert.Equal(t, 75, otherAssets[0].QualityScore)
+	assert.Equal(t, attestations, otherAssets[0].QualityAttestations)
+	ledger.as(myOrg1Msp)
+	myAssets, err := assetTransferCC.GetMyOrgsDataAssets(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, myAssets, 1)
+	assert.Equal(t, attestations, myAssets[0].QualityAttestations)
+
+	var stored map[string]interface{}
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &stored)
+	assert.NotContains(t, stored, "qualityAttestations", "attestations are not stored with the asset")
+}
+
+func TestMinQualityFilters(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) { c.ValidatorOrgs = []string{myOrg3Msp} })
+	key := prepValidatorIdentity(t, ledger)
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000", testMetadata))
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, signedTestAttestation(t, key, QualityAttestation{CompletenessPercent: 95, ChecksumVerified: true})))
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, "03-02-2000", "100", "", testUsageTerms))
+
+	assets, err := assetTransferCC.SearchDataAssets(transactionContext, 90, "")
+	require.NoError(t, err)
+	require.Len(t, assets, 1)
+	assert.Equal(t, testDataDate, assets[0].Date)
+	assets, err = assetTransferCC.SearchDataAssets(transactionContext, 0, "")
+	require.NoError(t, err)
+	assert.Len(t, assets, 2)
+
+	ledger.as(myOrg1Msp)
+	bids, err := assetTransferCC.GetBidsForMyOrgWithMinQuality(transactionContext, 90)
+	require.NoError(t, err)
+	require.Len(t, bids, 1)
+	assert.Equal(t, testDataDate, bids[0].Date)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// VerifyReadingInclusion lets anyone check that reading is reading readingIndex of the asset of deviceName on
+// date, without the rest of the day's readings. reading must be byte for byte the JSON the gateway hashed, and
+// proof is a JSON array of the hex encoded sibling hashes from the reading up to the readings Merkle root.
+func (s *SmartContract) VerifyReadingInclusion(ctx contractapi.TransactionContextInterface, deviceName string, date string, readingIndex int64, reading string, proof string) (bool, error) {
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return false, err
+	}
+	if asset.Metadata == nil || asset.Metadata.ReadingsMerkleRoot == "" {
+		return false, fmt.Errorf("asset %s was uploaded without a readings Merkle root", CreateAssetID(deviceName, date))
+	}
+	siblings, err := parseHexHashes(proof)
+	if err != nil {
+		return false, err
+	}
+	root, err := hex.DecodeString(asset.Metadata.ReadingsMerkleRoot)
+	if err != nil {
+		return false, fmt.Errorf("invalid readings Merkle root: %v", err)
+	}
+	return verifyMerkleProof(merkleLeaf([]byte(reading)), readingIndex, asset.Metadata.ReadingCount, siblings, root), nil
+}
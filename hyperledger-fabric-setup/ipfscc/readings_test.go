@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReadings(count int) []string {
+	readings := make([]string, count)
+	for i := range readings {
+		readings[i] = fmt.Sprintf(`{"time":"2000-02-02T%02d:00:00.000Z","value":%d}`, i, 100+i)
+	}
+	return readings
+}
+
+func TestVerifyReadingInclusion(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	readings := testReadings(7)
+	leaves := make([][]byte, len(readings))
+	for i, reading := range readings {
+		leaves[i] = merkleLeaf([]byte(reading))
+	}
+	metadata := fmt.Sprintf(`{"schemaVersion":3,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":3600,"readingCount":7,"byteSize":400,"compression":"deflate","encryptionAlgorithm":"AES-256-ECB","readingsMerkleRoot":"%s"}`,
+		hex.EncodeToString(merkleRoot(leaves)))
+	assert.Error(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000",
+		`{"schemaVersion":3,"sensorType":"energy meter","unit":"kWh","samplingIntervalSeconds":3600,"readingCount":7,"byteSize":400,"compression":"deflate","encryptionAlgorithm":"AES-256-ECB"}`),
+		"version 3 needs the readings Merkle root")
+	require.NoError(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000", metadata))
+
+	encoded := []string{}
+	for _, hash := range merkleProof(leaves, 4) {
+		encoded = append(encoded, hex.EncodeToString(hash))
+	}
+	proofBytes, err := json.Marshal(encoded)
+	require.NoError(t, err)
+	proof := string(proofBytes)
+
+	included, err := assetTransferCC.VerifyReadingInclusion(transactionContext, testDeviceName, "03-02-2000", 4, readings[4], proof)
+	require.NoError(t, err)
+	assert.True(t, included)
+	included, err = assetTransferCC.VerifyReadingInclusion(transactionContext, testDeviceName, "03-02-2000", 4, `{"time":"2000-02-02T04:00:00.000Z","value":1}`, proof)
+	require.NoError(t, err)
+	assert.False(t, included, "a changed reading does not verify")
+	included, err = assetTransferCC.VerifyReadingInclusion(transactionContext, testDeviceName, "03-02-2000", 5, readings[4], proof)
+	require.NoError(t, err)
+	assert.False(t, included, "the proof is bound to the index of the reading")
+
+	_, err = assetTransferCC.VerifyReadingInclusion(transactionContext, testDeviceName, testDataDate, 4, readings[4], proof)
+	assert.ErrorContains(t, err, "readings Merkle root")
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const maxReviewLength = 280
+
+// TradeRating is the score one side of a sale or licence gives the other side.
+type TradeRating struct {
+	DeviceName   string `json:"deviceName"`
+	Date         string `json:"date"`
+	TransferTxID string `json:"transferTxID"`
+	RaterOrg     string `json:"raterOrg"`
+	RatedOrg     string `json:"ratedOrg"`
+	Score        int    `json:"score"`
+	Review       string `json:"review"`
+	SubmittedAt  string `json:"submittedAt"`
+}
+
+// OrgReputation aggregates the trading history of an org, computed from ledger state.
+type OrgReputation struct {
+	Org               string  `json:"org"`
+	AverageScore      float64 `json:"averageScore"`
+	RatingCount       int     `json:"ratingCount"`
+	TradeCount        int     `json:"tradeCount"`
+	DisputeCount      int     `json:"disputeCount"`
+	LateKeyDeliveries int     `json:"lateKeyDeliveries"`
+	// FailedStorageChallenges counts the storage challenges the org answered wrongly or missed.
+	FailedStorageChallenges int `json:"failedStorageChallenges"`
+}
+
+func CreateRatingID(ratedOrg string, deviceName string, date string, transferTxID string, raterOrg string) string {
+	return "rating_" + ratedOrg + "_" + deviceName + "_" + date + "_" + transferTxID + "_" + raterOrg
+}
+
+// SubmitRating lets the two sides of the sale or licence of the asset of deviceName on date recorded in
+// transaction transferTxID rate each other with a score from 1 to 5. Each side can rate a trade once.
+func (s *SmartContract) SubmitRating(ctx contractapi.TransactionContextInterface, deviceName string, date string, transferTxID string, score int, review string) error {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+
+	transfer, err := s.getTransferRecord(ctx, deviceName, date, transferTxID)
+	if err != nil {
+		return err
+	}
+	var ratedOrg string
+	switch mspid {
+	case transfer.ToOrg:
+		ratedOrg = transfer.FromOrg
+	case transfer.FromOrg:
+		ratedOrg = transfer.ToOrg
+	default:
+		return fmt.Errorf("only the buyer and seller of a trade can rate it")
+	}
+
+	if score < 1 || score > 5 {
+		return fmt.Errorf("score must be between 1 and 5, got %d", score)
+	}
+	if len(review) > maxReviewLength {
+		return fmt.Errorf("review is longer than %d characters", maxReviewLength)
+	}
+
+	ratingID := CreateRatingID(ratedOrg, deviceName, date, transferTxID, mspid)
+	existing, err := ctx.GetStub().GetState(ratingID)
+	if err != nil {
+		return fmt.Errorf("error ocurred getting rating: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("%s already rated this trade", mspid)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	rating := TradeRating{
+		DeviceName:   deviceName,
+		Date:         date,
+		TransferTxID: transferTxID,
+		RaterOrg:     mspid,
+		RatedOrg:     ratedOrg,
+		Score:        score,
+		Review:       review,
+		SubmittedAt:  now.UTC().Format(time.RFC3339),
+	}
+	ratingBytes, err := json.Marshal(rating)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rating to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(ratingID, ratingBytes)
+}
+
+// GetRatingsForOrg returns every rating given to mspid.
+func (s *SmartContract) GetRatingsForOrg(ctx contractapi.TransactionContextInterface, mspid string) ([]*TradeRating, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("rating_"+mspid+"_", "rating_"+mspid+"_~")
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var ratings []*TradeRating
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var rating TradeRating
+		err = json.Unmarshal(queryResponse.Value, &rating)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, &rating)
+	}
+	return ratings, nil
+}
+
+// GetOrgReputation computes the ratings, trades, disputes, late key deliveries and failed storage challenges
+// of mspid.
+func (s *SmartContract) GetOrgReputation(ctx contractapi.TransactionContextInterface, mspid string) (*OrgReputation, error) {
+	reputation := OrgReputation{Org: mspid}
+
+	ratings, err := s.GetRatingsForOrg(ctx, mspid)
+	if err != nil {
+		return nil, err
+	}
+	totalScore := 0
+	for _, rating := range ratings {
+		totalScore += rating.Score
+	}
+	reputation.RatingCount = len(ratings)
+	if len(ratings) > 0 {
+		reputation.AverageScore = float64(totalScore) / float64(len(ratings))
+	}
+
+	transfers, err := s.getTransferRecords(ctx, "transfer_", "transfer_~")
+	if err != nil {
+		return nil, err
+	}
+	for _, transfer := range transfers {
+		if transfer.FromOrg == mspid || transfer.ToOrg == mspid {
+			reputation.TradeCount++
+		}
+	}
+
+	disputesIterator, err := ctx.GetStub().GetStateByRange("dispute_", "dispute_~")
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer disputesIterator.Close()
+	for disputesIterator.HasNext() {
+		queryResponse, err := disputesIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var dispute Dispute
+		err = json.Unmarshal(queryResponse.Value, &dispute)
+		if err != nil {
+			return nil, err
+		}
+		if dispute.BuyerOrg == mspid || dispute.SellerOrg == mspid {
+			reputation.DisputeCount++
+		}
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := s.getKeyDeliveries(ctx, "keyDelivery_", "keyDelivery_~")
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		if delivery.SenderOrg == mspid && delivery.IsLate(now) {
+			reputation.LateKeyDeliveries++
+		}
+	}
+	renewals, err := s.getKeyRenewals(ctx, "keyRenewal_", "keyRenewal_~")
+	if err != nil {
+		return nil, err
+	}
+	for _, renewal := range renewals {
+		if renewal.SenderOrg == mspid && renewal.IsLate(now) {
+			reputation.LateKeyDeliveries++
+		}
+	}
+
+	challengesIterator, err := ctx.GetStub().GetStateByRange("challenge_"+mspid+"_", "challenge_"+mspid+"_~")
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer challengesIterator.Close()
+	for challengesIterator.HasNext() {
+		queryResponse, err := challengesIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var challenge StorageChallenge
+		err = json.Unmarshal(queryResponse.Value, &challenge)
+		if err != nil {
+			return nil, err
+		}
+		if challenge.StorageOrg == mspid && challenge.IsFailed(now) {
+			reputation.FailedStorageChallenges++
+		}
+	}
+
+	return &reputation, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitRating(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "saleTx", 5, "great"), "there was no trade yet")
+	ledger.setTxID("saleTx")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "saleTx", 1, "not my trade"))
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "saleTx", 6, "too good"))
+	require.NoError(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "saleTx", 4, "good data"))
+	assert.Error(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "saleTx", 5, "again"), "one rating per side")
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "saleTx", 2, "slow payer"))
+
+	ratings, err := assetTransferCC.GetRatingsForOrg(transactionContext, myOrg1Msp)
+	require.NoError(t, err)
+	require.Len(t, ratings, 1)
+	assert.Equal(t, myOrg2Msp, ratings[0].RaterOrg)
+	assert.Equal(t, 4, ratings[0].Score)
+	assert.Equal(t, "saleTx", ratings[0].TransferTxID)
+}
+
+func TestRepeatTradesAreRatedApart(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	// The same bid ID comes back when the asset returns to its first owner and is sold to the same buyer again.
+	ledger.setTxID("firstSale")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	ledger.setTxID("buyBack")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg2Msp, myOrg1Msp, "100"))
+	ledger.setTxID("secondSale")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "150"))
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "firstSale", 5, ""))
+	require.NoError(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "secondSale", 1, "late key"))
+	ratings, err := assetTransferCC.GetRatingsForOrg(transactionContext, myOrg1Msp)
+	require.NoError(t, err)
+	assert.Len(t, ratings, 2)
+}
+
+func TestGetOrgReputation(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+
+	sold := time.Date(2000, 2, 3, 9, 0, 0, 0, time.UTC)
+	ledger.setTime(sold)
+	ledger.setTxID("firstSale")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	ledger.setTime(sold.Add(time.Hour))
+	require.NoError(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+
+	ledger.setTime(sold.Add(2 * time.Hour))
+	ledger.setTxID("secondSale")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg2Msp, myOrg3Msp, "200"))
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.SubmitRating(transactionContext, testDeviceName, testDataDate, "secondSale", 5, ""))
+
+	ledger.setTime(sold.Add(48 * time.Hour))
+	reputation, err := assetTransferCC.GetOrgReputation(transactionContext, myOrg2Msp)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reputation.TradeCount)
+	assert.Equal(t, 1, reputation.RatingCount)
+	assert.Equal(t, 5.0, reputation.AverageScore)
+	assert.Equal(t, 1, reputation.LateKeyDeliveries, "the key for the second sale was never delivered")
+
+	reputation, err = assetTransferCC.GetOrgReputation(transactionContext, myOrg1Msp)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reputation.TradeCount)
+	assert.Equal(t, 0, reputation.LateKeyDeliveries)
+}
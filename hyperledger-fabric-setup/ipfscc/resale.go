@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ResalePolicyAllowed = "allowed"
+	ResalePolicyNone    = "none"
+	ResalePolicyRoyalty = "royalty"
+)
+
+// RoyaltyRecord is the royalty a reseller owes the original producer of an asset for one sale.
+// There is no settlement token on the channel, so royalties are accrued on the ledger and settled off chain.
+type RoyaltyRecord struct {
+	DeviceName     string `json:"deviceName"`
+	Date           string `json:"date"`
+	ProducerOrg    string `json:"producerOrg"`
+	SellerOrg      string `json:"sellerOrg"`
+	SalePrice      int64  `json:"salePrice"`
+	RoyaltyPercent int    `json:"royaltyPercent"`
+	Amount         int64  `json:"amount"`
+	TxID           string `json:"txID"`
+}
+
+func CreateRoyaltyID(producerOrg string, deviceName string, date string, txID string) string {
+	return "royalty_" + producerOrg + "_" + deviceName + "_" + date + "_" + txID
+}
+
+// checkResaleAllowed fails if sellerOrg is reselling an asset whose producer does not allow resale, if it bought
+// the asset under usage terms that don't allow resale, or if the asset is derived from a source whose producer
+// does not allow resale. Assets uploaded before producers were recorded have no resale restrictions.
+func checkResaleAllowed(asset *DataAsset, sellerOrg string) error {
+	for _, restriction := range asset.InheritedRestrictions {
+		if restriction.ResalePolicy == ResalePolicyNone {
+			return fmt.Errorf("asset %s is derived from %v, whose producer %s does not allow resale", CreateAssetID(asset.AssetName, asset.Date), restriction.SourceAssetIDs, restriction.ProducerOrg)
+		}
+	}
+	if asset.OriginalProducerOrg == "" || sellerOrg == asset.OriginalProducerOrg {
+		return nil
+	}
+	if asset.ResalePolicy == ResalePolicyNone {
+		return fmt.Errorf("the producer %s does not allow resale of asset %s", asset.OriginalProducerOrg, CreateAssetID(asset.AssetName, asset.Date))
+	}
+	if sellerOrg == asset.OwnerOrg && asset.OwnerTerms != nil && !asset.OwnerTerms.ResaleAllowed {
+		return fmt.Errorf("%s bought asset %s under usage terms that don't allow resale", sellerOrg, CreateAssetID(asset.AssetName, asset.Date))
+	}
+	return nil
+}
+
+// accrueRoyalty records the royalty sellerOrg owes the producer when it resells an asset with a royalty policy,
+// and the royalties it owes the producers of the sources of a derived asset.
+func (s *SmartContract) accrueRoyalty(ctx contractapi.TransactionContextInterface, asset *DataAsset, sellerOrg string, price string) error {
+	if asset.ResalePolicy == ResalePolicyRoyalty && asset.OriginalProducerOrg != "" && sellerOrg != asset.OriginalProducerOrg {
+		err := s.putRoyalty(ctx, asset, asset.OriginalProducerOrg, asset.RoyaltyPercent, sellerOrg, price)
+		if err != nil {
+			return err
+		}
+	}
+	for _, restriction := range asset.InheritedRestrictions {
+		if restriction.ResalePolicy != ResalePolicyRoyalty || sellerOrg == restriction.ProducerOrg {
+			continue
+		}
+		err := s.putRoyalty(ctx, asset, restriction.ProducerOrg, restriction.RoyaltyPercent, sellerOrg, price)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SmartContract) putRoyalty(ctx contractapi.TransactionContextInterface, asset *DataAsset, producerOrg string, royaltyPercent int, sellerOrg string, price string) error {
+	salePrice, err := parsePrice(price)
+	if err != nil {
+		return fmt.Errorf("royalty can't be computed: %v", err)
+	}
+	txID := ctx.GetStub().GetTxID()
+	royalty := RoyaltyRecord{
+		DeviceName:     asset.AssetName,
+		Date:           asset.Date,
+		ProducerOrg:    producerOrg,
+		SellerOrg:      sellerOrg,
+		SalePrice:      salePrice,
+		RoyaltyPercent: royaltyPercent,
+		Amount:         salePrice * int64(royaltyPercent) / 100,
+		TxID:           txID,
+	}
+	royaltyBytes, err := json.Marshal(royalty)
+	if err != nil {
+		return fmt.Errorf("failed to marshal royalty to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateRoyaltyID(royalty.ProducerOrg, royalty.DeviceName, royalty.Date, txID), royaltyBytes)
+}
+
+// SetResalePolicy lets the original producer of an asset allow resale, forbid it, or allow it with a royalty.
+// royaltyPercent is only used with the royalty policy.
+func (s *SmartContract) SetResalePolicy(ctx contractapi.TransactionContextInterface, deviceName string, date string, policy string, royaltyPercent int) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if asset.OriginalProducerOrg != mspid {
+		return fmt.Errorf("only the original producer of asset %s can set its resale policy", CreateAssetID(deviceName, date))
+	}
+
+	switch policy {
+	case ResalePolicyAllowed, ResalePolicyNone:
+		royaltyPercent = 0
+	case ResalePolicyRoyalty:
+		if royaltyPercent <= 0 || royaltyPercent > 100 {
+			return fmt.Errorf("royalty percent must be between 1 and 100, got %d", royaltyPercent)
+		}
+	default:
+		return fmt.Errorf("unknown resale policy %q", policy)
+	}
+
+	asset.ResalePolicy = policy
+	asset.RoyaltyPercent = royaltyPercent
+	return s.putDataAsset(ctx, asset)
+}
+
+// GetRoyaltiesForMyOrg returns the royalties accrued to the calling org as the producer of resold assets.
+func (s *SmartContract) GetRoyaltiesForMyOrg(ctx contractapi.TransactionContextInterface) ([]*RoyaltyRecord, error) {
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("royalty_"+mspid+"_", "royalty_"+mspid+"_~")
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var royalties []*RoyaltyRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var royalty RoyaltyRecord
+		err = json.Unmarshal(queryResponse.Value, &royalty)
+		if err != nil {
+			return nil, err
+		}
+		royalties = append(royalties, &royalty)
+	}
+	return royalties, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadRecordsProducer(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg1Msp, asset.OriginalProducerOrg)
+	assert.Equal(t, ResalePolicyAllowed, asset.ResalePolicy)
+}
+
+func TestNoResalePolicy(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	require.NoError(t, assetTransferCC.SetResalePolicy(transactionContext, testDeviceName, testDataDate, ResalePolicyNone, 0))
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+
+	ledger.as(myOrg3Msp)
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "200", "", testUsageTerms))
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.SetResalePolicy(transactionContext, testDeviceName, testDataDate, ResalePolicyAllowed, 0), "only the producer sets the policy")
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg2Msp, asset.OwnerOrg)
+	assert.Equal(t, myOrg1Msp, asset.OriginalProducerOrg, "the producer does not change with ownership")
+}
+
+func TestResaleRoyalty(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	assert.Error(t, assetTransferCC.SetResalePolicy(transactionContext, testDeviceName, testDataDate, ResalePolicyRoyalty, 101))
+	require.NoError(t, assetTransferCC.SetResalePolicy(transactionContext, testDeviceName, testDataDate, ResalePolicyRoyalty, 10))
+
+	ledger.setTxID("firstSale")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	ledger.setTxID("resale")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg2Msp, myOrg3Msp, "250"))
+
+	ledger.as(myOrg1Msp)
+	royalties, err := assetTransferCC.GetRoyaltiesForMyOrg(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, royalties, 1, "the producer's own sale accrues no royalty")
+	assert.Equal(t, myOrg2Msp, royalties[0].SellerOrg)
+	assert.Equal(t, int64(25), royalties[0].Amount)
+}
+
+func TestResaleRefusedByBuyersTerms(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.AcceptBid(transactionContext, myOrg2Msp, testDeviceName, testDataDate, "100"))
+
+	ledger.as(myOrg3Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "200", "", testUsageTerms), "don't allow resale")
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", testUsageTerms))
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.GrantLicence(transactionContext, myOrg3Msp, testDeviceName, testDataDate), "don't allow resale", "licensing the data on is a resale too")
+	assert.ErrorContains(t, assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", "2000-02-03T00:00:00Z", testUsageTerms), "don't allow resale")
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.False(t, asset.OwnerTerms.ResaleAllowed)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ShredEvent is emitted each time a holder removes the key of an asset from its implicit collection.
+// PendingOrgs are the holders that still have to, each of which is expected to call ShredExpiredKey.
+type ShredEvent struct {
+	DeviceName  string   `json:"deviceName"`
+	Date        string   `json:"date"`
+	HolderOrg   string   `json:"holderOrg"`
+	PendingOrgs []string `json:"pendingOrgs"`
+	ShreddedAt  string   `json:"shreddedAt"`
+}
+
+// checkNotShredded fails if the key of the asset was shredded, since its data can no longer be decrypted.
+func checkNotShredded(asset *DataAsset) error {
+	if asset.Shredded {
+		return fmt.Errorf("the key of asset %s was shredded after its retention period", CreateAssetID(asset.AssetName, asset.Date))
+	}
+	return nil
+}
+
+// retentionEndsAt returns when the retention period of the asset ends, counted from the start of its data.
+func retentionEndsAt(config *MarketConfig, asset *DataAsset) (time.Time, error) {
+	dataStart, _, err := assetPeriod(config, asset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return dataStart.AddDate(0, 0, asset.RetentionDays), nil
+}
+
+// keyHolderOrgs returns every org whose implicit collection may hold the key of the asset: its producer, its
+// owners and every org the key was delivered to.
+func (s *SmartContract) keyHolderOrgs(ctx contractapi.TransactionContextInterface, asset *DataAsset) ([]string, error) {
+	holders := []string{asset.OriginalProducerOrg}
+	addHolder := func(org string) {
+		if org != "" && !contains(holders, org) {
+			holders = append(holders, org)
+		}
+	}
+	addHolder(asset.OwnerOrg)
+	for _, coOwner := range asset.CoOwners {
+		addHolder(coOwner.Org)
+	}
+
+	deliveries, err := s.GetKeyDeliveriesForAsset(ctx, asset.AssetName, asset.Date)
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		addHolder(delivery.SenderOrg)
+		addHolder(delivery.RecipientOrg)
+	}
+	return holders, nil
+}
+
+// SetRetentionPeriod lets the original producer of an asset limit how many days after its date the data may
+// be kept decryptable. A retentionDays of 0 keeps it indefinitely.
+func (s *SmartContract) SetRetentionPeriod(ctx contractapi.TransactionContextInterface, deviceName string, date string, retentionDays int) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if asset.OriginalProducerOrg != mspid {
+		return fmt.Errorf("only the original producer of asset %s can set its retention period", CreateAssetID(deviceName, date))
+	}
+	err = checkNotShredded(asset)
+	if err != nil {
+		return err
+	}
+	if retentionDays < 0 {
+		return fmt.Errorf("retention days cannot be negative, got %d", retentionDays)
+	}
+
+	asset.RetentionDays = retentionDays
+	return s.putDataAsset(ctx, asset)
+}
+
+// ShredExpiredKey removes the key of an asset whose retention period has passed from the implicit collection
+// of the calling org. Deleting and purging private data of an implicit collection needs the endorsement of the
+// org owning it, so each holder shreds its own key in its own transaction, endorsed by its peer. The first
+// holder to call it marks the asset as shredded, which stops any further trade or key delivery, and records the
+// holders that still have to shred their key in ShredPendingOrgs. Purging the key from the private data history
+// of the peers needs Fabric 2.5 or later.
+func (s *SmartContract) ShredExpiredKey(ctx contractapi.TransactionContextInterface, deviceName string, date string) error {
+	err := s.checkOperational(ctx)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	keyID := CreateAssetID(deviceName, date)
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !asset.Shredded {
+		if asset.RetentionDays == 0 {
+			return fmt.Errorf("asset %s has no retention period", keyID)
+		}
+		config, err := s.getMarketConfig(ctx)
+		if err != nil {
+			return err
+		}
+		endsAt, err := retentionEndsAt(config, asset)
+		if err != nil {
+			return err
+		}
+		if now.Before(endsAt) {
+			return fmt.Errorf("the retention period of asset %s ends at %s", keyID, endsAt.UTC().Format(time.RFC3339))
+		}
+		holders, err := s.keyHolderOrgs(ctx, asset)
+		if err != nil {
+			return err
+		}
+		asset.Shredded = true
+		asset.ShreddedAt = now.UTC().Format(time.RFC3339)
+		asset.ShredPendingOrgs = holders
+		asset.SaleEpoch++
+	}
+	if !contains(asset.ShredPendingOrgs, mspid) {
+		return fmt.Errorf("%s holds no key of asset %s that is still to be shredded", mspid, keyID)
+	}
+
+	collection := "_implicit_org_" + mspid
+	err = ctx.GetStub().DelPrivateData(collection, keyID)
+	if err != nil {
+		return fmt.Errorf("error ocurred deleting key from %s: %v", collection, err)
+	}
+	// Purging also removes the key from the private data history kept by the peers.
+	err = ctx.GetStub().PurgePrivateData(collection, keyID)
+	if err != nil {
+		return fmt.Errorf("error ocurred purging key from %s: %v", collection, err)
+	}
+
+	pending := []string{}
+	for _, org := range asset.ShredPendingOrgs {
+		if org != mspid {
+			pending = append(pending, org)
+		}
+	}
+	asset.ShredPendingOrgs = pending
+	err = s.putDataAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	eventBytes, err := json.Marshal(ShredEvent{
+		DeviceName:  deviceName,
+		Date:        date,
+		HolderOrg:   mspid,
+		PendingOrgs: pending,
+		ShreddedAt:  now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal shred event to JSON: %v", err)
+	}
+	return ctx.GetStub().SetEvent("keyShredded_"+keyID, eventBytes)
+}
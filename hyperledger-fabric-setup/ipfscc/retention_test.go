@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShredExpiredKey(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	assetID := CreateAssetID(testDeviceName, testDataDate)
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	require.NoError(t, assetTransferCC.UploadKeyPrivateData(transactionContext, testDeviceName, testCID, testDataDate))
+	assert.Error(t, assetTransferCC.ShredExpiredKey(transactionContext, testDeviceName, testDataDate), "assets without a retention period are kept")
+
+	ledger.as(myOrg2Msp)
+	assert.Error(t, assetTransferCC.SetRetentionPeriod(transactionContext, testDeviceName, testDataDate, 10), "only the producer sets retention")
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.SetRetentionPeriod(transactionContext, testDeviceName, testDataDate, -1))
+	require.NoError(t, assetTransferCC.SetRetentionPeriod(transactionContext, testDeviceName, testDataDate, 10))
+
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+	require.NoError(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+	assert.NotNil(t, ledger.privateData["_implicit_org_"+myOrg2Msp][assetID])
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "200", "", testUsageTerms))
+
+	ledger.setTime(time.Date(2000, time.February, 11, 0, 0, 0, 0, time.UTC))
+	assert.ErrorContains(t, assetTransferCC.ShredExpiredKey(transactionContext, testDeviceName, testDataDate), "retention period")
+
+	ledger.setTime(time.Date(2000, time.February, 12, 0, 0, 0, 0, time.UTC))
+	assert.ErrorContains(t, assetTransferCC.ShredExpiredKey(transactionContext, testDeviceName, testDataDate), "holds no key", "only holders shred")
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.ShredExpiredKey(transactionContext, testDeviceName, testDataDate))
+	assert.NotNil(t, ledger.privateData["_implicit_org_"+myOrg1Msp][assetID], "each holder shreds its own key")
+	assert.Nil(t, ledger.privateData["_implicit_org_"+myOrg2Msp][assetID])
+	assert.Equal(t, 1, ledger.stub.PurgePrivateDataCallCount())
+
+	var asset DataAsset
+	ledger.getJSON(t, assetID, &asset)
+	assert.True(t, asset.Shredded)
+	assert.Equal(t, "2000-02-12T00:00:00Z", asset.ShreddedAt)
+	assert.Equal(t, []string{myOrg1Msp}, asset.ShredPendingOrgs)
+
+	assert.Equal(t, "keyShredded_"+assetID, ledger.lastEvent)
+	var event ShredEvent
+	require.NoError(t, json.Unmarshal(ledger.events[ledger.lastEvent], &event))
+	assert.Equal(t, myOrg2Msp, event.HolderOrg)
+	assert.Equal(t, []string{myOrg1Msp}, event.PendingOrgs)
+
+	var bid DataBid
+	ledger.getJSON(t, "bid_"+testDeviceName+"_"+testDataDate+"_"+myOrg2Msp+"_"+myOrg3Msp, &bid)
+	assert.True(t, bid.IsStale(&asset), "open bids go stale")
+	assert.Error(t, assetTransferCC.ShredExpiredKey(transactionContext, testDeviceName, testDataDate), "a key is shredded once")
+
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.ShredExpiredKey(transactionContext, testDeviceName, testDataDate))
+	assert.Nil(t, ledger.privateData["_implicit_org_"+myOrg1Msp][assetID])
+	assert.Equal(t, 2, ledger.stub.PurgePrivateDataCallCount())
+	ledger.getJSON(t, assetID, &asset)
+	assert.Empty(t, asset.ShredPendingOrgs)
+	assert.Equal(t, "2000-02-12T00:00:00Z", asset.ShreddedAt, "the asset was shredded by the first holder")
+
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms), "shredded")
+	assert.ErrorContains(t, assetTransferCC.TransferEncKey(transactionContext, myOrg3Msp, testDeviceName, testDataDate), "shredded")
+	assert.ErrorContains(t, assetTransferCC.UploadKeyPrivateData(transactionContext, testDeviceName, testCID, testDataDate), "shredded")
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AssetVersion keeps an earlier version of an asset after SupersedeAsset replaced its data, with the reason
+// the owner gave for the correction.
+type AssetVersion struct {
+	DeviceName    string         `json:"deviceName"`
+	Date          string         `json:"date"`
+	Version       int            `json:"version"`
+	IPFS_CID      string         `json:"IPFS_CID"`
+	ContentID     *ContentID     `json:"contentID"`
+	Metadata      *AssetMetadata `json:"metadata"`
+	OwnerOrg      string         `json:"ownerOrg"`
+	SupersededAt  string         `json:"supersededAt"`
+	SupersedeTxID string         `json:"supersedeTxID"`
+	Reason        string         `json:"reason"`
+}
+
+// AssetSuperseded is the payload of the event emitted when an asset is corrected.
+type AssetSuperseded struct {
+	DeviceName      string   `json:"deviceName"`
+	Date            string   `json:"date"`
+	Version         int      `json:"version"`
+	IPFS_CID        string   `json:"IPFS_CID"`
+	PreviousVersion string   `json:"previousVersion"`
+	Reason          string   `json:"reason"`
+	KeyRecipients   []string `json:"keyRecipients"`
+}
+
+// KeyRenewal tracks the key of a corrected version of an asset, owed by the owner that corrected it to an org
+// that held the key of an earlier version. It is kept apart from the KeyDelivery of the sale, licence or
+// co-ownership the org got the asset through, which stays as it was. TransferTxID is the SupersedeAsset
+// transaction, and Version the version whose key is owed.
+type KeyRenewal struct {
+	KeyDelivery
+	Version int `json:"version"`
+}
+
+func CreateKeyRenewalID(deviceName string, date string, recipientOrg string) string {
+	return "keyRenewal_" + deviceName + "_" + date + "_" + recipientOrg
+}
+
+func (s *SmartContract) putKeyRenewal(ctx contractapi.TransactionContextInterface, renewal *KeyRenewal) error {
+	renewalBytes, err := json.Marshal(renewal)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key renewal to JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateKeyRenewalID(renewal.DeviceName, renewal.Date, renewal.RecipientOrg), renewalBytes)
+}
+
+func (s *SmartContract) getKeyRenewal(ctx contractapi.TransactionContextInterface, deviceName string, date string, recipientOrg string) (*KeyRenewal, error) {
+	renewalBytes, err := ctx.GetStub().GetState(CreateKeyRenewalID(deviceName, date, recipientOrg))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting key renewal: %v", err)
+	}
+	if renewalBytes == nil {
+		return nil, nil
+	}
+
+	var renewal KeyRenewal
+	err = json.Unmarshal(renewalBytes, &renewal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key renewal JSON: %v", err)
+	}
+	return &renewal, nil
+}
+
+func (s *SmartContract) getKeyRenewals(ctx contractapi.TransactionContextInterface, startKey string, endKey string) ([]*KeyRenewal, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	renewals := []*KeyRenewal{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var renewal KeyRenewal
+		err = json.Unmarshal(queryResponse.Value, &renewal)
+		if err != nil {
+			return nil, err
+		}
+		renewals = append(renewals, &renewal)
+	}
+	return renewals, nil
+}
+
+// GetKeyRenewalsForAsset returns the keys of corrected versions owed or delivered for the asset of deviceName on
+// date, one per recipient for the latest correction.
+func (s *SmartContract) GetKeyRenewalsForAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]*KeyRenewal, error) {
+	renewals, err := s.getKeyRenewals(ctx, "keyRenewal_"+deviceName+"_"+date+"_", "keyRenewal_"+deviceName+"_"+date+"_~")
+	if err != nil {
+		return nil, err
+	}
+	// Other devices whose name starts with deviceName and an underscore fall in the same range.
+	assetRenewals := []*KeyRenewal{}
+	for _, renewal := range renewals {
+		if renewal.DeviceName == deviceName && renewal.Date == date {
+			assetRenewals = append(assetRenewals, renewal)
+		}
+	}
+	return assetRenewals, nil
+}
+
+// recordKeyRenewed marks the key renewal owed to recipientOrg delivered, and reports whether one was pending.
+// Only the org that owes the renewal can deliver it.
+func (s *SmartContract) recordKeyRenewed(ctx contractapi.TransactionContextInterface, deviceName string, date string, senderOrg string, recipientOrg string) (bool, error) {
+	renewal, err := s.getKeyRenewal(ctx, deviceName, date, recipientOrg)
+	if err != nil {
+		return false, err
+	}
+	if renewal == nil || renewal.Status != KeyDeliveryStatusPending {
+		return false, nil
+	}
+	if renewal.SenderOrg != senderOrg {
+		return false, fmt.Errorf("the key of version %d of asset %s is owed by %s", renewal.Version, CreateAssetID(deviceName, date), renewal.SenderOrg)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	renewal.DeliveredAt = now.UTC().Format(time.RFC3339)
+	renewal.Status = KeyDeliveryStatusDelivered
+	return true, s.putKeyRenewal(ctx, renewal)
+}
+
+// CreateAssetVersionID zero pads version so the versions of an asset sort in order.
+func CreateAssetVersionID(deviceName string, date string, version int) string {
+	return fmt.Sprintf("assetVersion_%s_%s_%06d", deviceName, date, version)
+}
+
+// currentVersion returns the version of an asset, counting assets uploaded before versions as version 1.
+func currentVersion(asset *DataAsset) int {
+	if asset.Version == 0 {
+		return 1
+	}
+	return asset.Version
+}
+
+// GetAssetVersions returns the earlier versions of the asset of deviceName on date, oldest first. It is empty
+// if the asset was never corrected.
+func (s *SmartContract) GetAssetVersions(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]*AssetVersion, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("assetVersion_"+deviceName+"_"+date+"_", "assetVersion_"+deviceName+"_"+date+"_~")
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	versions := []*AssetVersion{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var version AssetVersion
+		err = json.Unmarshal(queryResponse.Value, &version)
+		if err != nil {
+			return nil, err
+		}
+		// Other devices whose name starts with deviceName and an underscore fall in the same range.
+		if version.DeviceName == deviceName && version.Date == date {
+			versions = append(versions, &version)
+		}
+	}
+	return versions, nil
+}
+
+// SupersedeAsset lets the current owner of an asset replace its data with a corrected upload stored on IPFS
+// under cid, giving the reason for the correction. metadata describes the corrected data as a JSON encoded
+// AssetMetadata, an empty string keeps the metadata of the asset. The replaced version is kept, see
+// GetAssetVersions, and its quality attestations are removed as they describe the replaced data. The transient
+// data holds the key of the corrected data under "symmetricKey", which replaces the key the owner keeps in its
+// implicit collection, and can be left out if the corrected data is encrypted with the same key. Every other org
+// holding the key of the asset is owed the key of the new version as a KeyRenewal, which TransferEncKey delivers.
+func (s *SmartContract) SupersedeAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string, cid string, reason string, metadata string) error {
+	assetID := CreateAssetID(deviceName, date)
+	err := s.checkOperational(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	if asset.OwnerOrg != mspid {
+		return fmt.Errorf("only the owner of asset %s can supersede it", assetID)
+	}
+	err = checkNotLocked(asset)
+	if err != nil {
+		return err
+	}
+	err = checkNotShredded(asset)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required to supersede asset %s", assetID)
+	}
+	contentID, err := ParseCID(cid)
+	if err != nil {
+		return err
+	}
+	if contentID.CIDv1 == assetCIDv1(asset) {
+		return fmt.Errorf("asset %s is already stored under CID %s", assetID, cid)
+	}
+	assetMetadata := asset.Metadata
+	if metadata != "" {
+		assetMetadata, err = ParseAssetMetadata(metadata)
+		if err != nil {
+			return err
+		}
+	}
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("error getting transient: %v", err)
+	}
+	ownerCollectionName := "_implicit_org_" + mspid
+	symmetricKey := string(transientMap["symmetricKey"])
+	if symmetricKey == "" {
+		ownerKey, err := ctx.GetStub().GetPrivateData(ownerCollectionName, assetID)
+		if err != nil {
+			return fmt.Errorf("error ocurred getting private data: %v", err)
+		}
+		if ownerKey == nil {
+			return fmt.Errorf("the transient data must hold the key of the corrected data of asset %s under symmetricKey", assetID)
+		}
+		var keyData KeyCIDAsset
+		err = json.Unmarshal(ownerKey, &keyData)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal private data JSON: %v", err)
+		}
+		symmetricKey = keyData.SymmetricKey
+	}
+	holders, err := s.keyHolderOrgs(ctx, asset)
+	if err != nil {
+		return err
+	}
+	attestations, err := s.GetQualityAttestations(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+
+	previous := AssetVersion{
+		DeviceName:    deviceName,
+		Date:          date,
+		Version:       currentVersion(asset),
+		IPFS_CID:      asset.IPFS_CID,
+		ContentID:     asset.ContentID,
+		Metadata:      asset.Metadata,
+		OwnerOrg:      asset.OwnerOrg,
+		SupersededAt:  now.UTC().Format(time.RFC3339),
+		SupersedeTxID: txID,
+		Reason:        reason,
+	}
+	previousID := CreateAssetVersionID(deviceName, date, previous.Version)
+	previousBytes, err := json.Marshal(previous)
+	if err != nil {
+		return fmt.Errorf("failed to marshal asset version to JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(previousID, previousBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put asset version: %v", err)
+	}
+
+	for _, attestation := range attestations {
+		err = ctx.GetStub().DelState(CreateQualityAttestationID(deviceName, date, attestation.ValidatorOrg))
+		if err != nil {
+			return fmt.Errorf("failed to delete quality attestation: %v", err)
+		}
+	}
+
+	asset.Version = previous.Version + 1
+	asset.IPFS_CID = cid
+	asset.ContentID = contentID
+	asset.Metadata = assetMetadata
+	asset.CorrectedAt = previous.SupersededAt
+	asset.CorrectionReason = reason
+	asset.PreviousVersionID = previousID
+	asset.QualityScore = 0
+	asset.QualityAttestationCount = 0
+	err = s.putDataAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+	err = putKeyPrivateData(ctx, ownerCollectionName, deviceName, cid, date, symmetricKey)
+	if err != nil {
+		return fmt.Errorf("error putting private data into the owners implicit collection: %v", err)
+	}
+
+	// The key of the new version is owed within the same window as the key of a sale, and TransferEncKey marks it
+	// delivered. A renewal still pending from an earlier correction is replaced, only the latest key is owed.
+	keyDeliveryWindow := time.Duration(config.KeyDeliveryWindowSeconds) * time.Second
+	recipients := []string{}
+	for _, holder := range holders {
+		if holder == mspid {
+			continue
+		}
+		err = s.putKeyRenewal(ctx, &KeyRenewal{
+			KeyDelivery: KeyDelivery{
+				DeviceName:   deviceName,
+				Date:         date,
+				SenderOrg:    mspid,
+				RecipientOrg: holder,
+				TransferTxID: txID,
+				DueBy:        now.Add(keyDeliveryWindow).UTC().Format(time.RFC3339),
+				Status:       KeyDeliveryStatusPending,
+			},
+			Version: asset.Version,
+		})
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, holder)
+	}
+
+	eventBytes, err := json.Marshal(AssetSuperseded{
+		DeviceName:      deviceName,
+		Date:            date,
+		Version:         asset.Version,
+		IPFS_CID:        cid,
+		PreviousVersion: previousID,
+		Reason:          reason,
+		KeyRecipients:   recipients,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal asset superseded event to JSON: %v", err)
+	}
+	return ctx.GetStub().SetEvent("assetSuperseded_"+assetID, eventBytes)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupersedeAsset(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) { c.ValidatorOrgs = []string{myOrg3Msp} })
+	key := prepValidatorIdentity(t, ledger)
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("licenceTx")
+	require.NoError(t, assetTransferCC.GrantLicence(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+	require.NoError(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.PostQualityAttestation(transactionContext, testDeviceName, testDataDate, signedTestAttestation(t, key, QualityAttestation{CompletenessPercent: 40, ChecksumVerified: true})))
+
+	assert.Error(t, assetTransferCC.SupersedeAsset(transactionContext, testDeviceName, testDataDate, testCIDv1, "sensor clock drift", ""), "only the owner supersedes")
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.SupersedeAsset(transactionContext, testDeviceName, testDataDate, testCIDv1, "", ""), "a reason is required")
+	assert.Error(t, assetTransferCC.SupersedeAsset(transactionContext, testDeviceName, testDataDate, testCIDv1, "sensor clock drift", ""), "the CIDv1 form of the same CID is no correction")
+	assert.Error(t, assetTransferCC.SupersedeAsset(transactionContext, testDeviceName, testDataDate, "42421337", "sensor clock drift", ""))
+
+	correctedCID := "bafkreie5nqv6kd3qnfjupgvz34woh3oksc3iau6abmyajn7qvtf6d2ho34"
+	assert.ErrorContains(t, assetTransferCC.SupersedeAsset(transactionContext, testDeviceName, testDataDate, correctedCID, "sensor clock drift", ""), "symmetricKey",
+		"the owner holds no key of the asset to keep")
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte("correctedKey")}, nil)
+	ledger.setTxID("supersedeTx")
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.SupersedeAsset(transactionContext, testDeviceName, testDataDate, correctedCID, "sensor clock drift", "")
+	}))
+	assert.Equal(t, "assetSuperseded_data_"+testDeviceName+"_"+testDataDate, ledger.lastEvent)
+
+	asset, err := assetTransferCC.GetAssetByID(transactionContext, testDeviceName+"_"+testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, 2, asset.Version)
+	assert.Equal(t, correctedCID, asset.IPFS_CID)
+	assert.Equal(t, "sensor clock drift", asset.CorrectionReason)
+	assert.Equal(t, "assetVersion_"+testDeviceName+"_"+testDataDate+"_000001", asset.PreviousVersionID)
+	assert.Equal(t, "energy meter", asset.Metadata.SensorType, "the metadata is kept")
+	assert.Equal(t, 0, asset.QualityAttestationCount, "attestations of the replaced data are removed")
+	attestations, err := assetTransferCC.GetQualityAttestations(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Empty(t, attestations)
+
+	versions, err := assetTransferCC.GetAssetVersions(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, testCID, versions[0].IPFS_CID)
+	assert.Equal(t, "supersedeTx", versions[0].SupersedeTxID)
+
+	ownerKey, err := assetTransferCC.GetKeyPrivateData(transactionContext, testDeviceName+"_"+testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, correctedCID, ownerKey.IPFS_CID)
+	assert.Equal(t, "correctedKey", ownerKey.SymmetricKey)
+
+	var delivery KeyDelivery
+	ledger.getJSON(t, CreateKeyDeliveryID(testDeviceName, testDataDate, myOrg2Msp), &delivery)
+	assert.Equal(t, KeyDeliveryStatusDelivered, delivery.Status, "the delivery of the licence is kept")
+	assert.Equal(t, "licenceTx", delivery.TransferTxID)
+	var renewal KeyRenewal
+	ledger.getJSON(t, CreateKeyRenewalID(testDeviceName, testDataDate, myOrg2Msp), &renewal)
+	assert.Equal(t, KeyDeliveryStatusPending, renewal.Status, "the licensee is owed the key of the new version")
+	assert.Equal(t, "supersedeTx", renewal.TransferTxID)
+	assert.Equal(t, 2, renewal.Version)
+
+	ledger.setTime(time.Date(2000, 2, 5, 0, 0, 0, 0, time.UTC))
+	ledger.as(myOrg2Msp)
+	reputation, err := assetTransferCC.GetOrgReputation(transactionContext, myOrg1Msp)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reputation.LateKeyDeliveries, "the renewal is overdue")
+	assert.ErrorContains(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate), "owed by "+myOrg1Msp)
+	ledger.as(myOrg1Msp)
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate)
+	}))
+	ledger.getJSON(t, CreateKeyRenewalID(testDeviceName, testDataDate, myOrg2Msp), &renewal)
+	assert.Equal(t, KeyDeliveryStatusDelivered, renewal.Status)
+	ledger.getJSON(t, CreateKeyDeliveryID(testDeviceName, testDataDate, myOrg2Msp), &delivery)
+	assert.Equal(t, "licenceTx", delivery.TransferTxID, "the renewal leaves the delivery of the licence as it was")
+	holders, err := assetTransferCC.GetKeyHoldersForAsset(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	require.Len(t, holders, 2)
+	assert.Equal(t, TransferKindLicence, holders[1].Via)
+	assert.Equal(t, "energy research", holders[1].Purpose)
+
+	ledger.stub.GetTransientReturns(nil, nil)
+
+	require.NoError(t, submitTx(t, ledger, func() error {
+		return assetTransferCC.SupersedeAsset(transactionContext, testDeviceName, testDataDate, testCID, "clock drift fix was wrong",
+			`{"schemaVersion":1,"sensorType":"energy meter","unit":"Wh","samplingIntervalSeconds":60,"readingCount":1440,"byteSize":52000,"compression":"deflate","encryptionAlgorithm":"AES-256-ECB"}`)
+	}))
+	versions, err = assetTransferCC.GetAssetVersions(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, correctedCID, versions[1].IPFS_CID)
+	asset, err = assetTransferCC.GetAssetByID(transactionContext, testDeviceName+"_"+testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, 3, asset.Version)
+	assert.Equal(t, "Wh", asset.Metadata.Unit)
+	ownerKey, err = assetTransferCC.GetKeyPrivateData(transactionContext, testDeviceName+"_"+testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, testCID, ownerKey.IPFS_CID)
+	assert.Equal(t, "correctedKey", ownerKey.SymmetricKey, "without a new key the owner keeps its key")
+}
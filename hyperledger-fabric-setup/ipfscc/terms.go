@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const maxPurposeLength = 256
+
+const (
+	TransferKindSale    = "sale"
+	TransferKindLicence = "licence"
+
+	LicenceStatusRequested = "requested"
+	LicenceStatusGranted   = "granted"
+	LicenceStatusRevoked   = "revoked"
+)
+
+// UsageTerms are the machine readable conditions under which a buyer or licensee may use a data asset.
+// A RetentionDays of 0 means the data may be kept indefinitely.
+type UsageTerms struct {
+	Purpose             string `json:"purpose"`
+	RetentionDays       int    `json:"retentionDays"`
+	ResaleAllowed       bool   `json:"resaleAllowed"`
+	AttributionRequired bool   `json:"attributionRequired"`
+}
+
+// DataLicence gives LicenseeOrg the right to use an asset under Terms without becoming its owner.
+type DataLicence struct {
+	DeviceName  string      `json:"deviceName"`
+	Date        string      `json:"date"`
+	OwnerOrg    string      `json:"ownerOrg"`
+	LicenseeOrg string      `json:"licenseeOrg"`
+	Price       string      `json:"price"`
+	Terms       *UsageTerms `json:"terms"`
+	Status      string      `json:"status"`
+}
+
+// TransferRecord is written for every accepted sale or granted licence, and keeps the terms that were agreed.
+type TransferRecord struct {
+	Kind        string      `json:"kind"`
+	DeviceName  string      `json:"deviceName"`
+	Date        string      `json:"date"`
+	FromOrg     string      `json:"fromOrg"`
+	ToOrg       string      `json:"toOrg"`
+	Price       string      `json:"price"`
+	Terms       *UsageTerms `json:"terms"`
+	BidID       string      `json:"bidID"`
+	PlatformFee int64       `json:"platformFee"`
+	Proceeds    []Proceeds  `json:"proceeds"`
+	TxID        string      `json:"txID"`
+	Timestamp   string      `json:"timestamp"`
+	// SellerTerms are the usage terms the seller held the asset under, and SellerCoOwners and
+	// SellerApprovalThresholdPercent the co-ownership it held the asset in, restored if the sale is reversed.
+	SellerTerms                    *UsageTerms  `json:"sellerTerms"`
+	SellerCoOwners                 []OwnerShare `json:"sellerCoOwners"`
+	SellerApprovalThresholdPercent int          `json:"sellerApprovalThresholdPercent"`
+}
+
+func CreateLicenceID(deviceName string, date string, licenseeOrg string) string {
+	return "licence_" + deviceName + "_" + date + "_" + licenseeOrg
+}
+
+func CreateTransferID(deviceName string, date string, txID string) string {
+	return "transfer_" + deviceName + "_" + date + "_" + txID
+}
+
+// ParseUsageTerms decodes and validates usage terms passed to the chaincode as JSON.
+func ParseUsageTerms(usageTerms string) (*UsageTerms, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(usageTerms)))
+	decoder.DisallowUnknownFields()
+
+	var terms UsageTerms
+	err := decoder.Decode(&terms)
+	if err != nil {
+		return nil, fmt.Errorf("usage terms are not valid JSON: %v", err)
+	}
+	err = terms.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &terms, nil
+}
+
+func (t *UsageTerms) Validate() error {
+	if t.Purpose == "" {
+		return fmt.Errorf("usage terms must state a purpose")
+	}
+	if len(t.Purpose) > maxPurposeLength {
+		return fmt.Errorf("usage terms purpose is longer than %d characters", maxPurposeLength)
+	}
+	if t.RetentionDays < 0 {
+		return fmt.Errorf("usage terms retention days must not be negative")
+	}
+	return nil
+}
+
+// checkPurpose fails if the purpose of terms is not in the purpose vocabulary of the market config.
+func (s *SmartContract) checkPurpose(ctx contractapi.TransactionContextInterface, terms *UsageTerms) error {
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !contains(config.Purposes, terms.Purpose) {
+		return fmt.Errorf("purpose %q is not one of the allowed purposes %v", terms.Purpose, config.Purposes)
+	}
+	return nil
+}
+
+// putTransferRecord records a sale or licence with the terms that apply to it.
+func (s *SmartContract) putTransferRecord(ctx contractapi.TransactionContextInterface, record *TransferRecord) error {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	record.TxID = ctx.GetStub().GetTxID()
+	record.Timestamp = now.UTC().Format(time.RFC3339)
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transfer record to JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(CreateTransferID(record.DeviceName, record.Date, record.TxID), recordBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put transfer record to the ledger: %v", err)
+	}
+
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	keyDeliveryWindow := time.Duration(config.KeyDeliveryWindowSeconds) * time.Second
+
+	// The seller owes the buyer the key, TransferEncKey marks the delivery as done.
+	return s.putKeyDelivery(ctx, &KeyDelivery{
+		DeviceName:   record.DeviceName,
+		Date:         record.Date,
+		SenderOrg:    record.FromOrg,
+		RecipientOrg: record.ToOrg,
+		TransferTxID: record.TxID,
+		DueBy:        now.Add(keyDeliveryWindow).UTC().Format(time.RFC3339),
+		Status:       KeyDeliveryStatusPending,
+	})
+}
+
+// getTransferRecord returns the sale or licence of the asset of deviceName on date recorded in transaction txID.
+func (s *SmartContract) getTransferRecord(ctx contractapi.TransactionContextInterface, deviceName string, date string, txID string) (*TransferRecord, error) {
+	transferBytes, err := ctx.GetStub().GetState(CreateTransferID(deviceName, date, txID))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting transfer record: %v", err)
+	}
+	if transferBytes == nil {
+		return nil, fmt.Errorf("no transfer %s found for asset %s", txID, CreateAssetID(deviceName, date))
+	}
+	var transfer TransferRecord
+	err = json.Unmarshal(transferBytes, &transfer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transfer record: %v", err)
+	}
+	return &transfer, nil
+}
+
+func (s *SmartContract) getTransferRecords(ctx contractapi.TransactionContextInterface, startKey string, endKey string) ([]*TransferRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var records []*TransferRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var record TransferRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+// GetTransfersForAsset returns every sale and licence recorded for the asset of deviceName on date.
+func (s *SmartContract) GetTransfersForAsset(ctx contractapi.TransactionContextInterface, deviceName string, date string) ([]*TransferRecord, error) {
+	startKey := "transfer_" + deviceName + "_" + date + "_"
+	endKey := "transfer_" + deviceName + "_" + date + "_~"
+	return s.getTransferRecords(ctx, startKey, endKey)
+}
+
+// GetTermsForAssetsSoldByMyOrg returns the transfers the calling org made, with the usage terms that apply to them.
+func (s *SmartContract) GetTermsForAssetsSoldByMyOrg(ctx contractapi.TransactionContextInterface) ([]*TransferRecord, error) {
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+
+	allRecords, err := s.getTransferRecords(ctx, "transfer_", "transfer_~")
+	if err != nil {
+		return nil, err
+	}
+	var records []*TransferRecord
+	for _, record := range allRecords {
+		if record.FromOrg == mspid {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// RequestLicence asks the owner of an asset for a licence to use it under usageTerms, which is given as JSON,
+// for price, a positive whole amount like the price of a bid.
+func (s *SmartContract) RequestLicence(ctx contractapi.TransactionContextInterface, deviceName string, date string, price string, usageTerms string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	licenseeOrg, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	ownerOrg, err := s.GetAssetOwner(ctx, deviceName, date)
+	if err != nil {
+		return fmt.Errorf("failed to get Asset Owner %v", err)
+	}
+	if ownerOrg == licenseeOrg {
+		return fmt.Errorf("an org cannot request a licence for its own asset")
+	}
+	_, err = parseOfferPrice(price)
+	if err != nil {
+		return err
+	}
+	terms, err := ParseUsageTerms(usageTerms)
+	if err != nil {
+		return err
+	}
+	err = s.checkPurpose(ctx, terms)
+	if err != nil {
+		return err
+	}
+
+	licence := DataLicence{
+		DeviceName:  deviceName,
+		Date:        date,
+		OwnerOrg:    ownerOrg,
+		LicenseeOrg: licenseeOrg,
+		Price:       price,
+		Terms:       terms,
+		Status:      LicenceStatusRequested,
+	}
+	licenceBytes, err := json.Marshal(licence)
+	if err != nil {
+		return fmt.Errorf("error ocurred marshalling JSON to Byte array: %v", err)
+	}
+	return ctx.GetStub().PutState(CreateLicenceID(deviceName, date, licenseeOrg), licenceBytes)
+}
+
+// GetLicence returns the licence requested by, or granted to, licenseeOrg for an asset.
+func (s *SmartContract) GetLicence(ctx contractapi.TransactionContextInterface, licenseeOrg string, deviceName string, date string) (*DataLicence, error) {
+	licenceBytes, err := ctx.GetStub().GetState(CreateLicenceID(deviceName, date, licenseeOrg))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting licence: %v", err)
+	}
+	if licenceBytes == nil {
+		return nil, fmt.Errorf("no licence found for %s on asset %s", licenseeOrg, CreateAssetID(deviceName, date))
+	}
+
+	var licence DataLicence
+	err = json.Unmarshal(licenceBytes, &licence)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal licence JSON: %v", err)
+	}
+	return &licence, nil
+}
+
+// GrantLicence is called by the asset owner to grant a requested licence. The key is then shared with TransferEncKey.
+// A licence of a co-owned asset needs the approval of its co-owners, and its price is split between them.
+func (s *SmartContract) GrantLicence(ctx contractapi.TransactionContextInterface, licenseeOrg string, deviceName string, date string) error {
+	err := s.checkOperational(ctx, CreateAssetID(deviceName, date))
+	if err != nil {
+		return err
+	}
+	ownerOrg, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("error ocurred getting MSPID: %v", err)
+	}
+	licence, err := s.GetLicence(ctx, licenseeOrg, deviceName, date)
+	if err != nil {
+		return err
+	}
+	asset, err := s.getDataAsset(ctx, deviceName, date)
+	if err != nil {
+		return fmt.Errorf("failed to get Asset Owner %v", err)
+	}
+	if asset.OwnerOrg != ownerOrg || licence.OwnerOrg != ownerOrg {
+		return fmt.Errorf("only the owner of asset %s can grant licences for it", CreateAssetID(deviceName, date))
+	}
+	err = checkResaleAllowed(asset, ownerOrg)
+	if err != nil {
+		return err
+	}
+	err = checkNotLocked(asset)
+	if err != nil {
+		return err
+	}
+	err = checkNotShredded(asset)
+	if err != nil {
+		return err
+	}
+	if licence.Status != LicenceStatusRequested {
+		return fmt.Errorf("licence for %s is already %s", licenseeOrg, licence.Status)
+	}
+	err = s.checkNoOpenErasure(ctx, deviceName, date)
+	if err != nil {
+		return err
+	}
+	err = s.checkConsent(ctx, deviceName, licenseeOrg, licence.Terms)
+	if err != nil {
+		return err
+	}
+	// Co-owners approve a licence like a sale to the licensee, see ApproveLicence.
+	err = s.checkCoOwnerApproval(ctx, asset, &DataBid{
+		BiddingOrg:      licenseeOrg,
+		CurrentOwnerOrg: ownerOrg,
+		DeviceName:      deviceName,
+		Date:            date,
+		Price:           licence.Price,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.accrueRoyalty(ctx, asset, ownerOrg, licence.Price)
+	if err != nil {
+		return err
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	platformFee, err := computePlatformFee(config, licence.Price)
+	if err != nil {
+		return err
+	}
+	proceeds, err := splitProceeds(asset, licence.Price, platformFee)
+	if err != nil {
+		return err
+	}
+
+	licence.Status = LicenceStatusGranted
+	licenceBytes, err := json.Marshal(licence)
+	if err != nil {
+		return fmt.Errorf("error ocurred marshalling JSON to Byte array: %v", err)
+	}
+	err = ctx.GetStub().PutState(CreateLicenceID(deviceName, date, licenseeOrg), licenceBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put licence to the ledger: %v", err)
+	}
+
+	return s.putTransferRecord(ctx, &TransferRecord{
+		Kind:        TransferKindLicence,
+		DeviceName:  deviceName,
+		Date:        date,
+		FromOrg:     ownerOrg,
+		ToOrg:       licenseeOrg,
+		Price:       licence.Price,
+		Terms:       licence.Terms,
+		PlatformFee: platformFee,
+		Proceeds:    proceeds,
+	})
+}
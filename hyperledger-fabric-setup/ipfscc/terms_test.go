@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUsageTerms(t *testing.T) {
+	terms, err := ParseUsageTerms(testUsageTerms)
+	require.NoError(t, err)
+	assert.Equal(t, "energy research", terms.Purpose)
+	assert.Equal(t, 30, terms.RetentionDays)
+	assert.True(t, terms.AttributionRequired)
+
+	_, err = ParseUsageTerms("no commitments")
+	assert.Error(t, err)
+	_, err = ParseUsageTerms(`{"retentionDays":30}`)
+	assert.Error(t, err, "purpose is required")
+	_, err = ParseUsageTerms(`{"purpose":"research","retentionDays":-1}`)
+	assert.Error(t, err, "retention must not be negative")
+	_, err = ParseUsageTerms(`{"purpose":"research","resale":true}`)
+	assert.Error(t, err, "unknown fields are rejected")
+}
+
+func TestAcceptedBidRecordsTerms(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", testUsageTerms))
+	assert.Error(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", `{"purpose":""}`))
+
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("acceptTx")
+	require.NoError(t, assetTransferCC.AcceptBid(transactionContext, myOrg2Msp, testDeviceName, testDataDate, "100"))
+
+	var record TransferRecord
+	ledger.getJSON(t, CreateTransferID(testDeviceName, testDataDate, "acceptTx"), &record)
+	assert.Equal(t, TransferKindSale, record.Kind)
+	assert.Equal(t, myOrg2Msp, record.ToOrg)
+	assert.Equal(t, "energy research", record.Terms.Purpose)
+
+	soldTerms, err := assetTransferCC.GetTermsForAssetsSoldByMyOrg(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, soldTerms, 1)
+	assert.Equal(t, 30, soldTerms[0].Terms.RetentionDays)
+
+	ledger.as(myOrg2Msp)
+	soldTerms, err = assetTransferCC.GetTermsForAssetsSoldByMyOrg(transactionContext)
+	require.NoError(t, err)
+	assert.Len(t, soldTerms, 0)
+}
+
+func TestLicence(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "fifty", testUsageTerms), "not a whole number")
+	assert.ErrorContains(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "0", testUsageTerms), "must be positive")
+	assert.ErrorContains(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "-50", testUsageTerms), "must not be negative")
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "0", "", testUsageTerms), "must be positive",
+		"bids and licence requests check prices alike")
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", testUsageTerms))
+	assert.Error(t, assetTransferCC.GrantLicence(transactionContext, myOrg2Msp, testDeviceName, testDataDate), "only the owner grants licences")
+
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.GrantLicence(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+	assert.Error(t, assetTransferCC.GrantLicence(transactionContext, myOrg2Msp, testDeviceName, testDataDate), "licence is already granted")
+
+	licence, err := assetTransferCC.GetLicence(transactionContext, myOrg2Msp, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	assert.Equal(t, LicenceStatusGranted, licence.Status)
+
+	transfers, err := assetTransferCC.GetTransfersForAsset(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	assert.Equal(t, TransferKindLicence, transfers[0].Kind)
+	assert.Equal(t, "energy research", transfers[0].Terms.Purpose)
+
+	var asset DataAsset
+	ledger.getJSON(t, CreateAssetID(testDeviceName, testDataDate), &asset)
+	assert.Equal(t, myOrg1Msp, asset.OwnerOrg, "a licence does not change ownership")
+}
+
+func TestPurposeVocabulary(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.setConfig(t, func(c *MarketConfig) { c.Purposes = []string{"energy research"} })
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.as(myOrg2Msp)
+	assert.ErrorContains(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testDataDate, "100", "", `{"purpose":"marketing"}`), "not one of the allowed purposes")
+	assert.Error(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", `{"purpose":"marketing"}`))
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	assert.Error(t, assetTransferCC.StartEnglishAuction(transactionContext, testDeviceName, testDataDate, "100", "10", "2000-02-03T00:00:00Z", `{"purpose":"marketing"}`))
+}
+
+func TestGetKeyHoldersForAsset(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	ledger.stub.GetTransientReturns(map[string][]byte{"symmetricKey": []byte(testEncryptionKey)}, nil)
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	ledger.as(myOrg3Msp)
+	require.NoError(t, assetTransferCC.RequestLicence(transactionContext, testDeviceName, testDataDate, "50", `{"purpose":"academic research"}`))
+	ledger.as(myOrg1Msp)
+	ledger.setTxID("licenceTx")
+	require.NoError(t, assetTransferCC.GrantLicence(transactionContext, myOrg3Msp, testDeviceName, testDataDate))
+	require.NoError(t, assetTransferCC.TransferEncKey(transactionContext, myOrg3Msp, testDeviceName, testDataDate))
+
+	ledger.setTxID("saleTx")
+	require.NoError(t, sellTestAsset(t, &assetTransferCC, ledger, myOrg1Msp, myOrg2Msp, "100"))
+
+	holders, err := assetTransferCC.GetKeyHoldersForAsset(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	require.Len(t, holders, 2, "the buyer holds the key only once it is delivered")
+	assert.Equal(t, KeyHolder{Org: myOrg1Msp, Via: KeyHolderViaProducer}, *holders[0])
+	assert.Equal(t, myOrg3Msp, holders[1].Org)
+	assert.Equal(t, TransferKindLicence, holders[1].Via)
+	assert.Equal(t, "academic research", holders[1].Purpose)
+	assert.Equal(t, "licenceTx", holders[1].TransferTxID)
+
+	require.NoError(t, assetTransferCC.TransferEncKey(transactionContext, myOrg2Msp, testDeviceName, testDataDate))
+	holders, err = assetTransferCC.GetKeyHoldersForAsset(transactionContext, testDeviceName, testDataDate)
+	require.NoError(t, err)
+	require.Len(t, holders, 3)
+	assert.Equal(t, myOrg2Msp, holders[1].Org)
+	assert.Equal(t, TransferKindSale, holders[1].Via)
+	assert.Equal(t, "energy research", holders[1].Purpose)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CreateAssetWindow returns the window of a windowed asset, which takes the place of the date in its key and
+// in the keys of its bids, licences and other records, so every function taking a date also takes a window.
+func CreateAssetWindow(windowStart time.Time, windowEnd time.Time) string {
+	return windowStart.UTC().Format(time.RFC3339) + "_" + windowEnd.UTC().Format(time.RFC3339)
+}
+
+// DeviceWindowIndex lists the windows of the windowed assets of a device whose data falls on a UTC day, so
+// uploads find the windows they would overlap with point reads instead of scanning every asset of the device.
+type DeviceWindowIndex struct {
+	Windows []string `json:"windows"`
+}
+
+// CreateDeviceWindowIndexID takes a day as a 2006-01-02 date.
+func CreateDeviceWindowIndexID(deviceName string, day string) string {
+	return "windowIndex_" + deviceName + "_" + day
+}
+
+// touchedDays returns the UTC days the time from start up to end falls on, as 2006-01-02 dates.
+func touchedDays(start time.Time, end time.Time) []string {
+	var days []string
+	start, end = start.UTC(), end.UTC()
+	for day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC); day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	return days
+}
+
+func (s *SmartContract) getDeviceWindowIndex(ctx contractapi.TransactionContextInterface, deviceName string, day string) (*DeviceWindowIndex, error) {
+	indexBytes, err := ctx.GetStub().GetState(CreateDeviceWindowIndexID(deviceName, day))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting device window index: %v", err)
+	}
+	index := &DeviceWindowIndex{}
+	if indexBytes == nil {
+		return index, nil
+	}
+	err = json.Unmarshal(indexBytes, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device window index JSON: %v", err)
+	}
+	return index, nil
+}
+
+// indexWindowedAsset adds a windowed asset to the window index of every day its data falls on.
+func (s *SmartContract) indexWindowedAsset(ctx contractapi.TransactionContextInterface, deviceName string, start time.Time, end time.Time) error {
+	for _, day := range touchedDays(start, end) {
+		index, err := s.getDeviceWindowIndex(ctx, deviceName, day)
+		if err != nil {
+			return err
+		}
+		index.Windows = append(index.Windows, CreateAssetWindow(start, end))
+		indexBytes, err := json.Marshal(index)
+		if err != nil {
+			return fmt.Errorf("failed to marshal device window index to JSON: %v", err)
+		}
+		err = ctx.GetStub().PutState(CreateDeviceWindowIndexID(deviceName, day), indexBytes)
+		if err != nil {
+			return fmt.Errorf("failed to put device window index: %v", err)
+		}
+	}
+	return nil
+}
+
+// assetPeriod returns the time the data of an asset starts and ends at. A daily asset covers its whole date.
+func assetPeriod(config *MarketConfig, asset *DataAsset) (time.Time, time.Time, error) {
+	if asset.WindowStart != "" {
+		start, err := time.Parse(time.RFC3339, asset.WindowStart)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid asset window start: %v", err)
+		}
+		end, err := time.Parse(time.RFC3339, asset.WindowEnd)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid asset window end: %v", err)
+		}
+		return start, end, nil
+	}
+	date, err := config.ParseDate(asset.Date)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return date, date.AddDate(0, 0, 1), nil
+}
+
+// getDeviceAssetsOverlapping returns the assets of deviceName whose data overlaps the time from start to end.
+func (s *SmartContract) getDeviceAssetsOverlapping(ctx contractapi.TransactionContextInterface, config *MarketConfig, deviceName string, start time.Time, end time.Time) ([]*DataAsset, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(CreateAssetID(deviceName, ""), CreateAssetID(deviceName, "~"))
+	if err != nil {
+		return nil, fmt.Errorf("error ocurred getting iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var assets []*DataAsset
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var asset DataAsset
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return nil, err
+		}
+		// Other devices whose name starts with deviceName and an underscore fall in the same range.
+		if asset.AssetName != deviceName {
+			continue
+		}
+		assetStart, assetEnd, err := assetPeriod(config, &asset)
+		if err != nil || !assetStart.Before(end) || !start.Before(assetEnd) {
+			continue
+		}
+		assets = append(assets, &asset)
+	}
+	return assets, nil
+}
+
+// checkNoOverlappingAsset stops a device from selling the same readings twice under different assets. It only
+// makes point reads, so uploads of other devices or other days never conflict with it: for every day the data
+// falls on, the daily asset of the device under each allowed date format, and the window index of the day.
+func (s *SmartContract) checkNoOverlappingAsset(ctx contractapi.TransactionContextInterface, config *MarketConfig, deviceName string, start time.Time, end time.Time) error {
+	for _, day := range touchedDays(start, end) {
+		dayStart, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return err
+		}
+		for _, layout := range config.AllowedDateFormats {
+			assetID := CreateAssetID(deviceName, dayStart.Format(layout))
+			exists, err := s.AssetExists(ctx, assetID)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return fmt.Errorf("the data of device %s overlaps the existing asset %s", deviceName, assetID)
+			}
+		}
+
+		index, err := s.getDeviceWindowIndex(ctx, deviceName, day)
+		if err != nil {
+			return err
+		}
+		for _, window := range index.Windows {
+			windowStart, windowEnd, found := strings.Cut(window, "_")
+			if !found {
+				return fmt.Errorf("invalid window %q in the window index of device %s", window, deviceName)
+			}
+			assetStart, err := time.Parse(time.RFC3339, windowStart)
+			if err != nil {
+				return fmt.Errorf("invalid window start in the window index of device %s: %v", deviceName, err)
+			}
+			assetEnd, err := time.Parse(time.RFC3339, windowEnd)
+			if err != nil {
+				return fmt.Errorf("invalid window end in the window index of device %s: %v", deviceName, err)
+			}
+			if assetStart.Before(end) && start.Before(assetEnd) {
+				return fmt.Errorf("the data of device %s overlaps the existing asset %s", deviceName, CreateAssetID(deviceName, window))
+			}
+		}
+	}
+	return nil
+}
+
+// parseWindow checks that the window from windowStart to windowEnd, both RFC3339 timestamps, is made of whole
+// chunks of granularity, one of the asset granularities in the market config, aligned to midnight UTC.
+func parseWindow(config *MarketConfig, windowStart string, windowEnd string, granularity string) (time.Time, time.Time, error) {
+	if !contains(config.AssetGranularities, granularity) {
+		return time.Time{}, time.Time{}, fmt.Errorf("granularity %q is not one of %v", granularity, config.AssetGranularities)
+	}
+	chunk, err := time.ParseDuration(granularity)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid granularity %q: %v", granularity, err)
+	}
+	start, err := time.Parse(time.RFC3339, windowStart)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("window start is not an RFC3339 timestamp: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, windowEnd)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("window end is not an RFC3339 timestamp: %v", err)
+	}
+	start, end = start.UTC(), end.UTC()
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("window start must be before window end")
+	}
+	midnight := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	if start.Sub(midnight)%chunk != 0 || end.Sub(start)%chunk != 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("window %s is not made of whole %s chunks", CreateAssetWindow(start, end), granularity)
+	}
+	return start, end, nil
+}
+
+// UploadWindowedDataAsAsset records a new asset for the data of deviceName from windowStart up to windowEnd,
+// for devices that sell their data in chunks smaller or larger than a day. granularity is the chunk size, such
+// as "15m" or "1h", and the window must not overlap another asset of the device. The asset is addressed by
+// the window returned by CreateAssetWindow wherever other functions take a date. metadata is a JSON encoded
+// AssetMetadata describing the data.
+func (s *SmartContract) UploadWindowedDataAsAsset(ctx contractapi.TransactionContextInterface, deviceName string, cid string, windowStart string, windowEnd string, granularity string, metadata string) error {
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseWindow(config, windowStart, windowEnd, granularity)
+	if err != nil {
+		return err
+	}
+	asset, err := s.newDataAsset(ctx, deviceName, cid, CreateAssetWindow(start, end), metadata)
+	if err != nil {
+		return err
+	}
+	asset.WindowStart = start.Format(time.RFC3339)
+	asset.WindowEnd = end.Format(time.RFC3339)
+	asset.Granularity = granularity
+	err = s.checkNoOverlappingAsset(ctx, config, deviceName, start, end)
+	if err != nil {
+		return err
+	}
+	err = s.putDataAsset(ctx, asset)
+	if err != nil {
+		return err
+	}
+	return s.indexWindowedAsset(ctx, deviceName, start, end)
+}
+
+// GetDataAssetsForDeviceBetween returns the daily and windowed assets of deviceName whose data overlaps the
+// time from start to end, both RFC3339 timestamps.
+func (s *SmartContract) GetDataAssetsForDeviceBetween(ctx contractapi.TransactionContextInterface, deviceName string, start string, end string) ([]*DataAsset, error) {
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return nil, fmt.Errorf("start is not an RFC3339 timestamp: %v", err)
+	}
+	endTime, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return nil, fmt.Errorf("end is not an RFC3339 timestamp: %v", err)
+	}
+	config, err := s.getMarketConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.getDeviceAssetsOverlapping(ctx, config, deviceName, startTime, endTime)
+}
+
+// validateGranularity checks that an asset granularity splits a day into whole chunks.
+func validateGranularity(granularity string) error {
+	chunk, err := time.ParseDuration(granularity)
+	if err != nil || chunk < time.Minute || (24*time.Hour)%chunk != 0 {
+		return fmt.Errorf("asset granularity %q must be a duration of at least a minute that divides a day", granularity)
+	}
+	return nil
+}
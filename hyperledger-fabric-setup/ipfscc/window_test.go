@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testWindow = "2000-02-03T10:00:00Z_2000-02-03T11:00:00Z"
+
+func TestUploadWindowedDataAsAsset(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+
+	require.NoError(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T10:00:00Z", "2000-02-03T11:00:00Z", "1h", testMetadata))
+	asset, err := assetTransferCC.GetAssetByID(transactionContext, testDeviceName+"_"+testWindow)
+	require.NoError(t, err)
+	assert.Equal(t, testWindow, asset.Date)
+	assert.Equal(t, "2000-02-03T10:00:00Z", asset.WindowStart)
+	assert.Equal(t, "1h", asset.Granularity)
+
+	assert.Error(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T10:00:00Z", "2000-02-03T11:00:00Z", "2h", testMetadata), "granularity must be in the config")
+	assert.Error(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T12:10:00Z", "2000-02-03T13:10:00Z", "1h", testMetadata), "windows are aligned to the granularity")
+	assert.Error(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T12:00:00Z", "2000-02-03T12:20:00Z", "15m", testMetadata), "windows are whole chunks")
+	assert.Error(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T12:00:00Z", "2000-02-03T12:00:00Z", "15m", testMetadata))
+	assert.Error(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03", "2000-02-04", "24h", testMetadata))
+	assert.ErrorContains(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T10:30:00Z", "2000-02-03T10:45:00Z", "15m", testMetadata), "overlaps")
+	assert.ErrorContains(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-02T23:00:00Z", "2000-02-03T00:00:00Z", "1h", testMetadata), "overlaps", "the daily asset covers the whole date")
+	assert.ErrorContains(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "03-02-2000", testMetadata), "overlaps")
+	require.NoError(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T12:00:00+01:00", "2000-02-03T11:15:00Z", "15m", testMetadata), "adjacent windows don't overlap")
+	require.NoError(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName+"_2", testCID, "2000-02-03T10:00:00Z", "2000-02-03T11:00:00Z", "1h", testMetadata))
+
+	assets, err := assetTransferCC.GetDataAssetsForDeviceBetween(transactionContext, testDeviceName, "2000-02-02T12:00:00Z", "2000-02-03T11:05:00Z")
+	require.NoError(t, err)
+	require.Len(t, assets, 3)
+	assert.Equal(t, testDataDate, assets[0].Date)
+	assert.Equal(t, "2000-02-03T11:00:00Z_2000-02-03T11:15:00Z", assets[2].Date, "windows are stored in UTC")
+}
+
+func TestUploadsDontScanTheAssetsOfTheDevice(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	uploadTestAsset(t, transactionContext, ledger, myOrg1Msp)
+	ledger.stub.GetTransientReturns(map[string][]byte{testDeviceName + "_03-02-2000": []byte(testEncryptionKey)}, nil)
+
+	uploads := []func() error{
+		func() error {
+			return assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-04T10:00:00Z", "2000-02-04T11:00:00Z", "1h", testMetadata)
+		},
+		func() error {
+			return assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "05-02-2000", testMetadata)
+		},
+		func() error {
+			return assetTransferCC.UploadDataAssetsBatch(transactionContext, `[{"deviceName":"`+testDeviceName+`","cid":"`+testCID+`","date":"03-02-2000","metadata":`+testMetadata+`}]`)
+		},
+	}
+	for _, upload := range uploads {
+		sim := simulateTx(t, ledger, upload)
+		assert.Empty(t, sim.ranges, "other uploads of the device can't cause phantom reads")
+		assert.True(t, commitBlock(ledger, []*txSimulation{sim})[0])
+	}
+
+	assert.ErrorContains(t, assetTransferCC.UploadDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-04", testMetadata), "overlaps", "found through the window index")
+	assert.ErrorContains(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-05T10:00:00Z", "2000-02-05T11:00:00Z", "1h", testMetadata), "overlaps")
+	require.NoError(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-06T00:00:00Z", "2000-02-08T00:00:00Z", "24h", testMetadata))
+	var index DeviceWindowIndex
+	ledger.getJSON(t, CreateDeviceWindowIndexID(testDeviceName, "2000-02-07"), &index)
+	assert.Equal(t, []string{"2000-02-06T00:00:00Z_2000-02-08T00:00:00Z"}, index.Windows, "windows are indexed on every day they cover")
+	assert.NotContains(t, ledger.state, CreateDeviceWindowIndexID(testDeviceName, "2000-02-08"))
+}
+
+func TestSellWindowedAsset(t *testing.T) {
+	transactionContext, ledger := prepLedgerMocks(myOrg1Msp)
+	assetTransferCC := SmartContract{}
+	require.NoError(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T10:00:00Z", "2000-02-03T11:00:00Z", "1h", testMetadata))
+	require.NoError(t, assetTransferCC.UploadWindowedDataAsAsset(transactionContext, testDeviceName, testCID, "2000-02-03T11:00:00Z", "2000-02-03T12:00:00Z", "1h", testMetadata))
+
+	ledger.as(myOrg2Msp)
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, testWindow, "100", "", testUsageTerms))
+	require.NoError(t, assetTransferCC.BidForData(transactionContext, testDeviceName, "2000-02-03T11:00:00Z_2000-02-03T12:00:00Z", "100", "", testUsageTerms))
+	ledger.as(myOrg1Msp)
+	require.NoError(t, assetTransferCC.AcceptBid(transactionContext, myOrg2Msp, testDeviceName, testWindow, "100"))
+
+	asset, err := assetTransferCC.GetAssetByID(transactionContext, testDeviceName+"_"+testWindow)
+	require.NoError(t, err)
+	assert.Equal(t, myOrg2Msp, asset.OwnerOrg)
+	bids, err := assetTransferCC.GetBidsForMyOrg(transactionContext)
+	require.NoError(t, err)
+	require.Len(t, bids, 1, "only the bids on the sold window are inactivated")
+	assert.Equal(t, "2000-02-03T11:00:00Z_2000-02-03T12:00:00Z", bids[0].Date)
+}
+
+func TestRetentionOfWindowedAsset(t *testing.T) {
+	asset := &DataAsset{WindowStart: "2000-02-03T10:00:00Z", WindowEnd: "2000-02-03T11:00:00Z", RetentionDays: 2}
+	endsAt, err := retentionEndsAt(defaultMarketConfig(), asset)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2000, time.February, 5, 10, 0, 0, 0, time.UTC), endsAt)
+}
@@ -175,19 +175,80 @@ async function getOtherOrgsDataAssets(contract) {
  * @param {*} deviceName
  * @param {*} cid
  * @param {*} date
+ * @param {*} metadata describes the uploaded data, validated by the chaincode against its metadata schema
  */
-async function uploadDataAsAsset(contract, deviceName, cid, date) {
+async function uploadDataAsAsset(contract, deviceName, cid, date, metadata) {
   console.log(
-    "\n--> Submit Transaction: UploadDataAsAsset, creates a new asset with ID: cid+_+date, deviceName, cid, date"
+    "\n--> Submit Transaction: UploadDataAsAsset, creates a new asset with ID: cid+_+date, deviceName, cid, date, metadata"
   );
   try {
-    await contract.submitTransaction("UploadDataAsAsset", deviceName, cid, date);
+    await contract.submitTransaction(
+      "UploadDataAsAsset",
+      deviceName,
+      cid,
+      date,
+      JSON.stringify(metadata)
+    );
     console.log("*** Transaction committed successfully");
   } catch (error) {
     console.log("*** Error during UploadDataAsAsset: \n", error);
   }
 }
 
+/**
+ * Submits a blocking synchronous transaction for data covering a window shorter or longer than a day.
+ * The key is uploaded with uploadKeyPrivateData, passing the window "<windowStart>_<windowEnd>" in UTC as the date.
+ * @param {*} deviceName
+ * @param {*} cid
+ * @param {*} windowStart RFC3339 timestamp the data starts at
+ * @param {*} windowEnd RFC3339 timestamp the data ends before
+ * @param {*} granularity chunk size of the window, one of the asset granularities in the market config, such as "15m"
+ * @param {*} metadata describes the uploaded data, validated by the chaincode against its metadata schema
+ */
+async function uploadWindowedDataAsAsset(contract, deviceName, cid, windowStart, windowEnd, granularity, metadata) {
+  console.log(
+    "\n--> Submit Transaction: UploadWindowedDataAsAsset, creates a new asset with ID: deviceName+_+windowStart+_+windowEnd"
+  );
+  try {
+    await contract.submitTransaction(
+      "UploadWindowedDataAsAsset",
+      deviceName,
+      cid,
+      windowStart,
+      windowEnd,
+      granularity,
+      JSON.stringify(metadata)
+    );
+    console.log("*** Transaction committed successfully");
+  } catch (error) {
+    console.log("*** Error during UploadWindowedDataAsAsset: \n", error);
+  }
+}
+
+/**
+ * Submits one transaction recording many daily assets and their keys, instead of an uploadDataAsAsset and an
+ * uploadKeyPrivateData per asset. Either every asset of the batch is recorded or none is.
+ * @param {*} entries array of { deviceName, cid, date, metadata }
+ * @param {*} symmetricKeys maps "<deviceName>_<date>" of every entry to its base64 encoded symmetric key
+ * @returns {Promise<Boolean>} whether the transaction committed, none of the entries are recorded if it didn't
+ */
+async function uploadDataAssetsBatch(contract, entries, symmetricKeys) {
+  console.log(
+    `\n--> Submit Transaction: UploadDataAssetsBatch, creates ${entries.length} new assets and stores their keys`
+  );
+  try {
+    await contract.submit("UploadDataAssetsBatch", {
+      arguments: [JSON.stringify(entries)],
+      transientData: symmetricKeys,
+    });
+    console.log("*** Transaction committed successfully");
+    return true;
+  } catch (error) {
+    console.log("*** Error during UploadDataAssetsBatch: \n", error);
+    return false;
+  }
+}
+
 // UploadKeyPrivateData(ctx contractapi.TransactionContextInterface, assetName string, IPFS_CID string, date string, symmetricKey string)
 async function uploadKeyPrivateData(contract, deviceName, IPFS_CID, date, symmetricKey) {
   try {
@@ -231,6 +292,19 @@ async function transferEncKey(contract, clientOrg, newOwnerOrg, deviceName, date
   }
 }
 
+// Only the peer of clientOrg can delete and purge the key from its implicit collection, so it alone endorses.
+async function shredExpiredKey(contract, clientOrg, deviceName, date) {
+  try {
+    await contract.submit("ShredExpiredKey", {
+      arguments: [deviceName, date],
+      endorsingOrganizations: [clientOrg],
+    });
+    console.log("*** Key successfully shredded from private collection!");
+  } catch (error) {
+    console.error(`***Error shredding key of device ${deviceName}s data:`, error);
+  }
+}
+
 async function getAssetByID(contract, assetId) {
   try {
     const resultBytes = await contract.evaluateTransaction("GetAssetByID", assetId);
@@ -259,9 +333,16 @@ async function getBidsForMyOrg(contract) {
   }
 }
 
-async function bidForData(contract, deviceName, date, price, additionalCommitments) {
+async function bidForData(contract, deviceName, date, price, additionalCommitments, usageTerms) {
   try {
-    await contract.submitTransaction("BidForData", deviceName, date, price, additionalCommitments);
+    await contract.submitTransaction(
+      "BidForData",
+      deviceName,
+      date,
+      price,
+      additionalCommitments,
+      JSON.stringify(usageTerms)
+    );
     console.log("*** Bid submitted succesfully");
   } catch (error) {
     console.error(`***Error bidding for device ${deviceName}s data:`, error);
@@ -306,9 +387,12 @@ const fabricGatewayClient = {
   acceptBid,
   getDataBidDetails,
   uploadDataAsAsset,
+  uploadWindowedDataAsAsset,
+  uploadDataAssetsBatch,
   uploadKeyPrivateData,
   getKeyPrivateData,
   transferEncKey,
+  shredExpiredKey,
 };
 
 module.exports = fabricGatewayClient;
@@ -8,7 +8,7 @@ require("./jsDocTypes");
  *
  * @param {Aggregated_IoT_Data} dataToUpload
  * @param {String} ipfsClusterApiPort
- * @returns {{status: Number, cid: string, symmetricKey: Buffer}}
+ * @returns {{status: Number, cid: string, symmetricKey: Buffer, cipherText: String}}
  */
 async function uploadToIPFS(dataToUpload, ipfsClusterApiPort) {
   // ipfs-cluster-ctl pin add --wait ... waits until the IPFS-pinning process is complete in at least 1 peer.
@@ -38,7 +38,7 @@ async function uploadToIPFS(dataToUpload, ipfsClusterApiPort) {
 
   if (cid != "error") {
     // Success
-    return { status: 0, cid: cid, symmetricKey: symmetricKey };
+    return { status: 0, cid: cid, symmetricKey: symmetricKey, cipherText: cipherText };
   } else {
     return {
       status: 2,
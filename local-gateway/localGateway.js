@@ -32,6 +32,8 @@ const FABRIC_GATEWAY_PORT = utils.envOrDefault("FABRIC_GATEWAY_PORT", 7051);
 const FABRIC_PEER_ALIAS = utils.envOrDefault("FABRIC_PEER_ALIAS", "peer0.org1.fabrictest.com");
 const FABRIC_MSPID = utils.envOrDefault("FABRIC_MSPID", "Org1MSP");
 const ORG_NUMBER = utils.envOrDefault("ORG_NUMBER", 1);
+const SENSOR_TYPE = utils.envOrDefault("SENSOR_TYPE", "energy meter");
+const SENSOR_UNIT = utils.envOrDefault("SENSOR_UNIT", "kWh");
 
 const CRYPTO_MATERIAL_PATH = path.resolve(
   __dirname,
@@ -94,6 +96,32 @@ let client;
 
 let uploadingDataInProgress = false;
 
+const UPLOAD_BATCH_SIZE = 500;
+
+/**
+ * Records entries on the ledger in one transaction, and returns the entries that were recorded. A failed batch
+ * is split in two halves that are tried on their own, so that an entry the chaincode rejects doesn't hold up the
+ * rest. Entries that fail on their own are left out, to be tried again on the next poll.
+ * @param {*} contract
+ * @param {*} entries array of { deviceName, cid, date, metadata }
+ * @param {*} symmetricKeys maps "<deviceName>_<date>" of every entry to its base64 encoded symmetric key
+ * @returns {Promise<Object[]>}
+ */
+async function uploadBatch(contract, entries, symmetricKeys) {
+  const batchKeys = Object.fromEntries(
+    entries.map(({ deviceName, date }) => [
+      `${deviceName}_${date}`,
+      symmetricKeys[`${deviceName}_${date}`],
+    ])
+  );
+  if (await fabricGatewayClient.uploadDataAssetsBatch(contract, entries, batchKeys)) return entries;
+  if (entries.length === 1) return [];
+  const half = Math.ceil(entries.length / 2);
+  const first = await uploadBatch(contract, entries.slice(0, half), symmetricKeys);
+  const second = await uploadBatch(contract, entries.slice(half), symmetricKeys);
+  return [...first, ...second];
+}
+
 async function dataUploadLifecycle(dailyStorage) {
   try {
     if (uploadingDataInProgress) {
@@ -110,6 +138,9 @@ async function dataUploadLifecycle(dailyStorage) {
 
     const { keep: _, upload: dataToUpload } = utils.filterData(dailyStorage);
 
+    // Every device's data is recorded on the ledger in one transaction once it is on the IPFS.
+    const entries = [];
+    const symmetricKeys = {};
     for (const [key, value] of Object.entries(dataToUpload)) {
       const dataDate = value[0]?.time.substring(0, 10);
       const deviceName = key;
@@ -120,7 +151,7 @@ async function dataUploadLifecycle(dailyStorage) {
         data: value,
       };
 
-      const { status, cid, symmetricKey } = await ipfsUtils.uploadToIPFS(
+      const { status, cid, symmetricKey, cipherText } = await ipfsUtils.uploadToIPFS(
         dataEntry,
         IPFSCLUSTER_API_PORT
       );
@@ -131,20 +162,45 @@ async function dataUploadLifecycle(dailyStorage) {
 
       console.log(`Data successfully uploaded to IPFS for device ${deviceName}, CID is: ${cid}`);
 
+      // Matches the compression and encryption done by ipfsUtils.uploadToIPFS.
+      const { blockCount, blockMerkleRoot } = utils.blockMerkleTree(Buffer.from(cipherText));
+      const metadata = {
+        schemaVersion: 3,
+        sensorType: SENSOR_TYPE,
+        unit: SENSOR_UNIT,
+        samplingIntervalSeconds: Math.max(1, Math.round(86400 / value.length)),
+        readingCount: value.length,
+        byteSize: Buffer.byteLength(JSON.stringify(dataEntry)),
+        compression: "deflate",
+        encryptionAlgorithm: "AES-256-ECB",
+        blockCount,
+        blockMerkleRoot,
+        readingsMerkleRoot: utils.readingsMerkleRoot(value),
+      };
+
+      entries.push({ deviceName, cid, date: dataDate, metadata });
+      symmetricKeys[`${deviceName}_${dataDate}`] = symmetricKeyBase64;
+    }
+
+    if (entries.length > 0) {
       const network = gateway.getNetwork(CHANNEL_NAME);
       const contract = network.getContract(CHAINCODE_NAME);
-      await Promise.all([
-        fabricGatewayClient.uploadDataAsAsset(contract, deviceName, cid, dataDate),
-        fabricGatewayClient.uploadKeyPrivateData(
-          contract,
-          deviceName,
-          cid,
-          dataDate,
-          symmetricKeyBase64
-        ),
-      ]);
-      delete dailyStorage[key];
-      console.log(`Data successfully uploaded to Fabric Ledger for device ${deviceName}`);
+      // The chaincode takes at most UPLOAD_BATCH_SIZE assets per transaction. Only the data of recorded
+      // assets is removed, the rest stays in dailyStorage and is uploaded again on the next poll.
+      const recorded = [];
+      for (let i = 0; i < entries.length; i += UPLOAD_BATCH_SIZE) {
+        const batch = entries.slice(i, i + UPLOAD_BATCH_SIZE);
+        recorded.push(...(await uploadBatch(contract, batch, symmetricKeys)));
+      }
+      for (const { deviceName } of recorded) {
+        delete dailyStorage[deviceName];
+      }
+      console.log(`Data successfully uploaded to Fabric Ledger for ${recorded.length} devices`);
+      if (recorded.length < entries.length) {
+        console.error(
+          `Data of ${entries.length - recorded.length} devices was not recorded, it will be uploaded again`
+        );
+      }
     }
   } catch (error) {
     console.error(
@@ -301,6 +357,7 @@ async function main() {
       const date = req.body?.date;
       const price = req.body?.price;
       const additionalCommitments = req.body?.additionalCommitments;
+      const usageTerms = req.body?.usageTerms;
       try {
         const network = gateway.getNetwork(CHANNEL_NAME);
         const contract = network.getContract(CHAINCODE_NAME);
@@ -309,7 +366,8 @@ async function main() {
           deviceName,
           date,
           price,
-          additionalCommitments
+          additionalCommitments,
+          usageTerms
         );
         res.status(200).send(result);
       } catch (error) {
@@ -354,6 +412,21 @@ async function main() {
       }
     });
 
+    app.post("/fabric/shredExpiredKey", async (req, res) => {
+      const deviceName = req.body?.deviceName;
+      const date = req.body?.date;
+      try {
+        const network = gateway.getNetwork(CHANNEL_NAME);
+        const contract = network.getContract(CHAINCODE_NAME);
+        const clientOrg = await gateway.getIdentity()?.mspId;
+        await fabricGatewayClient.shredExpiredKey(contract, clientOrg, deviceName, date);
+        res.status(200).send("Key shredded succesfully");
+      } catch (error) {
+        console.error("******** FAILED to shred key:", error);
+        res.status(500).send(`ERROR: ${error.message}`);
+      }
+    });
+
     app.listen(PORT, () => {
       console.log(`Local Gateway running on ${PORT}`);
     });
@@ -7,6 +7,8 @@ const {
   locallyStoreJSON,
   loadFileAsObject,
   deleteFile,
+  readingsMerkleRoot,
+  blockMerkleTree,
 } = require("./utils");
 const {
   mockLocalStorage,
@@ -87,6 +89,33 @@ describe("uploadToIpfs works well", () => {
   });
 });
 
+describe("readingsMerkleRoot works well", () => {
+  it("matches the Merkle tree the chaincode verifies readings against", () => {
+    const readings = [
+      { time: "2000-02-02T00:00:00.000Z", value: 1 },
+      { time: "2000-02-02T01:00:00.000Z", value: 2 },
+      { time: "2000-02-02T02:00:00.000Z", value: 3 },
+    ];
+    expect(readingsMerkleRoot(readings)).toBe(
+      "aaebe7e1ef1da116733e4604bd25f99abfc8b72e2b8ca6a94e72590dc3859c99"
+    );
+  });
+});
+
+describe("blockMerkleTree works well", () => {
+  it("matches the block Merkle tree storage challenges are answered against", () => {
+    const content = Buffer.alloc(3 * 262144 + 5, 7);
+    expect(blockMerkleTree(content)).toEqual({
+      blockCount: 4,
+      blockMerkleRoot: "df7a36c7c89b9acf0371d8abffb0c6fc87a083491944f4191000ba6a7ba9ec1a",
+    });
+  });
+
+  it("stores small content in one block", () => {
+    expect(blockMerkleTree(Buffer.from("abc")).blockCount).toBe(1);
+  });
+});
+
 // Same function used in virtualDevice.js, so use the same tests.
 describe("envOrDefault works well", () => {
   it("returns the value from process.env when available", () => {
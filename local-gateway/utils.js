@@ -1,4 +1,5 @@
 require("./jsDocTypes");
+const crypto = require("crypto");
 const fs = require("fs");
 const path = require("node:path");
 
@@ -119,6 +120,63 @@ function isTodaysDate(date) {
   return date === todaysDate;
 }
 
+// Size of the chunks IPFS splits added files into with its default chunker, each chunk is one block.
+const IPFS_BLOCK_SIZE = 262144;
+
+const sha256 = (...parts) =>
+  parts.reduce((hash, part) => hash.update(part), crypto.createHash("sha256")).digest();
+
+/**
+ * Returns the Merkle root over the given leaf contents the way the chaincode's merkleRoot builds it. As in RFC 6962,
+ * a leaf is the sha2-256 hash of 0x00 and its content, and an internal node the hash of 0x01 and its two children.
+ * Each level pairs neighbouring nodes, and the last node of a level with an odd number of nodes is carried up
+ * unchanged.
+ * @param {(String|Buffer)[]} contents
+ * @returns {Buffer}
+ */
+function merkleRoot(contents) {
+  let level = contents.map((content) => sha256(Buffer.from([0x00]), content));
+  while (level.length > 1) {
+    const next = [];
+    for (let i = 0; i < level.length; i += 2) {
+      if (i + 1 === level.length) {
+        next.push(level[i]);
+      } else {
+        next.push(sha256(Buffer.from([0x01]), level[i], level[i + 1]));
+      }
+    }
+    level = next;
+  }
+  return level[0];
+}
+
+/**
+ * Returns the hex encoded Merkle root over the readings, each serialised with JSON.stringify, the way the chaincode
+ * verifies it in VerifyReadingInclusion.
+ * @param {Object[]} readings
+ * @returns {String}
+ */
+function readingsMerkleRoot(readings) {
+  return merkleRoot(readings.map((reading) => JSON.stringify(reading))).toString("hex");
+}
+
+/**
+ * Returns the number of IPFS blocks the content is stored in and the hex encoded Merkle root over the sha2-256
+ * hashes of the blocks, which storage challenges ask the storing orgs to prove.
+ * @param {Buffer} content
+ * @returns {{blockCount: Number, blockMerkleRoot: String}}
+ */
+function blockMerkleTree(content) {
+  const blockHashes = [];
+  for (let i = 0; i < content.length; i += IPFS_BLOCK_SIZE) {
+    blockHashes.push(sha256(content.subarray(i, i + IPFS_BLOCK_SIZE)));
+  }
+  return {
+    blockCount: blockHashes.length,
+    blockMerkleRoot: merkleRoot(blockHashes).toString("hex"),
+  };
+}
+
 const utils = {
   processDataInput,
   envOrDefault,
@@ -126,6 +184,8 @@ const utils = {
   loadFileAsObject,
   deleteFile,
   filterData,
+  readingsMerkleRoot,
+  blockMerkleTree,
 };
 
 module.exports = utils;